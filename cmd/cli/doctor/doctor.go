@@ -0,0 +1,123 @@
+package doctor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"time"
+	
+	"github.com/pion/webrtc/v3"
+	"github.com/spf13/cobra"
+	"github.com/submlit21/stardewl-ink/core"
+)
+
+var (
+	jsonOutput  bool
+	modsPath    string
+	gameAddr    string
+	iceServers  []string
+	checkTimeout time.Duration
+)
+
+var DoctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check your setup for common problems",
+	Long: `Run every environment check in one pass and explain how to fix
+anything that fails: signaling server, room creation, WebSocket join,
+STUN/TURN reachability, Mods folder, local game port and clock skew.
+
+Send a screenshot of the output when asking for help.
+
+Examples:
+  # Check against the default signaling server
+  stardewl doctor
+  
+  # Check a specific server
+  stardewl doctor --signaling ws://example.com:8080/ws
+  
+  # Machine-readable output
+  stardewl doctor --json`,
+	Args: cobra.NoArgs,
+	RunE: runDoctor,
+}
+
+func init() {
+	DoctorCmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the results as JSON")
+	DoctorCmd.Flags().StringVar(&modsPath, "mods", "", "Mods folder path (default: auto-detect)")
+	DoctorCmd.Flags().StringVar(&gameAddr, "game-addr", fmt.Sprintf("127.0.0.1:%d", core.DefaultGamePort), "Local game address to check")
+	DoctorCmd.Flags().StringSliceVar(&iceServers, "ice-server", nil, "Additional STUN/TURN server to check, e.g. stun:host:3478")
+	DoctorCmd.Flags().DurationVar(&checkTimeout, "check-timeout", 5*time.Second, "Timeout for each network check")
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	signalingURL, _ := cmd.Root().PersistentFlags().GetString("signaling")
+	verbose, _ := cmd.Root().PersistentFlags().GetBool("verbose")
+	
+	// 检查过程中的连接日志只在--verbose时显示，输出保持一屏
+	if !verbose {
+		log.SetOutput(io.Discard)
+	}
+	
+	config := core.DoctorConfig{
+		SignalingURL: signalingURL,
+		ModsPath:     modsPath,
+		GameAddr:     gameAddr,
+		Timeout:      checkTimeout,
+	}
+	for _, server := range iceServers {
+		config.ICEServers = append(config.ICEServers, webrtc.ICEServer{URLs: []string{server}})
+	}
+	
+	if !jsonOutput {
+		fmt.Println("=== Stardewl Doctor ===")
+		fmt.Printf("Version: %s, OS: %s/%s\n", cmd.Root().Version, runtime.GOOS, runtime.GOARCH)
+		fmt.Printf("Signaling server: %s\n", signalingURL)
+		fmt.Printf("Time: %s\n", time.Now().Format(time.RFC3339))
+		fmt.Println()
+	}
+	
+	results := core.RunDoctor(context.Background(), config)
+	
+	failed := 0
+	for _, result := range results {
+		if result.Status == core.CheckFail {
+			failed++
+		}
+	}
+	
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(results); err != nil {
+			return err
+		}
+	} else {
+		warned := 0
+		for _, result := range results {
+			switch result.Status {
+			case core.CheckPass:
+				fmt.Printf("✅ PASS  %-17s %s\n", result.Name, result.Detail)
+			case core.CheckWarn:
+				warned++
+				fmt.Printf("⚠️  WARN  %-17s %s\n", result.Name, result.Detail)
+			default:
+				fmt.Printf("❌ FAIL  %-17s %s\n", result.Name, result.Detail)
+			}
+			if result.Remedy != "" {
+				fmt.Printf("         → %s\n", result.Remedy)
+			}
+		}
+		
+		fmt.Println()
+		fmt.Printf("%d passed, %d warnings, %d failed\n", len(results)-warned-failed, warned, failed)
+	}
+	
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
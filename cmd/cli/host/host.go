@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 	
@@ -14,6 +15,24 @@ import (
 
 var (
 	modsPath string
+	tunnel   bool
+	gameAddr string
+	lanMode  bool
+	manual   bool
+
+	reconnectAttempts int
+	reconnectGrace    time.Duration
+
+	heartbeatInterval time.Duration
+	heartbeatTimeout  time.Duration
+	statsInterval     time.Duration
+	bulkBandwidth     int64
+
+	icePolicy         string
+	networkTypes      []string
+	interfaces        []string
+	excludeInterfaces []string
+	portRange         string
 )
 
 var HostCmd = &cobra.Command{
@@ -32,13 +51,40 @@ Examples:
   stardewl host --mods /path/to/Mods
   
   # Create a room with 60-second timeout
-  stardewl host --timeout 60`,
+  stardewl host --timeout 60
+  
+  # Forward to a game server on a non-default port
+  stardewl host --game-addr 127.0.0.1:24643
+  
+  # Everyone is on the same LAN: skip STUN/TURN, IPv4 only
+  stardewl host --ice-policy host --network-types udp4
+  
+  # Same LAN without a signaling server (players run "stardewl join")
+  stardewl host --lan
+  
+  # No signaling server at all: exchange connection blobs over chat
+  stardewl host --manual`,
 	Args: cobra.NoArgs,
 	RunE: runHost,
 }
 
 func init() {
 	HostCmd.Flags().StringVar(&modsPath, "mods", "", "Mods folder path (default: auto-detect)")
+	HostCmd.Flags().BoolVar(&tunnel, "tunnel", true, "Tunnel the game port to joining players")
+	HostCmd.Flags().BoolVar(&lanMode, "lan", false, "Announce the room on the local network instead of using a signaling server")
+	HostCmd.Flags().BoolVar(&manual, "manual", false, "Exchange connection blobs by copy/paste instead of using a signaling server (one player)")
+	HostCmd.Flags().StringVar(&gameAddr, "game-addr", fmt.Sprintf("127.0.0.1:%d", core.DefaultGamePort), "Local Stardew Valley server address")
+	HostCmd.Flags().IntVar(&reconnectAttempts, "reconnect-attempts", core.DefaultReconnectPolicy().MaxAttempts, "ICE restart attempts after the connection drops (0 disables reconnection)")
+	HostCmd.Flags().DurationVar(&reconnectGrace, "reconnect-grace", core.DefaultReconnectPolicy().GracePeriod, "How long a dropped connection may recover on its own before restarting ICE")
+	HostCmd.Flags().DurationVar(&heartbeatInterval, "heartbeat-interval", core.DefaultHeartbeatInterval, "How often to ping the other side (heartbeat_interval)")
+	HostCmd.Flags().DurationVar(&heartbeatTimeout, "heartbeat-timeout", 0, "Declare the other side gone after this long without any message (default: 3 heartbeat intervals)")
+	HostCmd.Flags().DurationVar(&statsInterval, "stats-interval", 30*time.Second, "How often to print connection statistics (0 disables)")
+	HostCmd.Flags().Int64Var(&bulkBandwidth, "bulk-bandwidth", 0, "Bandwidth cap for mod/save transfers in KiB/s, game traffic always goes first (0 = unlimited)")
+	HostCmd.Flags().StringVar(&icePolicy, "ice-policy", core.ICEPolicyAll, "ICE candidates to use: all, relay (TURN only) or host (same LAN only)")
+	HostCmd.Flags().StringSliceVar(&networkTypes, "network-types", nil, "Allowed network types, e.g. udp4,tcp4 (default: all)")
+	HostCmd.Flags().StringSliceVar(&interfaces, "interfaces", nil, "Only gather candidates on these network interfaces")
+	HostCmd.Flags().StringSliceVar(&excludeInterfaces, "exclude-interfaces", nil, "Never gather candidates on these network interfaces")
+	HostCmd.Flags().StringVar(&portRange, "port-range", "", "Local UDP port range for ICE, e.g. 50000-50100")
 }
 
 func runHost(cmd *cobra.Command, args []string) error {
@@ -46,28 +92,165 @@ func runHost(cmd *cobra.Command, args []string) error {
 	timeout, _ := cmd.Root().PersistentFlags().GetInt("timeout")
 	signalingURL, _ := cmd.Root().PersistentFlags().GetString("signaling")
 	
+	if lanMode && manual {
+		return fmt.Errorf("--lan and --manual cannot be used together")
+	}
+	
+	iceConfig, err := core.NewICEConfig(icePolicy, networkTypes, interfaces, excludeInterfaces, portRange)
+	if err != nil {
+		return err
+	}
+	
 	fmt.Println("=== Host Mode ===")
-	fmt.Printf("Signaling server: %s\n", signalingURL)
+	if lanMode {
+		fmt.Println("Signaling: local network (no signaling server)")
+	} else if manual {
+		fmt.Println("Signaling: manual copy/paste (no signaling server)")
+	} else {
+		fmt.Printf("Signaling server: %s\n", signalingURL)
+	}
 	
 	// Create P2P connector configuration
 	config := core.P2PConfig{
 		SignalingURL: signalingURL,
 		IsHost:       true,
 		ModsPath:     modsPath,
-		ICEServers: []webrtc.ICEServer{
-			{URLs: []string{"stun:stun.l.google.com:19302"}},
-			{URLs: []string{"stun:stun1.l.google.com:19302"}},
-			{URLs: []string{"stun:stun2.l.google.com:19302"}},
-			{URLs: []string{"stun:stun3.l.google.com:19302"}},
-			{URLs: []string{"stun:stun4.l.google.com:19302"}},
+		ICE:          iceConfig,
+		Tunnel: core.TunnelConfig{
+			Enabled:  tunnel,
+			GameAddr: gameAddr,
+		},
+		Bulk: core.BulkConfig{
+			MaxBandwidth: bulkBandwidth * 1024,
+		},
+		Heartbeat: core.HeartbeatConfig{
+			Interval: heartbeatInterval,
+			Timeout:  heartbeatTimeout,
 		},
+		Reconnect: reconnectPolicy(),
 	}
 	
 	// Auto-generate room ID
 	roomID := core.GenerateRoomID()
 	config.RoomID = roomID
 
-	// First create room on signaling server (with retry)
+	var manualSignaling *core.ManualSignaling
+	if manual {
+		// Exchange the offer/answer over chat; SDP blobs carry all ICE candidates
+		manualSignaling = core.NewManualSignaling(true, os.Stdin, os.Stdout)
+		config.Signaling = manualSignaling
+		config.ICEServers = core.GetDefaultICEServers()
+		fmt.Println("Gathering network candidates, this can take a few seconds...")
+	} else if lanMode {
+		// Act as the signaling server for our own room on the LAN
+		lanHost, err := core.NewLANHost(roomID)
+		if err != nil {
+			return fmt.Errorf("failed to open LAN room: %v", err)
+		}
+		config.Signaling = lanHost
+		fmt.Printf("LAN room open on port %d (players on this network can run \"stardewl join\")\n", lanHost.Port())
+	} else {
+		// First create room on signaling server (with retry)
+		code, iceServers, err := createRoom(signalingURL)
+		if err != nil {
+			return err
+		}
+		
+		// Use server-returned room ID
+		config.RoomID = code
+		roomID = code
+		
+		// Use the STUN/TURN servers issued for this room, plus public STUN
+		config.ICEServers = core.MergeICEServers(iceServers, core.GetDefaultICEServers())
+	}
+
+	if !manual {
+		fmt.Printf("Connection code: %s\n", roomID)
+	}
+	if tunnel {
+		fmt.Printf("Game tunnel: forwarding to %s (host your farm via Co-op first)\n", gameAddr)
+	}
+	fmt.Println("Waiting for client connection...")
+	
+	// Create P2P connector
+	connector, err := core.NewP2PConnector(config)
+	if err != nil {
+		return fmt.Errorf("failed to create P2P connector: %v", err)
+	}
+	defer connector.Close()
+	
+	connector.SetHandshakeFailedHandler(func(clientID string, err *core.HandshakeError) {
+		fmt.Printf("❌ Incompatible peer %s: %s\n", clientID, err.Message)
+		if err.Remote != nil {
+			fmt.Printf("   Peer runs stardewl %s, this is stardewl %s\n", err.Remote.AppVersion, core.AppVersion)
+		}
+	})
+	
+	connector.SetReconnectCallbacks(
+		func(clientID string, attempt int) {
+			fmt.Printf("⚠️  Connection to %s lost, reconnecting (attempt %d/%d)...\n", clientID, attempt, reconnectAttempts)
+		},
+		func(clientID string) {
+			fmt.Printf("✅ Reconnected to %s\n", clientID)
+		},
+	)
+	
+	// Start connection
+	if err := connector.Start(); err != nil {
+		return fmt.Errorf("failed to start P2P connection: %v", err)
+	}
+	
+	// Don't compete with the blob exchange for stdin
+	if manualSignaling != nil {
+		<-manualSignaling.Done()
+	}
+	
+	if statsInterval > 0 {
+		go printStats(connector, statsInterval)
+	}
+	
+	// Wait based on timeout setting
+	if timeout > 0 {
+		fmt.Printf("\nWaiting for %d seconds (timeout)...\n", timeout)
+		time.Sleep(time.Duration(timeout) * time.Second)
+		fmt.Println("Timeout reached, exiting...")
+	} else {
+		fmt.Print("\nPress Enter to exit...")
+		var input string
+		fmt.Scanln(&input)
+	}
+	
+	return nil
+}
+
+// printStats 定期打印与每个对端的连接统计
+func printStats(connector *core.P2PConnector, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	
+	for range ticker.C {
+		for _, stats := range connector.Stats() {
+			fmt.Printf("📊 %s\n", stats)
+			if pair := stats.Connection.CandidatePair; pair != nil {
+				fmt.Printf("   path: %s\n", pair)
+			}
+			for _, channel := range stats.Connection.Channels {
+				fmt.Printf("   %s\n", channel)
+			}
+		}
+	}
+}
+
+// reconnectPolicy 根据命令行参数生成重连策略
+func reconnectPolicy() core.ReconnectPolicy {
+	policy := core.DefaultReconnectPolicy()
+	policy.MaxAttempts = reconnectAttempts
+	policy.GracePeriod = reconnectGrace
+	return policy
+}
+
+// createRoom 在信令服务器上创建房间，返回连接码和为该房间签发的ICE服务器
+func createRoom(signalingURL string) (string, []webrtc.ICEServer, error) {
 	fmt.Println("Creating room on signaling server...")
 	createRoomURL := strings.Replace(signalingURL, "ws://", "http://", 1)
 	createRoomURL = strings.Replace(createRoomURL, "/ws", "/create", 1)
@@ -97,52 +280,23 @@ func runHost(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		fmt.Printf("❌ Failed to create room (after 3 attempts): %v\n", err)
 		fmt.Println("Please ensure signaling server is running: ./dist/stardewl-signaling")
-		return fmt.Errorf("failed to create room: %v", err)
+		return "", nil, fmt.Errorf("failed to create room: %v", err)
 	}
 	defer resp.Body.Close()
 	
 	if resp.StatusCode != 200 {
 		fmt.Printf("❌ Failed to create room, status code: %d\n", resp.StatusCode)
-		return fmt.Errorf("failed to create room, status: %d", resp.StatusCode)
+		return "", nil, fmt.Errorf("failed to create room, status: %d", resp.StatusCode)
 	}
 	
 	var roomResponse struct {
-		Code string `json:"code"`
+		Code       string             `json:"code"`
+		ICEServers []webrtc.ICEServer `json:"ice_servers"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&roomResponse); err != nil {
 		fmt.Printf("❌ Failed to parse room response: %v\n", err)
-		return fmt.Errorf("failed to parse room response: %v", err)
+		return "", nil, fmt.Errorf("failed to parse room response: %v", err)
 	}
 	
-	// Use server-returned room ID
-	config.RoomID = roomResponse.Code
-	roomID = roomResponse.Code
-
-	fmt.Printf("Connection code: %s\n", roomID)
-	fmt.Println("Waiting for client connection...")
-	
-	// Create P2P connector
-	connector, err := core.NewP2PConnector(config)
-	if err != nil {
-		return fmt.Errorf("failed to create P2P connector: %v", err)
-	}
-	defer connector.Close()
-	
-	// Start connection
-	if err := connector.Start(); err != nil {
-		return fmt.Errorf("failed to start P2P connection: %v", err)
-	}
-	
-	// Wait based on timeout setting
-	if timeout > 0 {
-		fmt.Printf("\nWaiting for %d seconds (timeout)...\n", timeout)
-		time.Sleep(time.Duration(timeout) * time.Second)
-		fmt.Println("Timeout reached, exiting...")
-	} else {
-		fmt.Print("\nPress Enter to exit...")
-		var input string
-		fmt.Scanln(&input)
-	}
-	
-	return nil
-}
\ No newline at end of file
+	return roomResponse.Code, roomResponse.ICEServers, nil
+}
@@ -15,7 +15,28 @@ import (
 )
 
 var (
-	modsPath string
+	modsPath     string
+	tunnel       bool
+	listenAddr   string
+	udpMode      string
+	lanDiscovery bool
+	resumeToken  string
+	lanMode      bool
+	manual       bool
+
+	reconnectAttempts int
+	reconnectGrace    time.Duration
+
+	heartbeatInterval time.Duration
+	heartbeatTimeout  time.Duration
+	statsInterval     time.Duration
+	bulkBandwidth     int64
+
+	icePolicy         string
+	networkTypes      []string
+	interfaces        []string
+	excludeInterfaces []string
+	portRange         string
 )
 
 var JoinCmd = &cobra.Command{
@@ -24,38 +45,251 @@ var JoinCmd = &cobra.Command{
 	Long: `Run in client mode to join a multiplayer room.
 
 This command connects to an existing room using the connection code
-provided by the host.
+provided by the host. Without a connection code (and without --signaling)
+it looks for a room hosted with "stardewl host --lan" on the local network.
 
 Examples:
   # Join a room with connection code 123456
   stardewl join 123456
   
+  # Join the room hosted on this LAN (no signaling server needed)
+  stardewl join
+  
   # Join with specific mods path
   stardewl join 123456 --mods /path/to/Mods
   
   # Join with 30-second timeout
-  stardewl join 123456 --timeout 30`,
-	Args: cobra.ExactArgs(1),
+  stardewl join 123456 --timeout 30
+  
+  # Expose the host's game on a different local port
+  stardewl join 123456 --listen 127.0.0.1:24643
+  
+  # Rejoin your previous slot after a crash or network change
+  stardewl join 123456 --resume <token>
+  
+  # Restricted network: relay everything through TURN
+  stardewl join 123456 --ice-policy relay
+  
+  # Host used "stardewl host --manual": paste their connection blob
+  stardewl join --manual`,
+	Args: cobra.MaximumNArgs(1),
 	RunE: runJoin,
 }
 
 func init() {
 	JoinCmd.Flags().StringVar(&modsPath, "mods", "", "Mods folder path (default: auto-detect)")
+	JoinCmd.Flags().BoolVar(&tunnel, "tunnel", true, "Expose the host's game on a local port")
+	JoinCmd.Flags().BoolVar(&lanDiscovery, "lan-discovery", false, "Make the host's farm appear in the game's \"Join LAN game\" list (binds the UDP tunnel port on all interfaces, reachable from your LAN)")
+	JoinCmd.Flags().StringVar(&udpMode, "udp-mode", string(core.UDPRelayUnreliable), "UDP relay channel mode: unreliable or reliable")
+	JoinCmd.Flags().StringVar(&listenAddr, "listen", fmt.Sprintf("127.0.0.1:%d", core.DefaultGamePort), "Local address the game connects to via \"Join LAN game\"")
+	JoinCmd.Flags().BoolVar(&lanMode, "lan", false, "Find the room on the local network instead of using a signaling server")
+	JoinCmd.Flags().BoolVar(&manual, "manual", false, "Paste the host's connection blob instead of using a signaling server")
+	JoinCmd.Flags().StringVar(&resumeToken, "resume", "", "Resume token printed by a previous join, to rejoin the same slot")
+	JoinCmd.Flags().IntVar(&reconnectAttempts, "reconnect-attempts", core.DefaultReconnectPolicy().MaxAttempts, "ICE restart attempts after the connection drops (0 disables reconnection)")
+	JoinCmd.Flags().DurationVar(&reconnectGrace, "reconnect-grace", core.DefaultReconnectPolicy().GracePeriod, "How long a dropped connection may recover on its own before restarting ICE")
+	JoinCmd.Flags().DurationVar(&heartbeatInterval, "heartbeat-interval", core.DefaultHeartbeatInterval, "How often to ping the other side (heartbeat_interval)")
+	JoinCmd.Flags().DurationVar(&heartbeatTimeout, "heartbeat-timeout", 0, "Declare the other side gone after this long without any message (default: 3 heartbeat intervals)")
+	JoinCmd.Flags().DurationVar(&statsInterval, "stats-interval", 30*time.Second, "How often to print connection statistics (0 disables)")
+	JoinCmd.Flags().Int64Var(&bulkBandwidth, "bulk-bandwidth", 0, "Bandwidth cap for mod/save transfers in KiB/s, game traffic always goes first (0 = unlimited)")
+	JoinCmd.Flags().StringVar(&icePolicy, "ice-policy", core.ICEPolicyAll, "ICE candidates to use: all, relay (TURN only) or host (same LAN only)")
+	JoinCmd.Flags().StringSliceVar(&networkTypes, "network-types", nil, "Allowed network types, e.g. udp4,tcp4 (default: all)")
+	JoinCmd.Flags().StringSliceVar(&interfaces, "interfaces", nil, "Only gather candidates on these network interfaces")
+	JoinCmd.Flags().StringSliceVar(&excludeInterfaces, "exclude-interfaces", nil, "Never gather candidates on these network interfaces")
+	JoinCmd.Flags().StringVar(&portRange, "port-range", "", "Local UDP port range for ICE, e.g. 50000-50100")
 }
 
 func runJoin(cmd *cobra.Command, args []string) error {
-	connectionID := args[0]
-	
 	// Get global flags
 	timeout, _ := cmd.Root().PersistentFlags().GetInt("timeout")
 	signalingURL, _ := cmd.Root().PersistentFlags().GetString("signaling")
 	
+	connectionID := ""
+	if len(args) > 0 {
+		connectionID = args[0]
+	}
+	
+	if manual && (lanMode || connectionID != "") {
+		return fmt.Errorf("--manual does not use a connection code or --lan")
+	}
+	
+	// No connection code and no signaling server given: look on the LAN
+	if connectionID == "" && !manual && !cmd.Root().PersistentFlags().Changed("signaling") {
+		lanMode = true
+	}
+	if connectionID == "" && !lanMode && !manual {
+		return fmt.Errorf("connection code required when using a signaling server")
+	}
+	
+	relayMode, err := core.ParseUDPRelayMode(udpMode)
+	if err != nil {
+		return err
+	}
+	
+	iceConfig, err := core.NewICEConfig(icePolicy, networkTypes, interfaces, excludeInterfaces, portRange)
+	if err != nil {
+		return err
+	}
+	
 	fmt.Println("=== Client Mode ===")
-	fmt.Printf("Connection code: %s\n", connectionID)
-	fmt.Printf("Signaling server: %s\n", signalingURL)
-	fmt.Println("Connecting to host...")
-	fmt.Println("(Press Ctrl+C to exit)")
 	
+	var lanClient *core.LANClient
+	var iceServers []webrtc.ICEServer
+	var manualSignaling *core.ManualSignaling
+	if manual {
+		fmt.Println("Signaling: manual copy/paste (no signaling server)")
+		manualSignaling = core.NewManualSignaling(false, os.Stdin, os.Stdout)
+		iceServers = core.GetDefaultICEServers()
+		connectionID = "manual"
+	} else if lanMode {
+		fmt.Println("Signaling: local network (no signaling server)")
+		room, err := findLANRoom(connectionID)
+		if err != nil {
+			return err
+		}
+		connectionID = room.RoomID
+		
+		lanClient, err = core.DialLANRoom(room)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Found room %s on %s (%s)\n", room.RoomID, room.Name, room.Addr)
+	} else {
+		fmt.Printf("Connection code: %s\n", connectionID)
+		fmt.Printf("Signaling server: %s\n", signalingURL)
+		fmt.Println("Connecting to host...")
+		fmt.Println("(Press Ctrl+C to exit)")
+		
+		issued, err := verifyRoom(signalingURL, connectionID)
+		if err != nil {
+			return err
+		}
+		
+		// Use the STUN/TURN servers issued for this room, plus public STUN
+		iceServers = core.MergeICEServers(issued, core.GetDefaultICEServers())
+	}
+	
+	// Create P2P connector configuration
+	config := core.P2PConfig{
+		SignalingURL: signalingURL,
+		RoomID:       connectionID,
+		IsHost:       false,
+		ModsPath:     modsPath,
+		ICEServers:   iceServers,
+		ICE:          iceConfig,
+		Tunnel: core.TunnelConfig{
+			Enabled:      tunnel,
+			ListenAddr:   listenAddr,
+			UDPMode:      relayMode,
+			LANDiscovery: lanDiscovery,
+		},
+		Bulk: core.BulkConfig{
+			MaxBandwidth: bulkBandwidth * 1024,
+		},
+		Heartbeat: core.HeartbeatConfig{
+			Interval: heartbeatInterval,
+			Timeout:  heartbeatTimeout,
+		},
+		Reconnect:   reconnectPolicy(),
+		ResumeToken: resumeToken,
+	}
+	if lanClient != nil {
+		config.Signaling = lanClient
+	}
+	if manualSignaling != nil {
+		config.Signaling = manualSignaling
+	}
+	
+	// Create P2P connector
+	connector, err := core.NewP2PConnector(config)
+	if err != nil {
+		return fmt.Errorf("failed to create P2P connector: %v", err)
+	}
+	defer connector.Close()
+	
+	connector.SetHandshakeFailedHandler(func(clientID string, err *core.HandshakeError) {
+		fmt.Printf("❌ Incompatible peer %s: %s\n", clientID, err.Message)
+		if err.Remote != nil {
+			fmt.Printf("   Peer runs stardewl %s, this is stardewl %s\n", err.Remote.AppVersion, core.AppVersion)
+		}
+	})
+	
+	connector.SetReconnectCallbacks(
+		func(clientID string, attempt int) {
+			fmt.Printf("⚠️  Connection to %s lost, reconnecting (attempt %d/%d)...\n", clientID, attempt, reconnectAttempts)
+		},
+		func(clientID string) {
+			fmt.Printf("✅ Reconnected to %s\n", clientID)
+		},
+	)
+	
+	// Start connection
+	if err := connector.Start(); err != nil {
+		return fmt.Errorf("failed to start P2P connection: %v", err)
+	}
+	
+	if token := connector.ResumeToken(); token != "" {
+		fmt.Printf("Resume token: %s\n", token)
+		fmt.Printf("If you get disconnected, rejoin the same slot with: stardewl join %s --resume %s\n", connectionID, token)
+	}
+	
+	if tunnel {
+		if lanDiscovery {
+			fmt.Println("Game tunnel: once connected, the host's farm shows up under \"Join LAN game\"")
+		} else {
+			fmt.Printf("Game tunnel: once connected, use \"Join LAN game\" and connect to %s\n", listenAddr)
+		}
+	}
+	
+	// Don't compete with the blob exchange for stdin
+	if manualSignaling != nil {
+		<-manualSignaling.Done()
+	}
+	
+	if statsInterval > 0 {
+		go printStats(connector, statsInterval)
+	}
+	
+	// Wait based on timeout setting
+	if timeout > 0 {
+		fmt.Printf("\nWaiting for %d seconds (timeout)...\n", timeout)
+		time.Sleep(time.Duration(timeout) * time.Second)
+		fmt.Println("Timeout reached, exiting...")
+	} else {
+		fmt.Print("\nPress Enter to exit...")
+		bufio.NewReader(os.Stdin).ReadBytes('\n')
+	}
+	
+	return nil
+}
+
+// printStats 定期打印与每个对端的连接统计
+func printStats(connector *core.P2PConnector, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	
+	for range ticker.C {
+		for _, stats := range connector.Stats() {
+			fmt.Printf("📊 %s\n", stats)
+			if pair := stats.Connection.CandidatePair; pair != nil {
+				fmt.Printf("   path: %s\n", pair)
+			}
+			for _, channel := range stats.Connection.Channels {
+				fmt.Printf("   %s\n", channel)
+			}
+		}
+	}
+}
+
+// reconnectPolicy 根据命令行参数生成重连策略
+func reconnectPolicy() core.ReconnectPolicy {
+	policy := core.DefaultReconnectPolicy()
+	policy.MaxAttempts = reconnectAttempts
+	policy.GracePeriod = reconnectGrace
+	return policy
+}
+
+// verifyRoom 检查房间是否存在，返回为该房间签发的ICE服务器
+func verifyRoom(signalingURL, connectionID string) ([]webrtc.ICEServer, error) {
 	// Verify room exists
 	fmt.Println("Verifying room exists...")
 	checkRoomURL := strings.Replace(signalingURL, "ws://", "http://", 1)
@@ -64,17 +298,17 @@ func runJoin(cmd *cobra.Command, args []string) error {
 	resp, err := http.Get(checkRoomURL)
 	if err != nil {
 		fmt.Println("Please ensure signaling server is running: ./dist/stardewl-signaling")
-		return fmt.Errorf("failed to connect to signaling server: %v", err)
+		return nil, fmt.Errorf("failed to connect to signaling server: %v", err)
 	}
 	defer resp.Body.Close()
 	
 	if resp.StatusCode == 404 {
 		fmt.Printf("❌ Room does not exist: %s\n", connectionID)
 		fmt.Println("Please check connection code, or wait for host to create room")
-		return fmt.Errorf("room not found")
+		return nil, fmt.Errorf("room not found")
 	} else if resp.StatusCode != 200 {
 		fmt.Printf("❌ Failed to verify room, status code: %d\n", resp.StatusCode)
-		return fmt.Errorf("room verification failed")
+		return nil, fmt.Errorf("room verification failed")
 	}
 	
 	var roomResponse struct {
@@ -82,11 +316,13 @@ func runJoin(cmd *cobra.Command, args []string) error {
 		Code    string `json:"code"`
 		Ready   bool   `json:"ready"`
 		Message string `json:"message,omitempty"`
+		// STUN/TURN servers issued for this room
+		ICEServers []webrtc.ICEServer `json:"ice_servers"`
 	}
 	
 	if err := json.NewDecoder(resp.Body).Decode(&roomResponse); err != nil {
 		fmt.Printf("❌ Failed to parse room response: %v\n", err)
-		return fmt.Errorf("failed to parse room response: %v", err)
+		return nil, fmt.Errorf("failed to parse room response: %v", err)
 	}
 	
 	if roomResponse.Ready {
@@ -96,42 +332,37 @@ func runJoin(cmd *cobra.Command, args []string) error {
 		fmt.Println("Please wait for host to connect, or check if host is running")
 	}
 	
-	// Create P2P connector configuration
-	config := core.P2PConfig{
-		SignalingURL: signalingURL,
-		RoomID:       connectionID,
-		IsHost:       false,
-		ModsPath:     modsPath,
-		ICEServers: []webrtc.ICEServer{
-			{URLs: []string{"stun:stun.l.google.com:19302"}},
-			{URLs: []string{"stun:stun1.l.google.com:19302"}},
-			{URLs: []string{"stun:stun2.l.google.com:19302"}},
-			{URLs: []string{"stun:stun3.l.google.com:19302"}},
-			{URLs: []string{"stun:stun4.l.google.com:19302"}},
-		},
-	}
-	
-	// Create P2P connector
-	connector, err := core.NewP2PConnector(config)
+	return roomResponse.ICEServers, nil
+}
+
+// findLANRoom 在局域网中查找房间，connectionID为空时要求只有一个房间
+func findLANRoom(connectionID string) (core.LANRoom, error) {
+	fmt.Println("Looking for rooms on the local network...")
+	rooms, err := core.DiscoverLANRooms(3 * time.Second)
 	if err != nil {
-		return fmt.Errorf("failed to create P2P connector: %v", err)
+		return core.LANRoom{}, fmt.Errorf("LAN discovery failed: %v", err)
 	}
-	defer connector.Close()
 	
-	// Start connection
-	if err := connector.Start(); err != nil {
-		return fmt.Errorf("failed to start P2P connection: %v", err)
+	if connectionID != "" {
+		for _, room := range rooms {
+			if room.RoomID == connectionID {
+				return room, nil
+			}
+		}
+		return core.LANRoom{}, fmt.Errorf("room %s not found on the local network", connectionID)
 	}
 	
-	// Wait based on timeout setting
-	if timeout > 0 {
-		fmt.Printf("\nWaiting for %d seconds (timeout)...\n", timeout)
-		time.Sleep(time.Duration(timeout) * time.Second)
-		fmt.Println("Timeout reached, exiting...")
-	} else {
-		fmt.Print("\nPress Enter to exit...")
-		bufio.NewReader(os.Stdin).ReadBytes('\n')
+	switch len(rooms) {
+	case 0:
+		fmt.Println("❌ No room found. Ask the host to run \"stardewl host --lan\" on this network")
+		return core.LANRoom{}, fmt.Errorf("no LAN room found")
+	case 1:
+		return rooms[0], nil
 	}
 	
-	return nil
-}
\ No newline at end of file
+	fmt.Println("Several rooms found, choose one with \"stardewl join <code> --lan\":")
+	for _, room := range rooms {
+		fmt.Printf("  %s  (%s, %s)\n", room.RoomID, room.Name, room.Addr)
+	}
+	return core.LANRoom{}, fmt.Errorf("multiple LAN rooms found")
+}
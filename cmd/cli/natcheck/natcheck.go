@@ -0,0 +1,105 @@
+package natcheck
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+	
+	"github.com/pion/webrtc/v3"
+	"github.com/spf13/cobra"
+	"github.com/submlit21/stardewl-ink/core"
+)
+
+var (
+	jsonOutput   bool
+	stunServers  []string
+	probeTimeout time.Duration
+)
+
+var NatCheckCmd = &cobra.Command{
+	Use:   "nat-check",
+	Short: "Check whether your network allows direct connections",
+	Long: `Query several STUN servers to classify your NAT and tell whether
+you will connect to other players directly or need a TURN relay.
+
+Mapping behavior is detected by comparing the public address seen by
+different servers. Filtering behavior needs a server that supports
+RFC 5780 (CHANGE-REQUEST) and is reported as unknown otherwise.
+
+Examples:
+  # Check against the default STUN servers
+  stardewl nat-check
+  
+  # Use specific servers
+  stardewl nat-check --stun stun:stun.example.com:3478,stun:stun2.example.com:3478
+  
+  # Machine-readable output
+  stardewl nat-check --json`,
+	Args: cobra.NoArgs,
+	RunE: runNatCheck,
+}
+
+func init() {
+	NatCheckCmd.Flags().BoolVar(&jsonOutput, "json", false, "Print the report as JSON")
+	NatCheckCmd.Flags().StringSliceVar(&stunServers, "stun", nil, "STUN servers to query, e.g. stun:host:3478 (default: built-in ICE servers)")
+	NatCheckCmd.Flags().DurationVar(&probeTimeout, "probe-timeout", 2*time.Second, "How long to wait for each STUN server")
+}
+
+func runNatCheck(cmd *cobra.Command, args []string) error {
+	config := core.NATCheckConfig{Timeout: probeTimeout}
+	for _, server := range stunServers {
+		config.Servers = append(config.Servers, webrtc.ICEServer{URLs: []string{server}})
+	}
+	
+	if !jsonOutput {
+		fmt.Println("=== NAT Check ===")
+		fmt.Println("Querying STUN servers...")
+	}
+	
+	report, err := core.CheckNAT(context.Background(), config)
+	if err != nil {
+		return fmt.Errorf("NAT check failed: %w", err)
+	}
+	
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	
+	fmt.Println()
+	for _, probe := range report.Probes {
+		if probe.Error != "" {
+			fmt.Printf("  ✗ %s: %s\n", probe.Server, probe.Error)
+			continue
+		}
+		fmt.Printf("  ✓ %s: mapped to %s (%s)", probe.Server, probe.Mapped, probe.RTT.Round(time.Millisecond))
+		if probe.OtherAddress != "" {
+			fmt.Print(", RFC 5780")
+		}
+		fmt.Println()
+	}
+	
+	fmt.Println()
+	fmt.Printf("Local address:  %s\n", report.LocalAddress)
+	if report.PublicAddress != "" {
+		fmt.Printf("Public address: %s\n", report.PublicAddress)
+	}
+	fmt.Printf("Mapping:        %s\n", report.Mapping)
+	fmt.Printf("Filtering:      %s\n", report.Filtering)
+	fmt.Printf("NAT type:       %s\n", report.Type)
+	fmt.Println()
+	
+	switch report.Verdict {
+	case core.NATVerdictDirect:
+		fmt.Printf("✅ %s\n", report.Summary)
+	case core.NATVerdictLikelyDirect, core.NATVerdictUnknown:
+		fmt.Printf("⚠️  %s\n", report.Summary)
+	default:
+		fmt.Printf("❌ %s\n", report.Summary)
+	}
+	
+	return nil
+}
@@ -2,10 +2,13 @@ package cli
 
 import (
 	"github.com/spf13/cobra"
+	"github.com/submlit21/stardewl-ink/cmd/cli/doctor"
 	"github.com/submlit21/stardewl-ink/cmd/cli/host"
 	"github.com/submlit21/stardewl-ink/cmd/cli/join"
 	"github.com/submlit21/stardewl-ink/cmd/cli/mods"
+	"github.com/submlit21/stardewl-ink/cmd/cli/natcheck"
 	"github.com/submlit21/stardewl-ink/cmd/cli/signaling"
+	"github.com/submlit21/stardewl-ink/core"
 )
 
 var (
@@ -32,12 +35,18 @@ Examples:
   # Run signaling server
   stardewl signaling
   
+  # Check whether you can connect directly
+  stardewl nat-check
+  
+  # Diagnose connection problems
+  stardewl doctor
+  
   # List mods
   stardewl mods list
   
   # Check mods in specific path
   stardewl mods list --path /path/to/Mods`,
-	Version: "0.1.0-alpha",
+	Version: core.AppVersion,
 	SilenceUsage: true,
 	SilenceErrors: true,
 }
@@ -53,6 +62,8 @@ func init() {
 	rootCmd.AddCommand(join.JoinCmd)
 	rootCmd.AddCommand(signaling.SignalingCmd)
 	rootCmd.AddCommand(mods.ModsCmd)
+	rootCmd.AddCommand(natcheck.NatCheckCmd)
+	rootCmd.AddCommand(doctor.DoctorCmd)
 	rootCmd.AddCommand(versionCmd)
 }
 
@@ -4,12 +4,18 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
 	
 	"github.com/spf13/cobra"
 )
 
 var (
 	port string
+
+	turnEnabled  bool
+	turnPort     int
+	turnPublicIP string
+	turnSecret   string
 )
 
 var SignalingCmd = &cobra.Command{
@@ -25,13 +31,20 @@ Examples:
   stardewl signaling
   
   # Run on specific port
-  stardewl signaling --port 9090`,
+  stardewl signaling --port 9090
+  
+  # Also relay traffic for players behind symmetric NAT
+  stardewl signaling --turn --turn-public-ip 203.0.113.10`,
 	Args: cobra.NoArgs,
 	RunE: runSignaling,
 }
 
 func init() {
 	SignalingCmd.Flags().StringVar(&port, "port", ":8080", "Port to listen on")
+	SignalingCmd.Flags().BoolVar(&turnEnabled, "turn", false, "Run an embedded TURN relay and advertise it to clients")
+	SignalingCmd.Flags().IntVar(&turnPort, "turn-port", 3478, "TURN listening port (UDP and TCP)")
+	SignalingCmd.Flags().StringVar(&turnPublicIP, "turn-public-ip", "", "Public IP clients use to reach the TURN relay (default: auto-detect)")
+	SignalingCmd.Flags().StringVar(&turnSecret, "turn-secret", "", "Shared secret for time-limited TURN credentials (default: random per run)")
 }
 
 func runSignaling(cmd *cobra.Command, args []string) error {
@@ -57,8 +70,21 @@ func runSignaling(cmd *cobra.Command, args []string) error {
 	runCmd.Stderr = os.Stderr
 	
 	// Set environment variable for port if specified
+	runCmd.Env = os.Environ()
 	if port != ":8080" {
-		runCmd.Env = append(os.Environ(), "PORT="+port)
+		runCmd.Env = append(runCmd.Env, "PORT="+port)
+	}
+	
+	// Embedded TURN relay
+	if turnEnabled {
+		fmt.Printf("TURN relay enabled on port %d\n", turnPort)
+		runCmd.Env = append(runCmd.Env, "TURN_ENABLED=true", "TURN_PORT="+strconv.Itoa(turnPort))
+		if turnPublicIP != "" {
+			runCmd.Env = append(runCmd.Env, "TURN_PUBLIC_IP="+turnPublicIP)
+		}
+		if turnSecret != "" {
+			runCmd.Env = append(runCmd.Env, "TURN_SECRET="+turnSecret)
+		}
 	}
 	
 	if err := runCmd.Run(); err != nil {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	
 	"github.com/spf13/cobra"
+	"github.com/submlit21/stardewl-ink/core"
 )
 
 var versionCmd = &cobra.Command{
@@ -12,7 +13,7 @@ var versionCmd = &cobra.Command{
 	Long:  `Print the version number of Stardewl-Ink.`,
 	Args:  cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("Stardewl-Ink v0.1.0-alpha")
+		fmt.Printf("Stardewl-Ink v%s (protocol %d, compatible down to %d)\n", core.AppVersion, core.ProtocolVersion, core.MinProtocolVersion)
 		fmt.Println("WebRTC P2P connection tool for Stardew Valley")
 		fmt.Println("GitHub: https://github.com/submlit21/stardewl-ink")
 	},
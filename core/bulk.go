@@ -0,0 +1,312 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// 大文件传输（Mod、存档）与游戏流量的调度
+//
+// 所有数据通道共用一个SCTP关联，SCTP按先来先发处理各个通道，一次塞进去的大量数据
+// 会排在游戏数据前面。因此大文件传输走单独的bulk数据通道，由调度器控制：
+// 控制通道或游戏隧道有数据排队时让路，只在SCTP中保留少量大文件数据，并限制总带宽。
+const (
+	// bulkLabelPrefix 大文件传输数据通道的标签前缀
+	bulkLabelPrefix = "bulk:"
+	// BulkChunkSize Write拆分数据的单条消息大小
+	BulkChunkSize = 16 * 1024
+	// bulkMaxBuffered 单个bulk通道在SCTP中最多排队的字节数
+	bulkMaxBuffered = 64 * 1024
+	// bulkYieldThreshold 游戏/控制通道排队超过该字节数时，大文件传输暂停
+	bulkYieldThreshold = 16 * 1024
+	// bulkPollInterval 让路或等待令牌时的检查间隔
+	bulkPollInterval = 5 * time.Millisecond
+)
+
+// BulkConfig 大文件传输配置
+type BulkConfig struct {
+	// MaxBandwidth 所有bulk通道合计的发送带宽上限（字节/秒），为0表示不限制
+	MaxBandwidth int64
+}
+
+// bulkScheduler 一条连接上所有bulk通道共用的调度器：优先级让路加令牌桶限速
+type bulkScheduler struct {
+	rate int64
+	// priorityBusy 游戏/控制流量有积压时返回true
+	priorityBusy func() bool
+	tokens       float64
+	last         time.Time
+	// busy/busyChecked 最近一次priorityBusy的结果，多个bulk通道同时等待时共用
+	busy        bool
+	busyChecked time.Time
+	mu          sync.Mutex
+}
+
+// newBulkScheduler 创建调度器
+func newBulkScheduler(config BulkConfig, priorityBusy func() bool) *bulkScheduler {
+	return &bulkScheduler{
+		rate:         config.MaxBandwidth,
+		priorityBusy: priorityBusy,
+		last:         time.Now(),
+	}
+}
+
+// wait 等待可以在dc上发送size字节：没有更高优先级的积压、dc自身积压不多、带宽令牌足够
+func (s *bulkScheduler) wait(ctx context.Context, dc *webrtc.DataChannel, size int) error {
+	ticker := time.NewTicker(bulkPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if dc.ReadyState() != webrtc.DataChannelStateOpen {
+			return ErrChannelClosed
+		}
+
+		if !s.yield() && dc.BufferedAmount() <= bulkMaxBuffered && s.take(size) {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// yield 游戏/控制流量有积压时返回true
+//
+// priorityBusy要持连接锁遍历所有数据通道，结果缓存一个检查间隔，
+// 每条连接每个间隔最多遍历一次，与同时传输的bulk通道数量无关。
+func (s *bulkScheduler) yield() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.busyChecked) >= bulkPollInterval {
+		s.busy = s.priorityBusy()
+		s.busyChecked = now
+	}
+	return s.busy
+}
+
+// take 从令牌桶取出size字节的令牌，不足时返回false
+//
+// 桶容量为0.1秒的带宽（至少一条消息），避免空闲后瞬间突发。
+func (s *bulkScheduler) take(size int) bool {
+	if s.rate <= 0 {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	capacity := float64(s.rate) / 10
+	if capacity < float64(size) {
+		capacity = float64(size)
+	}
+	s.tokens += now.Sub(s.last).Seconds() * float64(s.rate)
+	if s.tokens > capacity {
+		s.tokens = capacity
+	}
+	s.last = now
+
+	if s.tokens < float64(size) {
+		return false
+	}
+	s.tokens -= float64(size)
+	return true
+}
+
+// BulkChannel 大文件传输通道（可靠有序），发送受调度器控制，不影响游戏流量
+type BulkChannel struct {
+	dc        *webrtc.DataChannel
+	scheduler *bulkScheduler
+	onMessage func([]byte)
+	onClose   func()
+	// pending 设置消息处理器之前收到的消息
+	pending [][]byte
+	opened  chan struct{}
+	// ctx 通道关闭（本端Close或对端关闭）时取消，结束等待中的发送
+	ctx    context.Context
+	cancel context.CancelFunc
+	sendMu sync.Mutex
+	mu     sync.Mutex
+}
+
+// newBulkChannel 包装数据通道
+func newBulkChannel(dc *webrtc.DataChannel, scheduler *bulkScheduler) *BulkChannel {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &BulkChannel{
+		dc:        dc,
+		scheduler: scheduler,
+		opened:    make(chan struct{}),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	var openOnce sync.Once
+	markOpen := func() {
+		openOnce.Do(func() {
+			close(b.opened)
+		})
+	}
+	dc.OnOpen(markOpen)
+	if dc.ReadyState() == webrtc.DataChannelStateOpen {
+		markOpen()
+	}
+
+	dc.OnClose(func() {
+		b.cancel()
+
+		b.mu.Lock()
+		handler := b.onClose
+		b.mu.Unlock()
+		if handler != nil {
+			handler()
+		}
+	})
+
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		b.mu.Lock()
+		handler := b.onMessage
+		if handler == nil {
+			b.pending = append(b.pending, msg.Data)
+		}
+		b.mu.Unlock()
+
+		if handler != nil {
+			handler(msg.Data)
+		}
+	})
+
+	return b
+}
+
+// Name 通道名称（打开时指定）
+func (b *BulkChannel) Name() string {
+	return strings.TrimPrefix(b.dc.Label(), bulkLabelPrefix)
+}
+
+// SetMessageHandler 设置消息处理器，之前收到的消息会立即交给它
+func (b *BulkChannel) SetMessageHandler(handler func([]byte)) {
+	b.mu.Lock()
+	b.onMessage = handler
+	pending := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+
+	for _, data := range pending {
+		handler(data)
+	}
+}
+
+// SetCloseHandler 设置关闭回调
+func (b *BulkChannel) SetCloseHandler(handler func()) {
+	b.mu.Lock()
+	b.onClose = handler
+	b.mu.Unlock()
+}
+
+// Send 发送一条消息：等待通道打开、游戏流量空闲和带宽配额，直到发出、ctx结束或通道关闭
+func (b *BulkChannel) Send(ctx context.Context, data []byte) error {
+	select {
+	case <-b.opened:
+	case <-b.ctx.Done():
+		return ErrChannelClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	b.sendMu.Lock()
+	defer b.sendMu.Unlock()
+
+	if err := b.scheduler.wait(ctx, b.dc, len(data)); err != nil {
+		return err
+	}
+	return b.dc.Send(data)
+}
+
+// Write 把数据拆分成BulkChunkSize大小的消息依次发送（实现io.Writer，可配合io.Copy）
+//
+// 没有调用方的ctx，等待直到发出或通道关闭；需要超时的调用方用Send。
+func (b *BulkChannel) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		end := written + BulkChunkSize
+		if end > len(p) {
+			end = len(p)
+		}
+		if err := b.Send(b.ctx, p[written:end]); err != nil {
+			if b.ctx.Err() != nil {
+				err = ErrChannelClosed
+			}
+			return written, err
+		}
+		written = end
+	}
+	return written, nil
+}
+
+// Close 关闭通道，等待中的Send和Write返回ErrChannelClosed
+func (b *BulkChannel) Close() error {
+	b.cancel()
+	return b.dc.Close()
+}
+
+// OpenBulkChannel 打开一条大文件传输通道，对端通过SetBulkChannelHandler接收
+func (c *Connection) OpenBulkChannel(name string) (*BulkChannel, error) {
+	dc, err := c.CreateDataChannel(bulkLabelPrefix+name, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bulk channel %q: %w", name, err)
+	}
+	return newBulkChannel(dc, c.bulk), nil
+}
+
+// SetBulkChannelHandler 设置对端打开的大文件传输通道的处理回调
+func (c *Connection) SetBulkChannelHandler(handler func(*BulkChannel)) {
+	c.mu.Lock()
+	c.onBulkChannel = handler
+	c.mu.Unlock()
+}
+
+// handleBulkChannel 处理对端打开的大文件传输通道
+func (c *Connection) handleBulkChannel(dc *webrtc.DataChannel) {
+	c.mu.RLock()
+	handler := c.onBulkChannel
+	c.mu.RUnlock()
+
+	if handler == nil {
+		log.Printf("No handler for bulk channel '%s', closing it", dc.Label())
+		dc.Close()
+		return
+	}
+	handler(newBulkChannel(dc, c.bulk))
+}
+
+// priorityBusy 控制通道或游戏隧道有数据排队时返回true，大文件传输应当让路
+func (c *Connection) priorityBusy() bool {
+	c.mu.RLock()
+	sender := c.sender
+	c.mu.RUnlock()
+
+	if sender != nil && sender.queued() > 0 {
+		return true
+	}
+
+	for _, dc := range c.channels.list() {
+		if strings.HasPrefix(dc.Label(), bulkLabelPrefix) {
+			continue
+		}
+		if dc.BufferedAmount() > bulkYieldThreshold {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,459 @@
+package core
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// 编码名称（hello中声明）
+const (
+	// CodecJSON JSON文本，hello和握手错误始终使用
+	CodecJSON = "json"
+	// CodecCBOR CBOR二进制（RFC 8949），信封为[type, payload, encoding]数组，负载由JSON转码
+	CodecCBOR = "cbor"
+)
+
+const (
+	// cborMaxDepth 解码时允许的最大嵌套层数
+	cborMaxDepth = 64
+)
+
+// Codec 控制通道消息的编码
+//
+// 握手完成后按协商的编码发送；接收时按首字节识别编码（JSON以'{'开头，CBOR信封是数组），
+// 因此切换编码前后到达的消息都能正确解码。
+type Codec interface {
+	// Name 编码名称
+	Name() string
+	// Encode 编码消息
+	Encode(msg Message) ([]byte, error)
+	// Decode 解码消息
+	Decode(data []byte) (Message, error)
+}
+
+// codecs 支持的编码，按优先顺序排列（协商时取双方都支持的第一个）
+var codecs = []Codec{cborCodec{}, jsonCodec{}}
+
+// CodecNames 支持的编码名称，按优先顺序排列
+func CodecNames() []string {
+	names := make([]string, 0, len(codecs))
+	for _, codec := range codecs {
+		names = append(names, codec.Name())
+	}
+	return names
+}
+
+// CodecByName 按名称查找编码
+func CodecByName(name string) (Codec, bool) {
+	for _, codec := range codecs {
+		if codec.Name() == name {
+			return codec, true
+		}
+	}
+	return nil, false
+}
+
+// negotiateCodec 选择双方都支持的优先级最高的编码，没有共同编码时使用JSON
+//
+// 按全局优先顺序选择而不是按某一端的偏好，两端独立协商的结果一致。
+func negotiateCodec(local, remote []string) string {
+	for _, codec := range codecs {
+		if containsString(local, codec.Name()) && containsString(remote, codec.Name()) {
+			return codec.Name()
+		}
+	}
+	return CodecJSON
+}
+
+// decodeMessage 按首字节识别编码并解码
+func decodeMessage(data []byte) (Message, error) {
+	if isCBOREnvelope(data) {
+		return cborCodec{}.Decode(data)
+	}
+	return jsonCodec{}.Decode(data)
+}
+
+// jsonCodec JSON编码（NewMessage的格式，压缩的负载为base64字符串）
+type jsonCodec struct{}
+
+// Name 编码名称
+func (jsonCodec) Name() string {
+	return CodecJSON
+}
+
+// Encode 编码消息
+func (jsonCodec) Encode(msg Message) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+// Decode 解码消息（不解压负载）
+func (jsonCodec) Decode(data []byte) (Message, error) {
+	var msg Message
+	err := json.Unmarshal(data, &msg)
+	return msg, err
+}
+
+// cborCodec CBOR编码：[type, payload, encoding]，后面为空的元素省略
+//
+// 负载仍以JSON定义（ParseXxx不变），编码时转成CBOR：省去引号、键名外的分隔符，整数按实际大小编码；
+// 压缩的负载直接以字节串存放，省去base64。
+type cborCodec struct{}
+
+// Name 编码名称
+func (cborCodec) Name() string {
+	return CodecCBOR
+}
+
+// Encode 编码消息
+func (cborCodec) Encode(msg Message) ([]byte, error) {
+	items := 1
+	if msg.Encoding != "" {
+		items = 3
+	} else if len(msg.Payload) > 0 {
+		items = 2
+	}
+
+	var buf bytes.Buffer
+	writeCBORHead(&buf, cborArray, uint64(items))
+	writeCBORHead(&buf, cborText, uint64(len(msg.Type)))
+	buf.WriteString(string(msg.Type))
+
+	if msg.Encoding != "" {
+		var compressed []byte
+		if err := json.Unmarshal(msg.Payload, &compressed); err != nil {
+			return nil, fmt.Errorf("invalid compressed %s payload: %w", msg.Type, err)
+		}
+		writeCBORHead(&buf, cborBytes, uint64(len(compressed)))
+		buf.Write(compressed)
+		writeCBORHead(&buf, cborText, uint64(len(msg.Encoding)))
+		buf.WriteString(msg.Encoding)
+	} else if len(msg.Payload) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(msg.Payload))
+		decoder.UseNumber()
+		var payload interface{}
+		if err := decoder.Decode(&payload); err != nil {
+			return nil, fmt.Errorf("invalid %s payload: %w", msg.Type, err)
+		}
+		if err := writeCBOR(&buf, payload); err != nil {
+			return nil, fmt.Errorf("failed to encode %s payload: %w", msg.Type, err)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode 解码消息（不解压负载）
+func (cborCodec) Decode(data []byte) (Message, error) {
+	var msg Message
+
+	r := &cborReader{data: data}
+	major, items, err := r.head()
+	if err != nil {
+		return msg, err
+	}
+	if major != cborArray || items < 1 || items > 3 {
+		return msg, fmt.Errorf("invalid CBOR envelope")
+	}
+
+	msgType, err := r.value(0)
+	if err != nil {
+		return msg, err
+	}
+	typeName, ok := msgType.(string)
+	if !ok {
+		return msg, fmt.Errorf("invalid CBOR envelope: message type is not a string")
+	}
+	msg.Type = MessageType(typeName)
+
+	if items >= 2 {
+		// 压缩的负载是字节串，解码为base64字符串，与JSON信封一致
+		payload, err := r.value(0)
+		if err != nil {
+			return msg, fmt.Errorf("invalid %s payload: %w", msg.Type, err)
+		}
+		if msg.Payload, err = json.Marshal(payload); err != nil {
+			return msg, fmt.Errorf("invalid %s payload: %w", msg.Type, err)
+		}
+	}
+	if items == 3 {
+		encoding, err := r.value(0)
+		if err != nil {
+			return msg, err
+		}
+		if msg.Encoding, ok = encoding.(string); !ok {
+			return msg, fmt.Errorf("invalid CBOR envelope: payload encoding is not a string")
+		}
+	}
+
+	if r.pos != len(r.data) {
+		return msg, fmt.Errorf("invalid CBOR envelope: %d trailing bytes", len(r.data)-r.pos)
+	}
+	return msg, nil
+}
+
+// CBOR主类型（RFC 8949 3.1）
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborText   = 3
+	cborArray  = 4
+	cborMap    = 5
+	cborSimple = 7
+)
+
+// CBOR简单值
+const (
+	cborFalse   = 0xf4
+	cborTrue    = 0xf5
+	cborNull    = 0xf6
+	cborFloat32 = 0xfa
+	cborFloat64 = 0xfb
+)
+
+var errCBORTruncated = errors.New("truncated CBOR data")
+
+// isCBOREnvelope 是否为CBOR信封（1~3个元素的数组）
+func isCBOREnvelope(data []byte) bool {
+	return len(data) > 0 && data[0]>>5 == cborArray && data[0]&0x1f >= 1 && data[0]&0x1f <= 3
+}
+
+// writeCBORHead 写入主类型和长度/数值
+func writeCBORHead(buf *bytes.Buffer, major byte, n uint64) {
+	major <<= 5
+	switch {
+	case n < 24:
+		buf.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(major | 24)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(major | 25)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	case n <= math.MaxUint32:
+		buf.WriteByte(major | 26)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	default:
+		buf.WriteByte(major | 27)
+		buf.Write(binary.BigEndian.AppendUint64(nil, n))
+	}
+}
+
+// writeCBOR 把JSON值（UseNumber解码的结果）写成CBOR，对象的键按字典序排列
+func writeCBOR(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(cborNull)
+	case bool:
+		if v {
+			buf.WriteByte(cborTrue)
+		} else {
+			buf.WriteByte(cborFalse)
+		}
+	case json.Number:
+		return writeCBORNumber(buf, v)
+	case string:
+		writeCBORHead(buf, cborText, uint64(len(v)))
+		buf.WriteString(v)
+	case []interface{}:
+		writeCBORHead(buf, cborArray, uint64(len(v)))
+		for _, item := range v {
+			if err := writeCBOR(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		writeCBORHead(buf, cborMap, uint64(len(v)))
+		for _, key := range keys {
+			writeCBORHead(buf, cborText, uint64(len(key)))
+			buf.WriteString(key)
+			if err := writeCBOR(buf, v[key]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported JSON value %T", v)
+	}
+	return nil
+}
+
+// writeCBORNumber 整数按大小编码，其余按float64编码
+func writeCBORNumber(buf *bytes.Buffer, n json.Number) error {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		if i >= 0 {
+			writeCBORHead(buf, cborUint, uint64(i))
+		} else {
+			writeCBORHead(buf, cborNegInt, uint64(-(i + 1)))
+		}
+		return nil
+	}
+	if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		writeCBORHead(buf, cborUint, u)
+		return nil
+	}
+
+	f, err := n.Float64()
+	if err != nil {
+		return fmt.Errorf("invalid number %q: %w", n, err)
+	}
+	buf.WriteByte(cborFloat64)
+	buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(f)))
+	return nil
+}
+
+// cborReader 解码CBOR为可直接json.Marshal的值
+type cborReader struct {
+	data []byte
+	pos  int
+}
+
+// head 读取主类型和长度/数值（不支持不定长）
+func (r *cborReader) head() (byte, uint64, error) {
+	if r.pos >= len(r.data) {
+		return 0, 0, errCBORTruncated
+	}
+	initial := r.data[r.pos]
+	r.pos++
+
+	major, info := initial>>5, initial&0x1f
+	if info < 24 {
+		return major, uint64(info), nil
+	}
+
+	size := 0
+	switch info {
+	case 24:
+		size = 1
+	case 25:
+		size = 2
+	case 26:
+		size = 4
+	case 27:
+		size = 8
+	default:
+		return 0, 0, fmt.Errorf("unsupported CBOR initial byte 0x%02x", initial)
+	}
+	if len(r.data)-r.pos < size {
+		return 0, 0, errCBORTruncated
+	}
+
+	var n uint64
+	for _, b := range r.data[r.pos : r.pos+size] {
+		n = n<<8 | uint64(b)
+	}
+	r.pos += size
+	return major, n, nil
+}
+
+// bytes 读取n个字节
+func (r *cborReader) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(r.data)-r.pos) {
+		return nil, errCBORTruncated
+	}
+	b := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+// value 读取一个值
+func (r *cborReader) value(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, fmt.Errorf("CBOR nesting deeper than %d", cborMaxDepth)
+	}
+
+	start := r.pos
+	major, n, err := r.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case cborUint:
+		return json.Number(strconv.FormatUint(n, 10)), nil
+	case cborNegInt:
+		if n > math.MaxInt64 {
+			return nil, fmt.Errorf("CBOR integer out of range")
+		}
+		return json.Number(strconv.FormatInt(-int64(n)-1, 10)), nil
+	case cborBytes:
+		b, err := r.bytes(n)
+		if err != nil {
+			return nil, err
+		}
+		// JSON中的[]byte是base64字符串
+		return base64.StdEncoding.EncodeToString(b), nil
+	case cborText:
+		b, err := r.bytes(n)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case cborArray:
+		// 每个元素至少1字节，防止伪造的长度导致大量分配
+		if n > uint64(len(r.data)-r.pos) {
+			return nil, errCBORTruncated
+		}
+		items := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			item, err := r.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case cborMap:
+		if n > uint64(len(r.data)-r.pos)/2 {
+			return nil, errCBORTruncated
+		}
+		object := make(map[string]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			key, err := r.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("CBOR map key is not a string")
+			}
+			if object[name], err = r.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return object, nil
+	case cborSimple:
+		switch r.data[start] {
+		case cborFalse:
+			return false, nil
+		case cborTrue:
+			return true, nil
+		case cborNull:
+			return nil, nil
+		case cborFloat32:
+			return cborFloat(float64(math.Float32frombits(uint32(n))), 32)
+		case cborFloat64:
+			return cborFloat(math.Float64frombits(n), 64)
+		}
+	}
+	return nil, fmt.Errorf("unsupported CBOR initial byte 0x%02x", r.data[start])
+}
+
+// cborFloat 浮点数转为JSON数字，NaN和无穷大在JSON中无法表示
+func cborFloat(f float64, bitSize int) (interface{}, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("CBOR float %v has no JSON representation", f)
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, bitSize)), nil
+}
@@ -0,0 +1,228 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// codecTestMessages 每种消息类型一条，外加压缩的负载和自定义消息类型
+func codecTestMessages(t *testing.T) map[string][]byte {
+	t.Helper()
+
+	mods := make([]ModInfo, 0, 100)
+	for i := 0; i < 100; i++ {
+		mods = append(mods, ModInfo{Name: "Mod" + strings.Repeat("x", i%7), Version: "1.2.3", Checksum: "abcdef0123456789", Size: int64(i) * 1024})
+	}
+	payloads := map[string]struct {
+		msgType MessageType
+		payload interface{}
+	}{
+		"hello":           {MessageTypeHello, newHello([]string{CapabilityModSync, CapabilityDeflate}, []string{CapabilityModSync})},
+		"mods_list":       {MessageTypeModsList, ModsListMessage{Mods: mods[:3]}},
+		"mods_comparison": {MessageTypeModsComparison, ModsComparisonMessage{Comparison: CompareMods(mods[:2], mods[1:3])}},
+		"game_ready":      {MessageTypeGameReady, nil},
+		"ping":            {MessageTypePing, PingMessage{Seq: 7, Timestamp: -1234567890123}},
+		"pong":            {MessageTypePong, PongMessage{Seq: 1<<64 - 1, Timestamp: 1234567890123}},
+		"error":           {MessageTypeError, ErrorMessage{Code: ErrorCodeMethodNotFound, Message: "no such method", Details: json.RawMessage(`{"n":1.5,"ok":true,"v":null}`), RequestID: 42}},
+		"lan_host_info":   {MessageTypeLANHostInfo, LANHostInfoMessage{Response: []byte{0, 1, 2, 0xff}}},
+		"request":         {MessageTypeRequest, RequestMessage{ID: 1, Method: MethodCompareMods, Params: json.RawMessage(`{"mods":[]}`)}},
+		"response":        {MessageTypeResponse, ResponseMessage{ID: 1, Result: json.RawMessage(`"ok"`)}},
+		"custom":          {"readycheck.vote", map[string]interface{}{"ready": true, "player": "Abigail"}},
+	}
+
+	messages := make(map[string][]byte, len(payloads)+1)
+	for name, p := range payloads {
+		data, err := NewMessage(p.msgType, p.payload)
+		if err != nil {
+			t.Fatalf("NewMessage(%s): %v", name, err)
+		}
+		messages[name] = data
+	}
+
+	data, err := NewMessage(MessageTypeModsList, ModsListMessage{Mods: mods})
+	if err != nil {
+		t.Fatalf("NewMessage(deflate): %v", err)
+	}
+	msg, err := jsonCodec{}.Decode(data)
+	if err != nil {
+		t.Fatalf("decode deflate message: %v", err)
+	}
+	compressed, err := compressPayload(&msg, defaultCompressionThreshold)
+	if err != nil || !compressed {
+		t.Fatalf("compressPayload = %v, %v; want compressed", compressed, err)
+	}
+	if messages["deflate"], err = json.Marshal(msg); err != nil {
+		t.Fatalf("marshal deflate message: %v", err)
+	}
+	return messages
+}
+
+// TestCodecRoundTrip 每种消息经两种编码编码再解码后与原消息一致
+func TestCodecRoundTrip(t *testing.T) {
+	messages := codecTestMessages(t)
+
+	// 表中需要覆盖所有内置消息类型
+	builtin := []MessageType{
+		MessageTypeHello, MessageTypeModsList, MessageTypeModsComparison, MessageTypeGameReady,
+		MessageTypePing, MessageTypePong, MessageTypeError, MessageTypeLANHostInfo,
+		MessageTypeRequest, MessageTypeResponse,
+	}
+	for _, msgType := range builtin {
+		if _, ok := messages[string(msgType)]; !ok {
+			t.Errorf("no round-trip case for %s", msgType)
+		}
+	}
+
+	for _, codec := range codecs {
+		for name, data := range messages {
+			t.Run(codec.Name()+"/"+name, func(t *testing.T) {
+				want, err := jsonCodec{}.Decode(data)
+				if err != nil {
+					t.Fatalf("decode original: %v", err)
+				}
+
+				encoded, err := codec.Encode(want)
+				if err != nil {
+					t.Fatalf("Encode: %v", err)
+				}
+				got, err := decodeMessage(encoded)
+				if err != nil {
+					t.Fatalf("Decode: %v", err)
+				}
+
+				if got.Type != want.Type || got.Encoding != want.Encoding {
+					t.Fatalf("got type %q encoding %q, want %q %q", got.Type, got.Encoding, want.Type, want.Encoding)
+				}
+				assertSameJSON(t, got.Payload, want.Payload)
+
+				// ParseMessage解压后与未压缩的原始负载一致
+				if want.Encoding != "" {
+					if err := decompressPayload(&got, defaultMaxReassembledSize); err != nil {
+						t.Fatalf("decompressPayload: %v", err)
+					}
+					if _, err := ParseModsList(got.Payload); err != nil {
+						t.Fatalf("ParseModsList after inflate: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// assertSameJSON 比较两个JSON值（CBOR会重排对象的键）
+func assertSameJSON(t *testing.T, got, want json.RawMessage) {
+	t.Helper()
+
+	if len(got) == 0 || len(want) == 0 {
+		if len(got) != len(want) {
+			t.Fatalf("payload %s, want %s", got, want)
+		}
+		return
+	}
+	var g, w interface{}
+	for _, v := range []struct {
+		data []byte
+		out  *interface{}
+	}{{got, &g}, {want, &w}} {
+		decoder := json.NewDecoder(bytes.NewReader(v.data))
+		decoder.UseNumber()
+		if err := decoder.Decode(v.out); err != nil {
+			t.Fatalf("invalid payload %s: %v", v.data, err)
+		}
+	}
+	if !reflect.DeepEqual(g, w) {
+		t.Fatalf("payload %s, want %s", got, want)
+	}
+}
+
+// TestCBORDecodeTruncated 截断的CBOR在每个位置都返回错误
+func TestCBORDecodeTruncated(t *testing.T) {
+	for name, data := range codecTestMessages(t) {
+		msg, err := jsonCodec{}.Decode(data)
+		if err != nil {
+			t.Fatalf("decode %s: %v", name, err)
+		}
+		encoded, err := cborCodec{}.Encode(msg)
+		if err != nil {
+			t.Fatalf("encode %s: %v", name, err)
+		}
+		for n := 0; n < len(encoded); n++ {
+			if _, err := (cborCodec{}).Decode(encoded[:n]); err == nil {
+				t.Errorf("%s truncated to %d of %d bytes: no error", name, n, len(encoded))
+			}
+		}
+	}
+}
+
+// TestCBORReaderMalformed 伪造长度、过深嵌套等输入返回错误而不是panic或大量分配
+func TestCBORReaderMalformed(t *testing.T) {
+	tests := map[string][]byte{
+		"empty":             {},
+		"byte string 2^64":  {0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"text string 2^32":  {0x7a, 0xff, 0xff, 0xff, 0xff, 'a'},
+		"array 2^64 items":  {0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00},
+		"map 2^32 entries":  {0xba, 0xff, 0xff, 0xff, 0xff, 0x61, 'a', 0x00},
+		"negint overflow":   {0x3b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"truncated head":    {0x19, 0x01},
+		"indefinite length": {0x9f, 0x00, 0xff},
+		"non-string key":    {0xa1, 0x00, 0x00},
+		"NaN":               {0xfb, 0x7f, 0xf8, 0, 0, 0, 0, 0, 0},
+		"undefined simple":  {0xf7},
+		"tag":               {0xc0, 0x00},
+		"nested too deep":   append(bytes.Repeat([]byte{0x81}, cborMaxDepth+2), 0x00),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := &cborReader{data: data}
+			if v, err := r.value(0); err == nil {
+				t.Fatalf("value() = %v, want error", v)
+			}
+		})
+	}
+
+	// 信封本身不合法
+	for name, data := range map[string][]byte{
+		"not an array":     {0x61, 'a'},
+		"type not string":  {0x81, 0x00},
+		"trailing bytes":   {0x81, 0x61, 'a', 0x00},
+		"encoding not str": {0x83, 0x61, 'a', 0x40, 0x00},
+	} {
+		if _, err := (cborCodec{}).Decode(data); err == nil {
+			t.Errorf("Decode(%s): no error", name)
+		}
+	}
+}
+
+// TestDecompressPayloadLimit 解压上限跟随连接配置的MaxReassembledSize
+func TestDecompressPayloadLimit(t *testing.T) {
+	const size = 2 * 1024 * 1024
+	payload, err := json.Marshal(strings.Repeat("a", size))
+	if err != nil {
+		t.Fatalf("marshal payload: %v", err)
+	}
+
+	compress := func() Message {
+		msg := Message{Type: MessageTypeModsList, Payload: payload}
+		if compressed, err := compressPayload(&msg, defaultCompressionThreshold); err != nil || !compressed {
+			t.Fatalf("compressPayload = %v, %v; want compressed", compressed, err)
+		}
+		return msg
+	}
+
+	small := compress()
+	if err := decompressPayload(&small, size/2); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("decompress with a %d byte limit: %v, want ErrMessageTooLarge", size/2, err)
+	}
+
+	large := compress()
+	if err := decompressPayload(&large, 2*size); err != nil {
+		t.Fatalf("decompress with a %d byte limit: %v", 2*size, err)
+	}
+	if !bytes.Equal(large.Payload, payload) {
+		t.Fatal("inflated payload differs from the original")
+	}
+}
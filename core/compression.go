@@ -0,0 +1,99 @@
+package core
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+const (
+	// PayloadEncodingDeflate 负载经deflate压缩（Message.Encoding），JSON中为base64字符串
+	PayloadEncodingDeflate = "deflate"
+	// defaultCompressionThreshold 负载超过该字节数才压缩
+	defaultCompressionThreshold = 1024
+)
+
+// CompressionConfig 控制通道消息负载的压缩
+//
+// 只有双方在hello中都声明了CapabilityDeflate才会压缩；压缩后没有变小的负载原样发送。
+type CompressionConfig struct {
+	// Disabled 不压缩，也不声明支持（对端仍可以发送未压缩的消息）
+	Disabled bool
+	// Threshold 负载超过该字节数才压缩，为0时为1KiB
+	Threshold int
+}
+
+// withDefaults 填充默认值
+func (c CompressionConfig) withDefaults() CompressionConfig {
+	if c.Threshold <= 0 {
+		c.Threshold = defaultCompressionThreshold
+	}
+	return c
+}
+
+// compressPayload 超过阈值且压缩后更小时把负载替换为压缩数据，返回是否压缩
+func compressPayload(msg *Message, threshold int) (bool, error) {
+	if msg.Encoding != "" || len(msg.Payload) < threshold {
+		return false, nil
+	}
+
+	var buf bytes.Buffer
+	writer, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return false, err
+	}
+	if _, err := writer.Write(msg.Payload); err != nil {
+		return false, err
+	}
+	if err := writer.Close(); err != nil {
+		return false, err
+	}
+
+	// base64后约为4/3，不够小就不压缩
+	if buf.Len()*4/3 >= len(msg.Payload) {
+		return false, nil
+	}
+
+	payload, err := json.Marshal(buf.Bytes())
+	if err != nil {
+		return false, err
+	}
+	msg.Payload = payload
+	msg.Encoding = PayloadEncodingDeflate
+	return true, nil
+}
+
+// decompressPayload 还原压缩的负载，解压后超过maxSize字节时返回ErrMessageTooLarge（防止压缩炸弹）
+//
+// maxSize与连接的FramingConfig.MaxReassembledSize一致，能完整收到的消息压缩后也能解压。
+func decompressPayload(msg *Message, maxSize int) error {
+	switch msg.Encoding {
+	case "":
+		return nil
+	case PayloadEncodingDeflate:
+	default:
+		return fmt.Errorf("unsupported payload encoding %q", msg.Encoding)
+	}
+
+	var compressed []byte
+	if err := json.Unmarshal(msg.Payload, &compressed); err != nil {
+		return fmt.Errorf("invalid compressed payload: %w", err)
+	}
+
+	reader := flate.NewReader(bytes.NewReader(compressed))
+	defer reader.Close()
+
+	payload, err := io.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if err != nil {
+		return fmt.Errorf("failed to inflate payload: %w", err)
+	}
+	if len(payload) > maxSize {
+		return fmt.Errorf("inflated payload exceeds %d bytes: %w", maxSize, ErrMessageTooLarge)
+	}
+
+	msg.Payload = payload
+	msg.Encoding = ""
+	return nil
+}
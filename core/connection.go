@@ -1,14 +1,20 @@
 package core
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/pion/webrtc/v3"
 )
 
+// controlChannelLabel 控制通道标签，用于交换stardewl协议消息
+const controlChannelLabel = "stardewl"
+
 // Connection 表示一个WebRTC连接
 type Connection struct {
 	peerConnection *webrtc.PeerConnection
@@ -16,33 +22,97 @@ type Connection struct {
 	connectionID  string
 	isHost        bool
 	onMessage     func([]byte)
+	onOpen        func()
 	onClose       func()
+	onDataChannel func(*webrtc.DataChannel)
 	mu            sync.RWMutex
+	// 断线重连（见reconnect.go）
+	reconnect reconnectState
+	// 请求/响应（见rpc.go）
+	rpc rpcState
+	// ctx 连接关闭时取消，传给请求和自定义消息的处理函数
+	ctx    context.Context
+	cancel context.CancelFunc
+	// 统计（见stats.go）
+	counters messageCounters
+	channels channelSet
+	// 控制通道的发送队列（见flowcontrol.go）
+	sender            *flowControl
+	flowControlConfig FlowControlConfig
+	// 大消息的分片与重组（见framing.go）
+	framingConfig FramingConfig
+	reassembler   *reassembler
+	nextFrameID   atomic.Uint32
+	// codec 握手协商的编码，为nil时发送JSON（见codec.go）
+	codec Codec
+	// 负载压缩（见compression.go），compress在对端声明支持后才打开
+	compressionConfig CompressionConfig
+	compress          bool
+	// 大文件传输通道的调度（见bulk.go）
+	bulk          *bulkScheduler
+	onBulkChannel func(*BulkChannel)
 }
 
 // ConnectionConfig 连接配置
 type ConnectionConfig struct {
 	ICEServers []webrtc.ICEServer
+	// ICE 传输策略与候选过滤
+	ICE ICEConfig
+	// Reconnect 断线重连策略（零值表示断开即关闭）
+	Reconnect ReconnectPolicy
+	// FlowControl 数据通道流量控制（零值使用默认值）
+	FlowControl FlowControlConfig
+	// Bulk 大文件传输通道的带宽限制
+	Bulk BulkConfig
+	// Framing 大消息的分片与重组（零值使用默认值）
+	Framing FramingConfig
+	// Compression 大负载的压缩（零值使用默认阈值）
+	Compression CompressionConfig
 }
 
 // NewConnection 创建新的WebRTC连接
 func NewConnection(connectionID string, isHost bool, config ConnectionConfig) (*Connection, error) {
 	// 创建PeerConnection配置
 	peerConfig := webrtc.Configuration{
-		ICEServers: config.ICEServers,
+		ICEServers:         config.ICE.iceServers(config.ICEServers),
+		ICETransportPolicy: config.ICE.TransportPolicy,
+	}
+
+	if config.ICE.TransportPolicy == webrtc.ICETransportPolicyRelay && !hasTURNServer(peerConfig.ICEServers) {
+		log.Printf("Warning: relay-only ICE policy without a TURN server, connection will fail (room: %s)", connectionID)
+	}
+
+	settings, err := config.ICE.settingEngine()
+	if err != nil {
+		return nil, err
 	}
 
 	// 创建PeerConnection
-	peerConnection, err := webrtc.NewPeerConnection(peerConfig)
+	api := webrtc.NewAPI(webrtc.WithSettingEngine(settings))
+	peerConnection, err := api.NewPeerConnection(peerConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create peer connection: %w", err)
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
 	conn := &Connection{
+		ctx:            ctx,
+		cancel:         cancel,
 		peerConnection: peerConnection,
 		connectionID:   connectionID,
 		isHost:         isHost,
+		reconnect: reconnectState{
+			policy: config.Reconnect,
+		},
+		flowControlConfig: config.FlowControl.withDefaults(),
+		framingConfig:     config.Framing.withDefaults(),
+		compressionConfig: config.Compression.withDefaults(),
 	}
+	conn.reassembler = newReassembler(conn.framingConfig, func(err error) {
+		log.Printf("Dropping chunked message (room: %s): %v", connectionID, err)
+		conn.counters.dropped.Add(1)
+	})
+	conn.bulk = newBulkScheduler(config.Bulk, conn.priorityBusy)
 
 	// 设置ICE连接状态回调
 	peerConnection.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
@@ -60,11 +130,8 @@ func NewConnection(connectionID string, isHost bool, config ConnectionConfig) (*
 			log.Printf("ICE connection closed (room: %s)", connectionID)
 		}
 		
-		if state == webrtc.ICEConnectionStateDisconnected ||
-			state == webrtc.ICEConnectionStateFailed ||
-			state == webrtc.ICEConnectionStateClosed {
-			conn.close()
-		}
+		// 断开与失败交给重连策略处理，关闭则直接关闭连接
+		conn.handleICEState(state)
 	})
 	
 	// 设置ICE候选回调
@@ -83,7 +150,7 @@ func NewConnection(connectionID string, isHost bool, config ConnectionConfig) (*
 
 	// 如果是主机，创建数据通道
 	if isHost {
-		dataChannel, err := peerConnection.CreateDataChannel("stardewl", nil)
+		dataChannel, err := peerConnection.CreateDataChannel(controlChannelLabel, nil)
 		if err != nil {
 			peerConnection.Close()
 			return nil, fmt.Errorf("failed to create data channel: %w", err)
@@ -91,32 +158,97 @@ func NewConnection(connectionID string, isHost bool, config ConnectionConfig) (*
 		
 		conn.setupDataChannel(dataChannel)
 		conn.dataChannel = dataChannel
-	} else {
-		// 如果是客户端，监听数据通道
-		peerConnection.OnDataChannel(func(dc *webrtc.DataChannel) {
-			conn.setupDataChannel(dc)
-			conn.dataChannel = dc
-			log.Printf("Data channel '%s' opened\n", dc.Label())
-		})
 	}
 
+	// 监听对端创建的数据通道：控制通道由连接自身处理，大文件传输通道交给bulk处理器，其余交给外部处理器（如游戏隧道）
+	peerConnection.OnDataChannel(func(dc *webrtc.DataChannel) {
+		conn.channels.add(dc)
+
+		if strings.HasPrefix(dc.Label(), bulkLabelPrefix) {
+			conn.handleBulkChannel(dc)
+			return
+		}
+
+		if dc.Label() != controlChannelLabel {
+			conn.mu.RLock()
+			onDataChannel := conn.onDataChannel
+			conn.mu.RUnlock()
+
+			if onDataChannel == nil {
+				log.Printf("No handler for data channel '%s', closing it", dc.Label())
+				dc.Close()
+				return
+			}
+			onDataChannel(dc)
+			return
+		}
+
+		conn.setupDataChannel(dc)
+		conn.mu.Lock()
+		conn.dataChannel = dc
+		conn.mu.Unlock()
+		log.Printf("Data channel '%s' opened\n", dc.Label())
+	})
+
 	return conn, nil
 }
 
 // setupDataChannel 设置数据通道的回调
 func (c *Connection) setupDataChannel(dc *webrtc.DataChannel) {
+	c.channels.add(dc)
+
+	sender := newFlowControl(dc, c.flowControlConfig, func(err error) {
+		if err != nil {
+			c.counters.sendErrors.Add(1)
+		} else {
+			c.counters.sent.Add(1)
+		}
+	})
+	c.mu.Lock()
+	previous := c.sender
+	c.sender = sender
+	c.mu.Unlock()
+	if previous != nil {
+		previous.close()
+	}
+
 	dc.OnOpen(func() {
 		label := dc.Label()
 		log.Printf("Data channel '%s' opened (room: %s)", label, c.connectionID)
+
+		c.mu.RLock()
+		onOpen := c.onOpen
+		c.mu.RUnlock()
+
+		if onOpen != nil {
+			onOpen()
+		}
 	})
 
 	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		data := msg.Data
+		if isFrame(data) {
+			if data = c.reassembler.add(data); data == nil {
+				return
+			}
+		}
+		if isCBOREnvelope(data) {
+			decoded, err := c.decode(data)
+			if err != nil {
+				log.Printf("Failed to decode message (room: %s): %v", c.connectionID, err)
+				c.counters.dropped.Add(1)
+				return
+			}
+			data = decoded
+		}
+		c.counters.received.Add(1)
+
 		c.mu.RLock()
 		onMessage := c.onMessage
 		c.mu.RUnlock()
 		
 		if onMessage != nil {
-			onMessage(msg.Data)
+			onMessage(data)
 		}
 	})
 
@@ -128,25 +260,40 @@ func (c *Connection) setupDataChannel(dc *webrtc.DataChannel) {
 
 // CreateOffer 创建SDP Offer（主机调用）
 func (c *Connection) CreateOffer() (string, error) {
+	return c.createOffer(nil)
+}
+
+// CreateRestartOffer 创建带ICE重启的SDP Offer（主机调用），用于断线后重新协商
+func (c *Connection) CreateRestartOffer() (string, error) {
+	return c.createOffer(&webrtc.OfferOptions{ICERestart: true})
+}
+
+// createOffer 创建Offer并等待ICE收集完成
+func (c *Connection) createOffer(options *webrtc.OfferOptions) (string, error) {
 	if !c.isHost {
 		return "", fmt.Errorf("only host can create offer")
 	}
 
-	offer, err := c.peerConnection.CreateOffer(nil)
+	pc, err := c.livePeerConnection()
+	if err != nil {
+		return "", err
+	}
+
+	offer, err := pc.CreateOffer(options)
 	if err != nil {
 		return "", fmt.Errorf("failed to create offer: %w", err)
 	}
 
-	err = c.peerConnection.SetLocalDescription(offer)
+	err = pc.SetLocalDescription(offer)
 	if err != nil {
 		return "", fmt.Errorf("failed to set local description: %w", err)
 	}
 
 	// 等待ICE收集完成
-	gatherComplete := webrtc.GatheringCompletePromise(c.peerConnection)
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
 	<-gatherComplete
 
-	offerJSON, err := json.Marshal(c.peerConnection.LocalDescription())
+	offerJSON, err := json.Marshal(pc.LocalDescription())
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal offer: %w", err)
 	}
@@ -161,7 +308,11 @@ func (c *Connection) SetRemoteDescription(sdp string) error {
 		return fmt.Errorf("failed to unmarshal SDP: %w", err)
 	}
 
-	return c.peerConnection.SetRemoteDescription(desc)
+	pc, err := c.livePeerConnection()
+	if err != nil {
+		return err
+	}
+	return pc.SetRemoteDescription(desc)
 }
 
 // CreateAnswer 创建SDP Answer（客户端调用）
@@ -170,21 +321,26 @@ func (c *Connection) CreateAnswer() (string, error) {
 		return "", fmt.Errorf("only client can create answer")
 	}
 
-	answer, err := c.peerConnection.CreateAnswer(nil)
+	pc, err := c.livePeerConnection()
+	if err != nil {
+		return "", err
+	}
+
+	answer, err := pc.CreateAnswer(nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to create answer: %w", err)
 	}
 
-	err = c.peerConnection.SetLocalDescription(answer)
+	err = pc.SetLocalDescription(answer)
 	if err != nil {
 		return "", fmt.Errorf("failed to set local description: %w", err)
 	}
 
 	// 等待ICE收集完成
-	gatherComplete := webrtc.GatheringCompletePromise(c.peerConnection)
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
 	<-gatherComplete
 
-	answerJSON, err := json.Marshal(c.peerConnection.LocalDescription())
+	answerJSON, err := json.Marshal(pc.LocalDescription())
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal answer: %w", err)
 	}
@@ -199,24 +355,218 @@ func (c *Connection) AddICECandidate(candidate string) error {
 		return fmt.Errorf("failed to unmarshal ICE candidate: %w", err)
 	}
 
-	return c.peerConnection.AddICECandidate(iceCandidate)
+	pc, err := c.livePeerConnection()
+	if err != nil {
+		return err
+	}
+	return pc.AddICECandidate(iceCandidate)
+}
+
+// livePeerConnection 获取PeerConnection，连接已关闭（close清空了该字段）时返回ErrChannelClosed
+//
+// 重连中的ICE重启可能与close同时发生，必须持锁取一次再使用。
+func (c *Connection) livePeerConnection() (*webrtc.PeerConnection, error) {
+	c.mu.RLock()
+	pc := c.peerConnection
+	c.mu.RUnlock()
+
+	if pc == nil {
+		return nil, ErrChannelClosed
+	}
+	return pc, nil
+}
+
+// CreateDataChannel 创建额外的数据通道（控制通道之外，例如游戏隧道）
+func (c *Connection) CreateDataChannel(label string, init *webrtc.DataChannelInit) (*webrtc.DataChannel, error) {
+	if label == controlChannelLabel {
+		return nil, fmt.Errorf("data channel label %q is reserved", label)
+	}
+
+	c.mu.RLock()
+	pc := c.peerConnection
+	c.mu.RUnlock()
+
+	if pc == nil {
+		return nil, fmt.Errorf("connection closed")
+	}
+
+	dc, err := pc.CreateDataChannel(label, init)
+	if err != nil {
+		return nil, err
+	}
+	c.channels.add(dc)
+	return dc, nil
 }
 
-// SendMessage 发送消息到对端
+// SendMessage 发送消息到对端（阻塞直到消息交给SCTP）
 func (c *Connection) SendMessage(data []byte) error {
+	return c.Send(context.Background(), data)
+}
+
+// Send 发送消息到对端：SCTP缓冲过多时在有界队列中等待，直到消息交给SCTP或ctx结束
+//
+// 超过MaxMessageSize()的消息拆成分片依次发送，由对端重组。
+func (c *Connection) Send(ctx context.Context, data []byte) error {
+	sender, err := c.openSender()
+	if err != nil {
+		return err
+	}
+
+	frames, err := c.frames(data)
+	if err != nil {
+		return err
+	}
+	for _, frame := range frames {
+		if err := sender.send(ctx, frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TrySendMessage 非阻塞发送：消息放入发送队列后立即返回，队列满时返回ErrSendQueueFull
+//
+// 大消息的分片中途遇到队列满时已入队的分片照常发出，对端在重组超时后丢弃。
+func (c *Connection) TrySendMessage(data []byte) error {
+	sender, err := c.openSender()
+	if err != nil {
+		return err
+	}
+
+	frames, err := c.frames(data)
+	if err != nil {
+		return err
+	}
+	for _, frame := range frames {
+		if err := sender.trySend(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// frames 按协商的编码重新编码并按SCTP单条消息上限拆分消息
+func (c *Connection) frames(data []byte) ([][]byte, error) {
+	data, err := c.encode(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > c.framingConfig.MaxReassembledSize {
+		return nil, fmt.Errorf("%d bytes exceeds %d: %w", len(data), c.framingConfig.MaxReassembledSize, ErrMessageTooLarge)
+	}
+	return splitFrames(data, c.nextFrameID.Add(1), c.MaxMessageSize()), nil
+}
+
+// SetCodec 设置发送使用的编码（握手协商后调用），为nil时发送JSON
+func (c *Connection) SetCodec(codec Codec) {
+	c.mu.Lock()
+	c.codec = codec
+	c.mu.Unlock()
+}
+
+// Codec 获取发送使用的编码名称
+func (c *Connection) Codec() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.codec == nil {
+		return CodecJSON
+	}
+	return c.codec.Name()
+}
+
+// SetCompression 对端支持时打开负载压缩（握手协商后调用），Compression.Disabled时无效
+func (c *Connection) SetCompression(enabled bool) {
+	c.mu.Lock()
+	c.compress = enabled && !c.compressionConfig.Disabled
+	c.mu.Unlock()
+}
+
+// encode 把JSON信封（NewMessage的结果）按协商的编码重新编码，超过阈值的负载先压缩
+//
+// 不是stardewl消息的数据（如SendJSON发送的任意JSON）原样发送。
+func (c *Connection) encode(data []byte) ([]byte, error) {
+	c.mu.RLock()
+	codec := c.codec
+	compress := c.compress
+	c.mu.RUnlock()
+
+	if codec == nil {
+		codec = jsonCodec{}
+	}
+	if codec.Name() == CodecJSON && (!compress || len(data) < c.compressionConfig.Threshold) {
+		return data, nil
+	}
+
+	msg, err := jsonCodec{}.Decode(data)
+	if err != nil || msg.Type == "" {
+		return data, nil
+	}
+
+	if compress {
+		compressed, err := compressPayload(&msg, c.compressionConfig.Threshold)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress %s message: %w", msg.Type, err)
+		}
+		if !compressed && codec.Name() == CodecJSON {
+			return data, nil
+		}
+	}
+
+	encoded, err := codec.Encode(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s message: %w", msg.Type, err)
+	}
+	return encoded, nil
+}
+
+// decode 把对端按其他编码发送的消息转回JSON信封，供消息处理器解析
+func (c *Connection) decode(data []byte) ([]byte, error) {
+	msg, err := decodeMessage(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(msg)
+}
+
+// MaxReassembledSize 控制通道单条消息（重组、解压后）的上限，即FramingConfig.MaxReassembledSize
+func (c *Connection) MaxReassembledSize() int {
+	return c.framingConfig.MaxReassembledSize
+}
+
+// MaxMessageSize 控制通道单条SCTP消息的上限（由远程SDP的max-message-size协商，最大65535字节），
+// 更大的消息由Send自动分片
+func (c *Connection) MaxMessageSize() int {
+	c.mu.RLock()
+	pc := c.peerConnection
+	c.mu.RUnlock()
+
+	if pc == nil {
+		return pionMessageSize
+	}
+	desc := pc.RemoteDescription()
+	if desc == nil {
+		return pionMessageSize
+	}
+	return sctpMaxMessageSize(desc.SDP)
+}
+
+// openSender 获取已打开的控制通道的发送队列
+func (c *Connection) openSender() (*flowControl, error) {
 	c.mu.RLock()
 	dc := c.dataChannel
+	sender := c.sender
 	c.mu.RUnlock()
 
-	if dc == nil {
-		return fmt.Errorf("data channel not ready")
+	if dc == nil || sender == nil {
+		return nil, fmt.Errorf("data channel not ready")
 	}
 
 	if dc.ReadyState() != webrtc.DataChannelStateOpen {
-		return fmt.Errorf("data channel not open")
+		return nil, fmt.Errorf("data channel not open")
 	}
 
-	return dc.Send(data)
+	return sender, nil
 }
 
 // SendJSON 发送JSON消息到对端
@@ -235,6 +585,20 @@ func (c *Connection) SetMessageHandler(handler func([]byte)) {
 	c.mu.Unlock()
 }
 
+// SetOpenHandler 设置控制通道打开回调
+func (c *Connection) SetOpenHandler(handler func()) {
+	c.mu.Lock()
+	c.onOpen = handler
+	c.mu.Unlock()
+}
+
+// SetDataChannelHandler 设置对端创建的额外数据通道的处理回调
+func (c *Connection) SetDataChannelHandler(handler func(*webrtc.DataChannel)) {
+	c.mu.Lock()
+	c.onDataChannel = handler
+	c.mu.Unlock()
+}
+
 // SetCloseHandler 设置关闭回调
 func (c *Connection) SetCloseHandler(handler func()) {
 	c.mu.Lock()
@@ -248,18 +612,32 @@ func (c *Connection) Close() error {
 	return nil
 }
 
-// close 内部关闭方法
+// close 内部关闭方法（可重复调用，只触发一次关闭回调）
 func (c *Connection) close() {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	pc := c.peerConnection
+	c.peerConnection = nil
+	onClose := c.onClose
+	sender := c.sender
+	c.mu.Unlock()
+
+	if pc == nil {
+		return
+	}
 
-	if c.peerConnection != nil {
-		c.peerConnection.Close()
-		c.peerConnection = nil
+	c.stopReconnect()
+	c.cancel()
+	c.closeRPC()
+	if sender != nil {
+		sender.close()
 	}
+	c.reassembler.reset()
 
-	if c.onClose != nil {
-		c.onClose()
+	// 在锁外关闭，PeerConnection关闭时触发的回调会再次进入连接
+	pc.Close()
+
+	if onClose != nil {
+		onClose()
 	}
 }
 
@@ -282,4 +660,23 @@ func (c *Connection) IsConnected() bool {
 		return false
 	}
 	return c.dataChannel.ReadyState() == webrtc.DataChannelStateOpen
-}
\ No newline at end of file
+}
+
+// IsClosed 检查连接是否已关闭
+func (c *Connection) IsClosed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.peerConnection == nil
+}
+
+// hasTURNServer 检查是否配置了TURN服务器
+func hasTURNServer(servers []webrtc.ICEServer) bool {
+	for _, server := range servers {
+		for _, url := range server.URLs {
+			if strings.HasPrefix(url, "turn:") || strings.HasPrefix(url, "turns:") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -1,9 +1,11 @@
 package core
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/pion/webrtc/v3"
@@ -19,6 +21,11 @@ type StardewlClient struct {
 	onModsChecked func(ModComparison)
 	onConnected   func()
 	onDisconnected func()
+	heartbeat      *heartbeat
+	handshake      *handshake
+	// handlers 自定义消息的处理函数（见handlers.go）
+	handlers handlerRegistry
+	mu       sync.Mutex
 }
 
 // ClientConfig 客户端配置
@@ -60,11 +67,33 @@ func NewStardewlClient(config ClientConfig) (*StardewlClient, error) {
 		modsPath:     modsPath,
 	}
 
+	// 控制通道打开后先交换hello，握手完成才算连接成功
+	client.handshake = newHandshake(newHello([]string{CapabilityModSync, CapabilityDeflate}, nil), connection.SendMessage,
+		func(info PeerInfo) {
+			if codec, ok := CodecByName(info.Codec); ok {
+				connection.SetCodec(codec)
+			}
+			connection.SetCompression(info.Has(CapabilityDeflate))
+			if client.onConnected != nil {
+				client.onConnected()
+			}
+		},
+		func(err *HandshakeError) {
+			log.Printf("Handshake failed: %v\n", err)
+			// 稍等再关闭，让error消息先送达对端
+			time.AfterFunc(handshakeCloseDelay, func() {
+				connection.Close()
+			})
+		},
+	)
+	connection.SetOpenHandler(client.handshake.start)
+
 	// 设置消息处理器
 	connection.SetMessageHandler(client.handleMessage)
 
 	// 设置关闭处理器
 	connection.SetCloseHandler(func() {
+		client.handshake.stop()
 		if client.onDisconnected != nil {
 			client.onDisconnected()
 		}
@@ -75,25 +104,51 @@ func NewStardewlClient(config ClientConfig) (*StardewlClient, error) {
 
 // handleMessage 处理接收到的消息
 func (c *StardewlClient) handleMessage(data []byte) {
-	msg, err := ParseMessage(data)
+	msg, err := parseMessage(data, c.connection.MaxReassembledSize())
 	if err != nil {
 		log.Printf("Failed to parse message: %v\n", err)
 		return
 	}
 
+	// 任何消息都说明对端存活
+	if hb := c.getHeartbeat(); hb != nil {
+		hb.touch()
+	}
+
+	switch msg.Type {
+	case MessageTypeHello:
+		c.handshake.handleHello(msg.Payload)
+		return
+	case MessageTypeError:
+		c.handleError(msg.Payload)
+		return
+	}
+
+	// 握手完成前只处理hello和error
+	if !c.handshake.completed() {
+		log.Printf("Ignoring %s before handshake\n", msg.Type)
+		return
+	}
+
 	switch msg.Type {
 	case MessageTypeModsList:
 		c.handleModsList(msg.Payload)
 	case MessageTypeModsComparison:
 		c.handleModsComparison(msg.Payload)
 	case MessageTypePing:
-		c.handlePing()
+		c.handlePing(msg.Payload)
+	case MessageTypePong:
+		c.handlePong(msg.Payload)
 	case MessageTypeGameReady:
 		c.handleGameReady()
-	case MessageTypeError:
-		c.handleError(msg.Payload)
+	case MessageTypeRequest:
+		c.connection.handleRequest(msg.Payload)
+	case MessageTypeResponse:
+		c.connection.handleResponse(msg.Payload)
 	default:
-		log.Printf("Unknown message type: %s\n", msg.Type)
+		if !c.handlers.dispatch(c.connection.ctx, c.remotePeer(), msg) {
+			log.Printf("Unknown message type: %s\n", msg.Type)
+		}
 	}
 }
 
@@ -144,10 +199,9 @@ func (c *StardewlClient) handleModsComparison(payload json.RawMessage) {
 	}
 }
 
-// handlePing 处理心跳消息
-func (c *StardewlClient) handlePing() {
-	// 发送pong响应
-	pongMsg, err := NewMessage(MessageTypePong, nil)
+// handlePing 处理心跳消息，原样带回序号和时间戳
+func (c *StardewlClient) handlePing(payload json.RawMessage) {
+	pongMsg, err := pongFor(payload)
 	if err != nil {
 		log.Printf("Failed to create pong message: %v\n", err)
 		return
@@ -158,6 +212,19 @@ func (c *StardewlClient) handlePing() {
 	}
 }
 
+// handlePong 处理心跳响应
+func (c *StardewlClient) handlePong(payload json.RawMessage) {
+	pong, err := ParsePong(payload)
+	if err != nil {
+		log.Printf("Failed to parse pong: %v\n", err)
+		return
+	}
+
+	if hb := c.getHeartbeat(); hb != nil {
+		hb.handlePong(pong)
+	}
+}
+
 // handleGameReady 处理游戏准备就绪消息
 func (c *StardewlClient) handleGameReady() {
 	log.Println("Remote peer is ready to play")
@@ -172,6 +239,9 @@ func (c *StardewlClient) handleError(payload json.RawMessage) {
 		return
 	}
 	
+	if c.connection.handleRPCError(errorMsg) || c.handshake.handleError(errorMsg) {
+		return
+	}
 	log.Printf("Received error from peer: %s - %s\n", errorMsg.Code, errorMsg.Message)
 }
 
@@ -264,9 +334,9 @@ func (c *StardewlClient) SendGameReady() error {
 	return c.connection.SendMessage(msg)
 }
 
-// SendPing 发送心跳消息
+// SendPing 发送心跳消息（不计入心跳统计）
 func (c *StardewlClient) SendPing() error {
-	msg, err := NewMessage(MessageTypePing, nil)
+	msg, err := NewMessage(MessageTypePing, PingMessage{Timestamp: time.Now().UnixNano()})
 	if err != nil {
 		return fmt.Errorf("failed to create ping message: %w", err)
 	}
@@ -274,20 +344,40 @@ func (c *StardewlClient) SendPing() error {
 	return c.connection.SendMessage(msg)
 }
 
-// StartHeartbeat 开始心跳检测
+// StartHeartbeat 开始心跳检测，3个间隔内没有收到对端消息即关闭连接（触发断开回调）
 func (c *StardewlClient) StartHeartbeat(interval time.Duration) {
-	go func() {
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
-		
-		for range ticker.C {
-			if c.connection.IsConnected() {
-				if err := c.SendPing(); err != nil {
-					log.Printf("Failed to send heartbeat: %v\n", err)
-				}
-			}
-		}
-	}()
+	// 连接建立前或重连期间不判定超时
+	paused := func() bool {
+		return !c.connection.IsConnected() || c.connection.IsReconnecting()
+	}
+	hb := newHeartbeat(HeartbeatConfig{Interval: interval}, c.connection.SendMessage, paused, func() {
+		c.connection.Close()
+	})
+
+	c.mu.Lock()
+	previous := c.heartbeat
+	c.heartbeat = hb
+	c.mu.Unlock()
+
+	if previous != nil {
+		previous.stop()
+	}
+	hb.start()
+}
+
+// HeartbeatStats 获取心跳统计（往返时间、抖动等）
+func (c *StardewlClient) HeartbeatStats() HeartbeatStats {
+	if hb := c.getHeartbeat(); hb != nil {
+		return hb.snapshot()
+	}
+	return HeartbeatStats{}
+}
+
+// getHeartbeat 获取心跳（未启动时为nil）
+func (c *StardewlClient) getHeartbeat() *heartbeat {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.heartbeat
 }
 
 // SetModsCheckedHandler 设置Mod检查回调
@@ -315,13 +405,59 @@ func (c *StardewlClient) IsHost() bool {
 	return c.isHost
 }
 
-// IsConnected 检查是否已连接
+// IsConnected 检查是否已连接（握手已完成）
 func (c *StardewlClient) IsConnected() bool {
-	return c.connection.IsConnected()
+	return c.connection.IsConnected() && c.handshake.completed()
+}
+
+// PeerInfo 获取与对端协商的协议版本和能力，握手完成前返回false
+func (c *StardewlClient) PeerInfo() (PeerInfo, bool) {
+	return c.handshake.result()
+}
+
+// HandleMethod 注册RPC方法，handler为nil时取消注册
+func (c *StardewlClient) HandleMethod(method string, handler RPCHandler) {
+	c.connection.HandleMethod(method, handler)
+}
+
+// Call 调用对端的RPC方法，对端返回错误时返回*RPCError
+func (c *StardewlClient) Call(ctx context.Context, method string, req interface{}) (json.RawMessage, error) {
+	if !c.IsConnected() {
+		return nil, fmt.Errorf("not connected")
+	}
+	return c.connection.Call(ctx, method, req)
+}
+
+// RegisterHandler 注册自定义消息类型的处理函数，handler为nil时取消注册
+//
+// 消息类型必须是"<命名空间>.<名称>"的形式（见handlers.go），否则返回ErrInvalidMessageType。
+func (c *StardewlClient) RegisterHandler(msgType MessageType, handler HandlerFunc) error {
+	return c.handlers.register(msgType, handler)
+}
+
+// Send 向对端发送自定义消息
+func (c *StardewlClient) Send(msgType MessageType, payload interface{}) error {
+	if !c.IsConnected() {
+		return fmt.Errorf("not connected")
+	}
+	return c.remotePeer().Send(msgType, payload)
+}
+
+// remotePeer 供自定义消息处理函数使用的对端（主机一侧为"client"，客户端一侧为"host"）
+func (c *StardewlClient) remotePeer() Peer {
+	id := hostPeerID
+	if c.isHost {
+		id = "client"
+	}
+	return peerHandle{id: id, connection: c.connection, handshake: c.handshake}
 }
 
 // Close 关闭客户端
 func (c *StardewlClient) Close() error {
+	c.handshake.stop()
+	if hb := c.getHeartbeat(); hb != nil {
+		hb.stop()
+	}
 	return c.connection.Close()
 }
 
@@ -344,4 +480,31 @@ func GetDefaultICEServers() []webrtc.ICEServer {
 			URLs: []string{"stun:stun4.l.google.com:19302"},
 		},
 	}
-}
\ No newline at end of file
+}
+
+// MergeICEServers 合并ICE服务器，extra中URL已全部出现在base里的条目被跳过
+//
+// 信令服务器在HTTP响应和connected消息中都会下发TURN服务器，避免重复分配中继。
+func MergeICEServers(base, extra []webrtc.ICEServer) []webrtc.ICEServer {
+	known := make(map[string]bool)
+	for _, server := range base {
+		for _, url := range server.URLs {
+			known[url] = true
+		}
+	}
+
+	merged := append([]webrtc.ICEServer(nil), base...)
+	for _, server := range extra {
+		duplicate := len(server.URLs) > 0
+		for _, url := range server.URLs {
+			if !known[url] {
+				duplicate = false
+			}
+			known[url] = true
+		}
+		if !duplicate {
+			merged = append(merged, server)
+		}
+	}
+	return merged
+}
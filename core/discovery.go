@@ -0,0 +1,139 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Lidgren（星露谷非Steam联机所用的UDP库）未连接消息的格式：
+//
+//	byte 0    消息类型
+//	byte 1-2  序号（最低位为分片标志）
+//	byte 3-4  负载长度（单位：位，小端）
+//	byte 5-   负载
+const (
+	lidgrenHeaderSize = 5
+
+	// lidgrenMessageTypeDiscovery 局域网发现请求（NetMessageType.Discovery）
+	lidgrenMessageTypeDiscovery byte = 136
+	// lidgrenMessageTypeDiscoveryResponse 局域网发现响应（NetMessageType.DiscoveryResponse）
+	lidgrenMessageTypeDiscoveryResponse byte = 137
+)
+
+// encodeLidgrenMessage 编码一条Lidgren未连接消息
+func encodeLidgrenMessage(msgType byte, payload []byte) []byte {
+	packet := make([]byte, lidgrenHeaderSize+len(payload))
+	packet[0] = msgType
+	binary.LittleEndian.PutUint16(packet[3:5], uint16(len(payload)*8))
+	copy(packet[lidgrenHeaderSize:], payload)
+	return packet
+}
+
+// parseLidgrenMessage 解析数据报中的第一条Lidgren消息
+func parseLidgrenMessage(data []byte) (byte, []byte, bool) {
+	if len(data) < lidgrenHeaderSize {
+		return 0, nil, false
+	}
+
+	bits := int(binary.LittleEndian.Uint16(data[3:5]))
+	size := (bits + 7) / 8
+	if lidgrenHeaderSize+size > len(data) {
+		return 0, nil, false
+	}
+
+	return data[0], data[lidgrenHeaderSize : lidgrenHeaderSize+size], true
+}
+
+// ProbeLANHost 向本地游戏服务器发送局域网发现请求，返回其发现响应的负载
+//
+// 负载由游戏写入（星露谷的协议版本等），客户端原样回放给本地游戏。
+func ProbeLANHost(gameAddr string, timeout time.Duration) ([]byte, error) {
+	conn, err := net.Dial("udp", gameAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach game server %s: %w", gameAddr, err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(encodeLidgrenMessage(lidgrenMessageTypeDiscovery, nil)); err != nil {
+		return nil, fmt.Errorf("failed to send discovery request: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	conn.SetReadDeadline(deadline)
+
+	buf := make([]byte, udpReadBufferSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("no discovery response from %s: %w", gameAddr, err)
+		}
+
+		msgType, payload, ok := parseLidgrenMessage(buf[:n])
+		if ok && msgType == lidgrenMessageTypeDiscoveryResponse {
+			return append([]byte(nil), payload...), nil
+		}
+	}
+}
+
+// DiscoveryResponder 在客户端替远程主机应答局域网发现广播
+//
+// 应答从隧道的UDP套接字发出，游戏因此会把该套接字当作主机地址，后续流量直接进入隧道。
+type DiscoveryResponder struct {
+	conn     *net.UDPConn
+	response []byte
+	mu       sync.RWMutex
+}
+
+// NewDiscoveryResponder 创建局域网发现应答器
+func NewDiscoveryResponder(conn *net.UDPConn) *DiscoveryResponder {
+	return &DiscoveryResponder{
+		conn: conn,
+	}
+}
+
+// SetHostInfo 设置远程主机的发现响应负载（通过数据通道获得）
+func (d *DiscoveryResponder) SetHostInfo(response []byte) {
+	d.mu.Lock()
+	changed := !bytes.Equal(d.response, response)
+	d.response = append([]byte(nil), response...)
+	d.mu.Unlock()
+
+	if changed {
+		log.Printf("LAN discovery: remote host info updated (%d bytes)", len(response))
+	}
+}
+
+// HasHostInfo 检查是否已收到远程主机信息
+func (d *DiscoveryResponder) HasHostInfo() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.response != nil
+}
+
+// HandlePacket 处理收到的数据报，如果是发现请求并已应答则返回true
+//
+// 尚未收到主机信息时返回false，请求会经隧道转发给真正的游戏服务器。
+func (d *DiscoveryResponder) HandlePacket(data []byte, from *net.UDPAddr) bool {
+	msgType, _, ok := parseLidgrenMessage(data)
+	if !ok || msgType != lidgrenMessageTypeDiscovery {
+		return false
+	}
+
+	d.mu.RLock()
+	response := d.response
+	d.mu.RUnlock()
+
+	if response == nil {
+		return false
+	}
+
+	if _, err := d.conn.WriteToUDP(encodeLidgrenMessage(lidgrenMessageTypeDiscoveryResponse, response), from); err != nil {
+		log.Printf("LAN discovery: failed to answer %s: %v", from, err)
+	}
+	return true
+}
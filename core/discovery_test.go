@@ -0,0 +1,111 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// discoveryTestPair 应答器的套接字和模拟游戏的套接字
+func discoveryTestPair(t *testing.T) (*net.UDPConn, *net.UDPConn) {
+	t.Helper()
+
+	loopback := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	responderConn, err := net.ListenUDP("udp", loopback)
+	if err != nil {
+		t.Fatalf("listen responder: %v", err)
+	}
+	t.Cleanup(func() { responderConn.Close() })
+
+	gameConn, err := net.ListenUDP("udp", loopback)
+	if err != nil {
+		t.Fatalf("listen game: %v", err)
+	}
+	t.Cleanup(func() { gameConn.Close() })
+
+	return responderConn, gameConn
+}
+
+// sendDiscovery 游戏发送发现请求，返回应答器收到的数据报和来源
+func sendDiscovery(t *testing.T, responderConn, gameConn *net.UDPConn) ([]byte, *net.UDPAddr) {
+	t.Helper()
+
+	request := encodeLidgrenMessage(lidgrenMessageTypeDiscovery, nil)
+	if _, err := gameConn.WriteToUDP(request, responderConn.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatalf("send discovery request: %v", err)
+	}
+
+	buf := make([]byte, udpReadBufferSize)
+	responderConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, from, err := responderConn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("responder did not receive the request: %v", err)
+	}
+	return buf[:n], from
+}
+
+// TestDiscoveryResponderAnswers 有主机信息时以137应答并带上保存的负载
+func TestDiscoveryResponderAnswers(t *testing.T) {
+	responderConn, gameConn := discoveryTestPair(t)
+
+	responder := NewDiscoveryResponder(responderConn)
+	hostInfo := []byte("Stardew Valley 1.6.15 (protocol 1)")
+	responder.SetHostInfo(hostInfo)
+	if !responder.HasHostInfo() {
+		t.Fatal("HasHostInfo = false after SetHostInfo")
+	}
+
+	packet, from := sendDiscovery(t, responderConn, gameConn)
+	if !responder.HandlePacket(packet, from) {
+		t.Fatal("HandlePacket = false, want the request answered")
+	}
+
+	buf := make([]byte, udpReadBufferSize)
+	gameConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := gameConn.Read(buf)
+	if err != nil {
+		t.Fatalf("no discovery response: %v", err)
+	}
+
+	msgType, payload, ok := parseLidgrenMessage(buf[:n])
+	if !ok {
+		t.Fatalf("malformed response %x", buf[:n])
+	}
+	if msgType != lidgrenMessageTypeDiscoveryResponse {
+		t.Fatalf("response type %d, want %d", msgType, lidgrenMessageTypeDiscoveryResponse)
+	}
+	if !bytes.Equal(payload, hostInfo) {
+		t.Fatalf("response payload %q, want %q", payload, hostInfo)
+	}
+}
+
+// TestDiscoveryResponderPassThrough 没有主机信息或不是发现请求时不应答，交给隧道转发
+func TestDiscoveryResponderPassThrough(t *testing.T) {
+	responderConn, gameConn := discoveryTestPair(t)
+	responder := NewDiscoveryResponder(responderConn)
+
+	packet, from := sendDiscovery(t, responderConn, gameConn)
+	if responder.HandlePacket(packet, from) {
+		t.Fatal("HandlePacket = true without host info, want pass-through")
+	}
+
+	responder.SetHostInfo([]byte("info"))
+	for name, data := range map[string][]byte{
+		"game data":  encodeLidgrenMessage(lidgrenMessageTypeDiscoveryResponse, []byte("x")),
+		"too short":  {lidgrenMessageTypeDiscovery, 0},
+		"bad length": {lidgrenMessageTypeDiscovery, 0, 0, 0xff, 0x00},
+	} {
+		if responder.HandlePacket(data, from) {
+			t.Errorf("HandlePacket(%s) = true, want pass-through", name)
+		}
+	}
+
+	buf := make([]byte, udpReadBufferSize)
+	gameConn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, err := gameConn.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("unexpected response %x (err %v)", buf[:n], err)
+	}
+}
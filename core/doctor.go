@@ -0,0 +1,500 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pion/stun"
+	"github.com/pion/turn/v2"
+	"github.com/pion/webrtc/v3"
+)
+
+// 诊断检查状态
+const (
+	CheckPass = "pass"
+	CheckWarn = "warn"
+	CheckFail = "fail"
+)
+
+const (
+	// defaultDoctorTimeout 每项网络检查的默认超时
+	defaultDoctorTimeout = 5 * time.Second
+	// clockSkewWarn/clockSkewFail 与信令服务器时间相差超过该值时警告/失败
+	clockSkewWarn = 10 * time.Second
+	clockSkewFail = 5 * time.Minute
+)
+
+// CheckResult 一项诊断检查的结果
+type CheckResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Detail 检查到的情况
+	Detail string `json:"detail"`
+	// Remedy 未通过时给玩家的解决办法
+	Remedy string `json:"remedy,omitempty"`
+}
+
+// DoctorConfig 环境诊断配置
+type DoctorConfig struct {
+	// SignalingURL 信令服务器WebSocket地址（如ws://host:8080/ws）
+	SignalingURL string
+	// ICEServers 额外检查的STUN/TURN服务器，默认ICE服务器和信令服务器签发的服务器总会检查
+	ICEServers []webrtc.ICEServer
+	// ModsPath Mods文件夹，为空时使用GetDefaultStardewValleyModsPath
+	ModsPath string
+	// GameAddr 本地游戏端口（加入时隧道在这里监听），为空时为127.0.0.1:24642
+	GameAddr string
+	// Timeout 每项网络检查的超时，为0时为5秒
+	Timeout time.Duration
+}
+
+// doctor 一次诊断的状态：后面的检查依赖前面的结果（房间号、服务器时间、签发的ICE服务器）
+type doctor struct {
+	config     DoctorConfig
+	httpBase   string
+	httpClient *http.Client
+	healthy    bool
+	serverTime time.Time
+	// roomID/roomICEServers 创建房间检查得到的房间号，加入房间时信令服务器签发的ICE服务器
+	roomID         string
+	roomICEServers []webrtc.ICEServer
+	// session 加入测试房间的信令连接（TURN凭据由它下发），保持到所有检查结束
+	session *SignalingClient
+}
+
+// RunDoctor 依次执行所有环境检查：信令服务器、创建房间、WebSocket加入、STUN、TURN、
+// Mods文件夹、本地游戏端口和时钟偏差
+func RunDoctor(ctx context.Context, config DoctorConfig) []CheckResult {
+	if config.Timeout <= 0 {
+		config.Timeout = defaultDoctorTimeout
+	}
+	if config.GameAddr == "" {
+		config.GameAddr = fmt.Sprintf("127.0.0.1:%d", DefaultGamePort)
+	}
+
+	d := &doctor{
+		config:     config,
+		httpBase:   signalingHTTPBase(config.SignalingURL),
+		httpClient: &http.Client{Timeout: config.Timeout},
+	}
+
+	checks := []func(context.Context) CheckResult{
+		d.checkSignaling,
+		d.checkCreateRoom,
+		d.checkJoin,
+		d.checkSTUN,
+		d.checkTURN,
+		d.checkMods,
+		d.checkGamePort,
+		d.checkClock,
+	}
+
+	results := make([]CheckResult, 0, len(checks))
+	for _, check := range checks {
+		results = append(results, check(ctx))
+	}
+
+	if d.session != nil {
+		d.session.Close()
+	}
+	return results
+}
+
+// signalingHTTPBase 信令服务器WebSocket地址对应的HTTP地址（去掉/ws）
+func signalingHTTPBase(signalingURL string) string {
+	base := strings.Replace(signalingURL, "ws://", "http://", 1)
+	base = strings.Replace(base, "wss://", "https://", 1)
+	base = strings.TrimSuffix(base, "/")
+	return strings.TrimSuffix(base, "/ws")
+}
+
+// checkSignaling 检查信令服务器/health
+func (d *doctor) checkSignaling(ctx context.Context) CheckResult {
+	result := CheckResult{Name: "Signaling server"}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.httpBase+"/health", nil)
+	if err != nil {
+		result.Status = CheckFail
+		result.Detail = fmt.Sprintf("invalid signaling URL %q: %v", d.config.SignalingURL, err)
+		result.Remedy = "Pass the server address as --signaling ws://HOST:PORT/ws."
+		return result
+	}
+
+	start := time.Now()
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		result.Status = CheckFail
+		result.Detail = fmt.Sprintf("cannot reach %s: %v", d.httpBase, err)
+		result.Remedy = "Check that the signaling server is running and that --signaling points to it. If it runs on another machine, make sure its port is open in the firewall."
+		return result
+	}
+	defer resp.Body.Close()
+	elapsed := time.Since(start)
+
+	var health struct {
+		Status      string `json:"status"`
+		Timestamp   int64  `json:"timestamp"`
+		Connections int    `json:"connections"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&health) != nil {
+		result.Status = CheckFail
+		result.Detail = fmt.Sprintf("%s/health answered with status %d", d.httpBase, resp.StatusCode)
+		result.Remedy = "The address answers but is not a stardewl signaling server. Check the --signaling URL."
+		return result
+	}
+
+	d.healthy = true
+	if health.Timestamp > 0 {
+		// 服务器时间取请求往返的中点
+		d.serverTime = time.Unix(health.Timestamp, 0).Add(-elapsed / 2)
+	}
+
+	result.Status = CheckPass
+	result.Detail = fmt.Sprintf("%s is %s (%s, %d connections)", d.httpBase, health.Status, elapsed.Round(time.Millisecond), health.Connections)
+	return result
+}
+
+// checkCreateRoom 检查能否创建房间
+func (d *doctor) checkCreateRoom(ctx context.Context) CheckResult {
+	result := CheckResult{Name: "Room creation"}
+	if !d.healthy {
+		return skippedCheck(result, "signaling server is unreachable")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.httpBase+"/create", nil)
+	if err != nil {
+		return skippedCheck(result, err.Error())
+	}
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		result.Status = CheckFail
+		result.Detail = fmt.Sprintf("failed to create a room: %v", err)
+		result.Remedy = "The server answered /health but not /create. Restart the signaling server and try again."
+		return result
+	}
+	defer resp.Body.Close()
+
+	var room struct {
+		Code       string             `json:"code"`
+		ICEServers []webrtc.ICEServer `json:"ice_servers"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&room) != nil || room.Code == "" {
+		result.Status = CheckFail
+		result.Detail = fmt.Sprintf("room creation answered with status %d", resp.StatusCode)
+		result.Remedy = "Check the signaling server logs; it may be out of connection codes or misconfigured."
+		return result
+	}
+
+	d.roomID = room.Code
+	// 旧版信令服务器在创建房间时就签发ICE服务器
+	d.roomICEServers = room.ICEServers
+
+	result.Status = CheckPass
+	result.Detail = fmt.Sprintf("created test room %s", room.Code)
+	return result
+}
+
+// checkJoin 检查能否通过WebSocket加入刚创建的房间
+func (d *doctor) checkJoin(ctx context.Context) CheckResult {
+	result := CheckResult{Name: "WebSocket join"}
+	if d.roomID == "" {
+		return skippedCheck(result, "no test room was created")
+	}
+
+	start := time.Now()
+	client, err := NewSignalingClient(d.config.SignalingURL, d.roomID, true)
+	if err != nil {
+		result.Status = CheckFail
+		result.Detail = fmt.Sprintf("failed to join room %s: %v", d.roomID, err)
+		result.Remedy = "HTTP works but WebSockets do not. A proxy or antivirus may be blocking WebSocket connections; if the server is behind a reverse proxy, enable WebSocket upgrades for /ws."
+		return result
+	}
+	d.session = client
+	d.roomICEServers = MergeICEServers(d.roomICEServers, client.ICEServers())
+
+	result.Status = CheckPass
+	result.Detail = fmt.Sprintf("joined room %s as %s (%s)", d.roomID, client.ClientID(), time.Since(start).Round(time.Millisecond))
+	if len(d.roomICEServers) > 0 {
+		result.Detail += fmt.Sprintf(", server issued %d ICE server(s)", len(d.roomICEServers))
+	}
+	return result
+}
+
+// checkSTUN 检查STUN服务器是否可达，并给出NAT类型
+func (d *doctor) checkSTUN(ctx context.Context) CheckResult {
+	result := CheckResult{Name: "STUN"}
+
+	// 最多查询natCheckMaxServers个服务器，指定的和信令服务器签发的排在前面
+	servers := MergeICEServers(d.config.ICEServers, d.roomICEServers)
+	servers = MergeICEServers(servers, GetDefaultICEServers())
+
+	report, err := CheckNAT(ctx, NATCheckConfig{Servers: servers, Timeout: d.config.Timeout / 2})
+	if err != nil {
+		result.Status = CheckFail
+		result.Detail = err.Error()
+		result.Remedy = "Check your internet connection."
+		return result
+	}
+
+	reachable := 0
+	for _, probe := range report.Probes {
+		if probe.Error == "" {
+			reachable++
+		}
+	}
+
+	result.Detail = fmt.Sprintf("%d/%d servers answered, NAT type: %s", reachable, len(report.Probes), report.Type)
+	switch report.Verdict {
+	case NATVerdictBlocked:
+		result.Status = CheckFail
+		result.Remedy = "UDP seems to be blocked. Allow stardewl through your firewall, or use a TURN server over TCP."
+	case NATVerdictUnknown:
+		result.Status = CheckWarn
+		result.Remedy = report.Summary
+	case NATVerdictRelay:
+		result.Status = CheckWarn
+		result.Remedy = report.Summary + " Ask the server owner to enable TURN (-turn)."
+	default:
+		result.Status = CheckPass
+		if reachable < len(report.Probes) {
+			result.Status = CheckWarn
+			result.Remedy = "Some STUN servers did not answer. This is fine as long as at least one does."
+		}
+	}
+	return result
+}
+
+// checkTURN 检查TURN服务器能否分配中继
+func (d *doctor) checkTURN(ctx context.Context) CheckResult {
+	result := CheckResult{Name: "TURN"}
+
+	var servers []turnTarget
+	for _, iceServer := range MergeICEServers(d.roomICEServers, d.config.ICEServers) {
+		password, _ := iceServer.Credential.(string)
+		for _, raw := range iceServer.URLs {
+			uri, err := stun.ParseURI(raw)
+			if err != nil || uri.Scheme != stun.SchemeTypeTURN {
+				continue
+			}
+			servers = append(servers, turnTarget{
+				url:      raw,
+				addr:     net.JoinHostPort(uri.Host, fmt.Sprint(uri.Port)),
+				tcp:      uri.Proto == stun.ProtoTypeTCP,
+				username: iceServer.Username,
+				password: password,
+			})
+		}
+	}
+
+	if len(servers) == 0 {
+		result.Status = CheckWarn
+		result.Detail = "no TURN server configured"
+		result.Remedy = "Players behind symmetric NAT will not be able to connect. Ask the server owner to start the signaling server with -turn or -turn-urls."
+		return result
+	}
+
+	var working []string
+	var failures []string
+	for _, server := range servers {
+		if err := server.allocate(ctx, d.config.Timeout); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", server.url, err))
+		} else {
+			working = append(working, server.url)
+		}
+	}
+
+	switch {
+	case len(failures) == 0:
+		result.Status = CheckPass
+		result.Detail = fmt.Sprintf("relay allocated on %s", strings.Join(working, ", "))
+	case len(working) > 0:
+		result.Status = CheckWarn
+		result.Detail = fmt.Sprintf("relay allocated on %s; failed on %s", strings.Join(working, ", "), strings.Join(failures, "; "))
+		result.Remedy = "At least one TURN transport works. If UDP failed, your firewall may block UDP; TCP relays are slower but usable."
+	default:
+		result.Status = CheckFail
+		result.Detail = strings.Join(failures, "; ")
+		result.Remedy = "The TURN server is unreachable or rejected the credentials. Ask the server owner to check that the TURN port (3478 UDP/TCP by default) is open and -turn-public-ip is correct."
+	}
+	return result
+}
+
+// checkMods 检查Mods文件夹
+func (d *doctor) checkMods(ctx context.Context) CheckResult {
+	result := CheckResult{Name: "Mods folder"}
+
+	path := d.config.ModsPath
+	if path == "" {
+		path = GetDefaultStardewValleyModsPath()
+		if path == "" {
+			result.Status = CheckWarn
+			result.Detail = "no Mods folder found in the default locations"
+			result.Remedy = "If you play with mods, install SMAPI and run the game once, or pass --mods with the path to your Mods folder. Without mods this is fine."
+			return result
+		}
+	}
+
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		result.Status = CheckFail
+		result.Detail = fmt.Sprintf("%s is not a folder", path)
+		result.Remedy = "Pass --mods with the path to the Mods folder inside your Stardew Valley installation."
+		return result
+	}
+
+	mods, err := ScanMods(path)
+	if err != nil {
+		result.Status = CheckFail
+		result.Detail = fmt.Sprintf("failed to scan %s: %v", path, err)
+		result.Remedy = "Make sure the folder is readable and each mod has a valid manifest.json."
+		return result
+	}
+
+	result.Status = CheckPass
+	result.Detail = fmt.Sprintf("%d mod(s) in %s", len(mods), path)
+	return result
+}
+
+// checkGamePort 检查本地游戏端口是否空闲（加入时隧道需要在这里监听）
+func (d *doctor) checkGamePort(ctx context.Context) CheckResult {
+	result := CheckResult{Name: "Game port"}
+
+	var busy []string
+	if listener, err := net.Listen("tcp", d.config.GameAddr); err != nil {
+		busy = append(busy, "TCP")
+	} else {
+		listener.Close()
+	}
+	if conn, err := net.ListenPacket("udp", d.config.GameAddr); err != nil {
+		busy = append(busy, "UDP")
+	} else {
+		conn.Close()
+	}
+
+	if len(busy) == 0 {
+		result.Status = CheckPass
+		result.Detail = fmt.Sprintf("%s is free", d.config.GameAddr)
+		return result
+	}
+
+	result.Status = CheckWarn
+	result.Detail = fmt.Sprintf("%s is in use (%s)", d.config.GameAddr, strings.Join(busy, ", "))
+	result.Remedy = "If you are hosting and Stardew Valley is running, this is expected. If you are joining, close the game's co-op server or any other stardewl instance, or use --listen with another port."
+	return result
+}
+
+// checkClock 检查本机时钟与信令服务器的偏差
+func (d *doctor) checkClock(ctx context.Context) CheckResult {
+	result := CheckResult{Name: "Clock"}
+	if d.serverTime.IsZero() {
+		result.Status = CheckWarn
+		result.Detail = "cannot compare without the signaling server's time"
+		result.Remedy = "Make sure automatic date and time is turned on in your system settings."
+		return result
+	}
+
+	skew := time.Since(d.serverTime)
+	if skew < 0 {
+		skew = -skew
+	}
+	// 服务器时间只精确到秒
+	skew = skew.Round(time.Second)
+
+	result.Detail = fmt.Sprintf("local clock differs from the server by %s", skew)
+	switch {
+	case skew > clockSkewFail:
+		result.Status = CheckFail
+		result.Remedy = "Your clock is far off; encrypted connections may fail. Turn on automatic date and time in your system settings."
+	case skew > clockSkewWarn:
+		result.Status = CheckWarn
+		result.Remedy = "Turn on automatic date and time in your system settings."
+	default:
+		result.Status = CheckPass
+	}
+	return result
+}
+
+// skippedCheck 依赖的检查失败时无法执行
+func skippedCheck(result CheckResult, reason string) CheckResult {
+	result.Status = CheckFail
+	result.Detail = "skipped: " + reason
+	result.Remedy = "Fix the checks above first."
+	return result
+}
+
+// turnTarget 要检查的TURN服务器
+type turnTarget struct {
+	url      string
+	addr     string
+	tcp      bool
+	username string
+	password string
+}
+
+// allocate 连接TURN服务器并分配一个中继地址
+func (t turnTarget) allocate(ctx context.Context, timeout time.Duration) error {
+	var conn net.PacketConn
+	if t.tcp {
+		dialer := net.Dialer{Timeout: timeout}
+		tcpConn, err := dialer.DialContext(ctx, "tcp", t.addr)
+		if err != nil {
+			return fmt.Errorf("failed to connect: %w", err)
+		}
+		conn = turn.NewSTUNConn(tcpConn)
+	} else {
+		udpConn, err := net.ListenPacket("udp4", "0.0.0.0:0")
+		if err != nil {
+			return fmt.Errorf("failed to open UDP socket: %w", err)
+		}
+		conn = udpConn
+	}
+	defer conn.Close()
+
+	client, err := turn.NewClient(&turn.ClientConfig{
+		STUNServerAddr: t.addr,
+		TURNServerAddr: t.addr,
+		Username:       t.username,
+		Password:       t.password,
+		Conn:           conn,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create TURN client: %w", err)
+	}
+	defer client.Close()
+
+	if err := client.Listen(); err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	// Allocate自己的重传可能很久，超时后关闭连接使其返回
+	done := make(chan error, 1)
+	go func() {
+		relay, err := client.Allocate()
+		if err == nil {
+			relay.Close()
+		}
+		done <- err
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("allocation failed: %w", err)
+		}
+		return nil
+	case <-timer.C:
+		conn.Close()
+		return fmt.Errorf("no response within %s", timeout)
+	case <-ctx.Done():
+		conn.Close()
+		return ctx.Err()
+	}
+}
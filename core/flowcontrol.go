@@ -0,0 +1,210 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// defaultMaxBufferedAmount 数据通道排队字节数上限
+	defaultMaxBufferedAmount = 1024 * 1024
+	// defaultLowThreshold 排队字节数降到该值以下时恢复发送
+	defaultLowThreshold = 256 * 1024
+	// defaultSendQueueSize 等待发送的消息数量上限
+	defaultSendQueueSize = 256
+)
+
+var (
+	// ErrSendQueueFull 非阻塞发送时发送队列已满
+	ErrSendQueueFull = errors.New("send queue full")
+	// ErrChannelClosed 数据通道已关闭
+	ErrChannelClosed = errors.New("data channel closed")
+)
+
+// FlowControlConfig 数据通道流量控制
+//
+// SCTP缓冲的字节数超过MaxBufferedAmount后暂停发送，直到降到LowThreshold以下；
+// 暂停期间消息在有界队列中等待，队列满时阻塞发送等待、非阻塞发送返回ErrSendQueueFull。
+type FlowControlConfig struct {
+	// MaxBufferedAmount 数据通道排队字节数上限，为0时为1MiB
+	MaxBufferedAmount uint64
+	// LowThreshold 恢复发送的排队字节数，为0时为MaxBufferedAmount的1/4
+	LowThreshold uint64
+	// QueueSize 发送队列的消息数量上限，为0时为256
+	QueueSize int
+}
+
+// DefaultFlowControlConfig 默认流量控制配置
+func DefaultFlowControlConfig() FlowControlConfig {
+	return FlowControlConfig{
+		MaxBufferedAmount: defaultMaxBufferedAmount,
+		LowThreshold:      defaultLowThreshold,
+		QueueSize:         defaultSendQueueSize,
+	}
+}
+
+// withDefaults 填充默认值
+func (c FlowControlConfig) withDefaults() FlowControlConfig {
+	if c.MaxBufferedAmount == 0 {
+		c.MaxBufferedAmount = defaultMaxBufferedAmount
+	}
+	if c.LowThreshold == 0 || c.LowThreshold > c.MaxBufferedAmount {
+		c.LowThreshold = c.MaxBufferedAmount / 4
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = defaultSendQueueSize
+	}
+	return c
+}
+
+// outgoing 队列中等待发送的消息
+type outgoing struct {
+	data []byte
+	ctx  context.Context
+	// result 阻塞发送等待发送结果，非阻塞发送为nil
+	result chan error
+}
+
+// flowControl 一个数据通道的有界发送队列，由单独的协程按SCTP缓冲情况依次发送
+type flowControl struct {
+	dc     *webrtc.DataChannel
+	config FlowControlConfig
+	queue  chan outgoing
+	// low 排队字节数降到阈值以下的通知
+	low chan struct{}
+	// onResult 每条消息发送完成（或失败）后调用，用于统计
+	onResult  func(err error)
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newFlowControl 为数据通道创建流量控制并启动发送协程
+func newFlowControl(dc *webrtc.DataChannel, config FlowControlConfig, onResult func(err error)) *flowControl {
+	config = config.withDefaults()
+	f := &flowControl{
+		dc:       dc,
+		config:   config,
+		queue:    make(chan outgoing, config.QueueSize),
+		low:      make(chan struct{}, 1),
+		onResult: onResult,
+		done:     make(chan struct{}),
+	}
+
+	dc.SetBufferedAmountLowThreshold(config.LowThreshold)
+	dc.OnBufferedAmountLow(func() {
+		select {
+		case f.low <- struct{}{}:
+		default:
+		}
+	})
+
+	go f.run()
+	return f
+}
+
+// send 阻塞发送：队列满时等待，直到消息交给SCTP（返回发送结果）或ctx结束
+func (f *flowControl) send(ctx context.Context, data []byte) error {
+	item := outgoing{
+		data:   append([]byte(nil), data...),
+		ctx:    ctx,
+		result: make(chan error, 1),
+	}
+
+	select {
+	case f.queue <- item:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-f.done:
+		return ErrChannelClosed
+	}
+
+	select {
+	case err := <-item.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-f.done:
+		return ErrChannelClosed
+	}
+}
+
+// trySend 非阻塞发送：消息放入队列后立即返回，队列满时返回ErrSendQueueFull
+func (f *flowControl) trySend(data []byte) error {
+	select {
+	case <-f.done:
+		return ErrChannelClosed
+	default:
+	}
+
+	select {
+	case f.queue <- outgoing{data: append([]byte(nil), data...)}:
+		return nil
+	default:
+		return ErrSendQueueFull
+	}
+}
+
+// run 按顺序发送队列中的消息，SCTP缓冲过多时等待缓冲降低
+func (f *flowControl) run() {
+	for {
+		select {
+		case item := <-f.queue:
+			// 阻塞发送的调用方已放弃（超时或取消）
+			if item.ctx != nil && item.ctx.Err() != nil {
+				continue
+			}
+
+			if !f.waitForBuffer(uint64(len(item.data))) {
+				return
+			}
+
+			err := f.dc.Send(item.data)
+			if err != nil {
+				log.Printf("Send on '%s' failed: %v", f.dc.Label(), err)
+			}
+			if f.onResult != nil {
+				f.onResult(err)
+			}
+			if item.result != nil {
+				item.result <- err
+			}
+		case <-f.done:
+			return
+		}
+	}
+}
+
+// waitForBuffer 等待SCTP缓冲有足够空间，通道关闭时返回false
+//
+// 缓冲不超过LowThreshold时总是允许发送（超大消息也不会永远等待），
+// 否则缓冲一定会再次降到阈值以下并触发OnBufferedAmountLow。
+func (f *flowControl) waitForBuffer(size uint64) bool {
+	for {
+		buffered := f.dc.BufferedAmount()
+		if buffered <= f.config.LowThreshold || buffered+size <= f.config.MaxBufferedAmount {
+			return true
+		}
+
+		select {
+		case <-f.low:
+		case <-f.done:
+			return false
+		}
+	}
+}
+
+// queued 队列中等待发送的消息数量
+func (f *flowControl) queued() int {
+	return len(f.queue)
+}
+
+// close 停止发送协程，队列中未发送的消息被丢弃（可重复调用）
+func (f *flowControl) close() {
+	f.closeOnce.Do(func() {
+		close(f.done)
+	})
+}
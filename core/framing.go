@@ -0,0 +1,224 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// frameMarker 分片帧的首字节（JSON消息总以'{'开头，不会与之混淆）
+	frameMarker = 0x00
+	// frameHeaderSize 分片帧头：标记(1) + 消息ID(4) + 分片序号(4) + 分片总数(4)
+	frameHeaderSize = 13
+	// pionMessageSize pion按65535字节的缓冲读取数据通道消息，更大的消息会导致对端关闭通道
+	pionMessageSize = 65535
+	// minSCTPMessageSize 分片大小的下限，忽略对端声明的过小的max-message-size
+	minSCTPMessageSize = 1024
+	// defaultMaxReassembledSize 重组后单条消息的默认上限
+	defaultMaxReassembledSize = 16 * 1024 * 1024
+	// defaultReassemblyTimeout 等待一条消息全部分片的默认时间
+	defaultReassemblyTimeout = 30 * time.Second
+	// maxPendingMessages 同时重组的消息数上限
+	maxPendingMessages = 16
+)
+
+// ErrMessageTooLarge 消息超过FramingConfig.MaxReassembledSize
+var ErrMessageTooLarge = errors.New("message too large")
+
+// FramingConfig 控制通道大消息的分片与重组
+//
+// 超过SCTP单条消息上限（见Connection.MaxMessageSize）的消息拆成编号的分片发送，
+// 对端按序重组；超过MaxReassembledSize或在ReassemblyTimeout内没有收齐的消息被丢弃。
+type FramingConfig struct {
+	// MaxReassembledSize 单条消息（重组后）的上限，发送和接收都检查，为0时为16MiB
+	MaxReassembledSize int
+	// ReassemblyTimeout 从收到第一个分片起等待其余分片的时间，为0时为30秒
+	ReassemblyTimeout time.Duration
+}
+
+// withDefaults 填充默认值
+func (c FramingConfig) withDefaults() FramingConfig {
+	if c.MaxReassembledSize <= 0 {
+		c.MaxReassembledSize = defaultMaxReassembledSize
+	}
+	if c.ReassemblyTimeout <= 0 {
+		c.ReassemblyTimeout = defaultReassemblyTimeout
+	}
+	return c
+}
+
+// sctpMaxMessageSize 从远程SDP的max-message-size属性计算单条消息上限
+//
+// 属性缺失时为64KiB（RFC 8841），为0表示对端不限制；结果不超过pion能接收的65535字节。
+func sctpMaxMessageSize(sdp string) int {
+	size := pionMessageSize
+	for _, line := range strings.Split(sdp, "\n") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(line), "a=max-message-size:")
+		if !ok {
+			continue
+		}
+		remote, err := strconv.Atoi(value)
+		if err != nil || remote <= 0 {
+			continue
+		}
+		if remote < size {
+			size = remote
+		}
+	}
+	if size < minSCTPMessageSize {
+		size = minSCTPMessageSize
+	}
+	return size
+}
+
+// isFrame 是否为分片帧
+func isFrame(data []byte) bool {
+	return len(data) >= frameHeaderSize && data[0] == frameMarker
+}
+
+// splitFrames 把消息拆成不超过maxSize的分片帧；不需要分片时原样返回
+//
+// 以frameMarker开头的消息即使很短也要分片，否则对端会把它当成分片帧。
+func splitFrames(data []byte, id uint32, maxSize int) [][]byte {
+	if len(data) <= maxSize && (len(data) == 0 || data[0] != frameMarker) {
+		return [][]byte{data}
+	}
+
+	chunkSize := maxSize - frameHeaderSize
+	count := (len(data) + chunkSize - 1) / chunkSize
+	frames := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		chunk := data[i*chunkSize : min(len(data), (i+1)*chunkSize)]
+
+		frame := make([]byte, frameHeaderSize+len(chunk))
+		frame[0] = frameMarker
+		binary.BigEndian.PutUint32(frame[1:5], id)
+		binary.BigEndian.PutUint32(frame[5:9], uint32(i))
+		binary.BigEndian.PutUint32(frame[9:13], uint32(count))
+		copy(frame[frameHeaderSize:], chunk)
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// partialMessage 正在重组的消息
+type partialMessage struct {
+	data  []byte
+	next  uint32
+	count uint32
+	timer *time.Timer
+}
+
+// reassembler 重组对端发来的分片（控制通道有序，分片按序到达）
+type reassembler struct {
+	config  FramingConfig
+	pending map[uint32]*partialMessage
+	// onDrop 丢弃不完整或超限的消息时调用
+	onDrop func(err error)
+	mu     sync.Mutex
+}
+
+// newReassembler 创建重组器
+func newReassembler(config FramingConfig, onDrop func(err error)) *reassembler {
+	return &reassembler{
+		config:  config.withDefaults(),
+		pending: make(map[uint32]*partialMessage),
+		onDrop:  onDrop,
+	}
+}
+
+// add 处理一个分片帧，消息收齐时返回重组后的消息
+func (r *reassembler) add(frame []byte) []byte {
+	id := binary.BigEndian.Uint32(frame[1:5])
+	index := binary.BigEndian.Uint32(frame[5:9])
+	count := binary.BigEndian.Uint32(frame[9:13])
+	chunk := frame[frameHeaderSize:]
+
+	r.mu.Lock()
+	partial, exists := r.pending[id]
+
+	if !exists {
+		if index != 0 {
+			// 之前的分片已因超时或超限被丢弃
+			r.mu.Unlock()
+			return nil
+		}
+		if count == 0 || uint64(count-1)*uint64(len(chunk)) > uint64(r.config.MaxReassembledSize) {
+			r.mu.Unlock()
+			r.onDrop(fmt.Errorf("message %d: %d chunks of %d bytes exceed %d bytes: %w", id, count, len(chunk), r.config.MaxReassembledSize, ErrMessageTooLarge))
+			return nil
+		}
+		if len(r.pending) >= maxPendingMessages {
+			r.mu.Unlock()
+			r.onDrop(fmt.Errorf("message %d: too many incomplete messages", id))
+			return nil
+		}
+
+		partial = &partialMessage{count: count}
+		partial.timer = time.AfterFunc(r.config.ReassemblyTimeout, func() {
+			r.expire(id, partial)
+		})
+		r.pending[id] = partial
+	}
+
+	if index != partial.next || count != partial.count {
+		r.removeLocked(id)
+		r.mu.Unlock()
+		r.onDrop(fmt.Errorf("message %d: unexpected chunk %d/%d", id, index, count))
+		return nil
+	}
+	if len(partial.data)+len(chunk) > r.config.MaxReassembledSize {
+		r.removeLocked(id)
+		r.mu.Unlock()
+		r.onDrop(fmt.Errorf("message %d exceeds %d bytes: %w", id, r.config.MaxReassembledSize, ErrMessageTooLarge))
+		return nil
+	}
+
+	partial.data = append(partial.data, chunk...)
+	partial.next++
+	if partial.next < partial.count {
+		r.mu.Unlock()
+		return nil
+	}
+
+	r.removeLocked(id)
+	r.mu.Unlock()
+	return partial.data
+}
+
+// expire 分片没有在超时前收齐，丢弃该消息
+func (r *reassembler) expire(id uint32, partial *partialMessage) {
+	r.mu.Lock()
+	if r.pending[id] != partial {
+		r.mu.Unlock()
+		return
+	}
+	received := partial.next
+	r.removeLocked(id)
+	r.mu.Unlock()
+
+	r.onDrop(fmt.Errorf("message %d: only %d/%d chunks received within %s", id, received, partial.count, r.config.ReassemblyTimeout))
+}
+
+// removeLocked 移除正在重组的消息（调用方持有mu）
+func (r *reassembler) removeLocked(id uint32) {
+	if partial, ok := r.pending[id]; ok {
+		partial.timer.Stop()
+		delete(r.pending, id)
+	}
+}
+
+// reset 丢弃所有未完成的消息（连接关闭）
+func (r *reassembler) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id := range r.pending {
+		r.removeLocked(id)
+	}
+}
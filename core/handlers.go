@@ -0,0 +1,160 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// 自定义消息类型的命名约定：
+//
+//   - 内置消息类型（hello、mods_list等）不含"."，保留给stardewl
+//   - 自定义消息类型为"<命名空间>.<名称>"，如"readycheck.vote"、"farmnotes.add"，
+//     由小写字母、数字、"_"和"-"组成，用"."分段，至少两段
+//   - 命名空间"stardewl"保留给以后的内置扩展
+//
+// 对端没有注册处理函数时消息只会被记录并丢弃；需要确认对端支持时，
+// 可以在P2PConfig.Capabilities中声明同名能力，再用Peer.Info().Has检查。
+const (
+	// reservedNamespace 保留的命名空间
+	reservedNamespace = "stardewl"
+	// maxMessageTypeLength 消息类型的最大长度
+	maxMessageTypeLength = 64
+)
+
+// ErrInvalidMessageType 消息类型不符合自定义消息的命名约定
+var ErrInvalidMessageType = errors.New("invalid custom message type")
+
+// Peer 自定义消息处理函数看到的对端
+type Peer interface {
+	// ID 对端标识（客户端一侧主机为"host"）
+	ID() string
+	// Info 握手协商的结果
+	Info() PeerInfo
+	// Send 向该对端发送自定义消息
+	Send(msgType MessageType, payload interface{}) error
+	// Call 调用该对端的RPC方法（见rpc.go）
+	Call(ctx context.Context, method string, req interface{}) (json.RawMessage, error)
+}
+
+// HandlerFunc 自定义消息的处理函数
+//
+// 在数据通道的接收协程中按到达顺序同步调用，耗时的处理应放到单独的协程；
+// ctx在连接关闭时取消。返回的错误只会被记录。
+type HandlerFunc func(ctx context.Context, peer Peer, payload json.RawMessage) error
+
+// ValidateMessageType 检查自定义消息类型是否符合命名约定
+func ValidateMessageType(msgType MessageType) error {
+	name := string(msgType)
+	if len(name) > maxMessageTypeLength {
+		return fmt.Errorf("%w %q: longer than %d characters", ErrInvalidMessageType, name, maxMessageTypeLength)
+	}
+
+	segments := strings.Split(name, ".")
+	if len(segments) < 2 {
+		return fmt.Errorf("%w %q: expected <namespace>.<name>", ErrInvalidMessageType, name)
+	}
+	if segments[0] == reservedNamespace {
+		return fmt.Errorf("%w %q: namespace %q is reserved", ErrInvalidMessageType, name, reservedNamespace)
+	}
+	for _, segment := range segments {
+		if segment == "" {
+			return fmt.Errorf("%w %q: empty segment", ErrInvalidMessageType, name)
+		}
+		for _, r := range segment {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+				return fmt.Errorf("%w %q: unexpected character %q", ErrInvalidMessageType, name, r)
+			}
+		}
+	}
+	return nil
+}
+
+// handlerRegistry 自定义消息类型到处理函数的映射
+type handlerRegistry struct {
+	handlers map[MessageType]HandlerFunc
+	mu       sync.RWMutex
+}
+
+// register 注册处理函数，handler为nil时取消注册
+func (r *handlerRegistry) register(msgType MessageType, handler HandlerFunc) error {
+	if err := ValidateMessageType(msgType); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if handler == nil {
+		delete(r.handlers, msgType)
+		return nil
+	}
+	if r.handlers == nil {
+		r.handlers = make(map[MessageType]HandlerFunc)
+	}
+	r.handlers[msgType] = handler
+	return nil
+}
+
+// dispatch 调用消息的处理函数，没有注册时返回false
+func (r *handlerRegistry) dispatch(ctx context.Context, peer Peer, msg Message) bool {
+	r.mu.RLock()
+	handler := r.handlers[msg.Type]
+	r.mu.RUnlock()
+
+	if handler == nil {
+		return false
+	}
+	if err := handler(ctx, peer, msg.Payload); err != nil {
+		log.Printf("Handler for %s from peer %s failed: %v", msg.Type, peer.ID(), err)
+	}
+	return true
+}
+
+// peerHandle Peer的实现，包装对端的连接和握手
+type peerHandle struct {
+	id         string
+	connection *Connection
+	handshake  *handshake
+}
+
+// ID 对端标识
+func (h peerHandle) ID() string {
+	return h.id
+}
+
+// Info 握手协商的结果
+func (h peerHandle) Info() PeerInfo {
+	info, _ := h.handshake.result()
+	return info
+}
+
+// Send 向该对端发送自定义消息
+func (h peerHandle) Send(msgType MessageType, payload interface{}) error {
+	data, err := newCustomMessage(msgType, payload)
+	if err != nil {
+		return err
+	}
+	return h.connection.SendMessage(data)
+}
+
+// Call 调用该对端的RPC方法
+func (h peerHandle) Call(ctx context.Context, method string, req interface{}) (json.RawMessage, error) {
+	return h.connection.Call(ctx, method, req)
+}
+
+// newCustomMessage 创建自定义消息，消息类型必须符合命名约定
+func newCustomMessage(msgType MessageType, payload interface{}) ([]byte, error) {
+	if err := ValidateMessageType(msgType); err != nil {
+		return nil, err
+	}
+	data, err := NewMessage(msgType, payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s message: %w", msgType, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// testPeer 只有ID的Peer，用于直接调用处理函数
+type testPeer struct {
+	id string
+}
+
+func (p testPeer) ID() string     { return p.id }
+func (p testPeer) Info() PeerInfo { return PeerInfo{} }
+func (p testPeer) Send(MessageType, interface{}) error {
+	return errors.New("not connected")
+}
+func (p testPeer) Call(context.Context, string, interface{}) (json.RawMessage, error) {
+	return nil, errors.New("not connected")
+}
+
+// TestValidateMessageType 自定义消息类型的命名约定
+func TestValidateMessageType(t *testing.T) {
+	tests := []struct {
+		msgType MessageType
+		valid   bool
+	}{
+		{"readycheck.vote", true},
+		{"farmnotes.add", true},
+		{"my-mod.sub_event.v2", true},
+		{"stardewl.anything", false},
+		{"stardewl.mods.sync", false},
+		{"readycheck", false},
+		{MessageTypeHello, false},
+		{"", false},
+		{"readycheck.", false},
+		{".vote", false},
+		{"readycheck..vote", false},
+		{"ReadyCheck.vote", false},
+		{"ready check.vote", false},
+		{MessageType("a." + string(make([]byte, maxMessageTypeLength))), false},
+	}
+
+	for _, test := range tests {
+		err := ValidateMessageType(test.msgType)
+		if test.valid && err != nil {
+			t.Errorf("%q rejected: %v", test.msgType, err)
+		}
+		if !test.valid && !errors.Is(err, ErrInvalidMessageType) {
+			t.Errorf("%q: got %v, want ErrInvalidMessageType", test.msgType, err)
+		}
+	}
+}
+
+// TestRegisterHandlerRejectsInvalidType 注册时拒绝不符合约定的类型，且不会留下处理函数
+func TestRegisterHandlerRejectsInvalidType(t *testing.T) {
+	var registry handlerRegistry
+	handler := func(context.Context, Peer, json.RawMessage) error { return nil }
+
+	for _, msgType := range []MessageType{"stardewl.vote", "readycheck", MessageTypeModsList} {
+		if err := registry.register(msgType, handler); !errors.Is(err, ErrInvalidMessageType) {
+			t.Errorf("register %q: got %v, want ErrInvalidMessageType", msgType, err)
+		}
+		if registry.dispatch(context.Background(), testPeer{id: "host"}, Message{Type: msgType}) {
+			t.Errorf("%q dispatched after a rejected registration", msgType)
+		}
+	}
+}
+
+// TestHandlerDispatch 处理函数只收到注册的类型，取消注册后不再收到
+func TestHandlerDispatch(t *testing.T) {
+	var registry handlerRegistry
+
+	var votes []string
+	err := registry.register("readycheck.vote", func(ctx context.Context, peer Peer, payload json.RawMessage) error {
+		var vote struct {
+			Player string `json:"player"`
+		}
+		if err := json.Unmarshal(payload, &vote); err != nil {
+			return err
+		}
+		votes = append(votes, peer.ID()+":"+vote.Player)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	tests := []struct {
+		msgType MessageType
+		handled bool
+	}{
+		{"readycheck.vote", true},
+		{"readycheck.start", false},
+		{"farmnotes.vote", false},
+		{MessageTypePing, false},
+	}
+
+	for _, test := range tests {
+		data, err := NewMessage(test.msgType, map[string]string{"player": "Abigail"})
+		if err != nil {
+			t.Fatalf("new %s message: %v", test.msgType, err)
+		}
+		msg, err := ParseMessage(data)
+		if err != nil {
+			t.Fatalf("parse %s message: %v", test.msgType, err)
+		}
+		if handled := registry.dispatch(context.Background(), testPeer{id: "client-1"}, msg); handled != test.handled {
+			t.Errorf("%s: handled %v, want %v", test.msgType, handled, test.handled)
+		}
+	}
+
+	if len(votes) != 1 || votes[0] != "client-1:Abigail" {
+		t.Fatalf("handler got %v, want exactly the readycheck.vote message", votes)
+	}
+
+	if err := registry.register("readycheck.vote", nil); err != nil {
+		t.Fatalf("unregister: %v", err)
+	}
+	if registry.dispatch(context.Background(), testPeer{id: "client-1"}, Message{Type: "readycheck.vote"}) {
+		t.Fatal("message dispatched after the handler was unregistered")
+	}
+}
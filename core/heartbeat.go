@@ -0,0 +1,241 @@
+package core
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHeartbeatInterval 默认心跳间隔（与配置文件的heartbeat_interval一致）
+	DefaultHeartbeatInterval = 30 * time.Second
+	// heartbeatTimeoutFactor 未指定超时时，连续这么多个间隔没有收到对端消息即视为断开
+	heartbeatTimeoutFactor = 3
+)
+
+// HeartbeatConfig 数据通道心跳配置
+type HeartbeatConfig struct {
+	// Interval 发送ping的间隔，为0时使用DefaultHeartbeatInterval
+	Interval time.Duration
+	// Timeout 超过这么久没有收到对端任何消息即判定对端已断开，为0时为3个间隔
+	Timeout time.Duration
+}
+
+// DefaultHeartbeatConfig 默认心跳配置
+func DefaultHeartbeatConfig() HeartbeatConfig {
+	return HeartbeatConfig{
+		Interval: DefaultHeartbeatInterval,
+		Timeout:  heartbeatTimeoutFactor * DefaultHeartbeatInterval,
+	}
+}
+
+// withDefaults 填充默认值
+func (c HeartbeatConfig) withDefaults() HeartbeatConfig {
+	if c.Interval <= 0 {
+		c.Interval = DefaultHeartbeatInterval
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = heartbeatTimeoutFactor * c.Interval
+	}
+	return c
+}
+
+// HeartbeatStats 心跳统计
+type HeartbeatStats struct {
+	// PingsSent 已发送的ping数量
+	PingsSent uint64 `json:"pings_sent"`
+	// PongsReceived 收到的pong数量
+	PongsReceived uint64 `json:"pongs_received"`
+	// PongsLost 超时未收到pong的ping数量
+	PongsLost uint64 `json:"pongs_lost"`
+	// LastRTT 最近一次往返时间
+	LastRTT time.Duration `json:"last_rtt"`
+	// SmoothedRTT 平滑往返时间（RFC 6298）
+	SmoothedRTT time.Duration `json:"smoothed_rtt"`
+	// MinRTT/MaxRTT 最小/最大往返时间
+	MinRTT time.Duration `json:"min_rtt"`
+	MaxRTT time.Duration `json:"max_rtt"`
+	// Jitter 往返时间抖动（RFC 3550）
+	Jitter time.Duration `json:"jitter"`
+	// LastSeen 最近一次收到对端消息的时间
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// heartbeat 一条数据通道连接的心跳：定期发送带序号和时间戳的ping，
+// 根据pong统计往返时间，长时间收不到对端消息时触发超时
+type heartbeat struct {
+	config    HeartbeatConfig
+	send      func([]byte) error
+	paused    func() bool
+	onTimeout func()
+	seq       uint64
+	// pending 已发送、尚未收到pong的ping（序号 -> 发送时间）
+	pending map[uint64]time.Time
+	stats   HeartbeatStats
+	done    chan struct{}
+	stopped bool
+	mu      sync.Mutex
+}
+
+// newHeartbeat 创建心跳；paused返回true时（例如正在ICE重连）不判定超时
+func newHeartbeat(config HeartbeatConfig, send func([]byte) error, paused func() bool, onTimeout func()) *heartbeat {
+	return &heartbeat{
+		config:    config.withDefaults(),
+		send:      send,
+		paused:    paused,
+		onTimeout: onTimeout,
+		pending:   make(map[uint64]time.Time),
+		stats:     HeartbeatStats{LastSeen: time.Now()},
+		done:      make(chan struct{}),
+	}
+}
+
+// start 启动心跳循环
+func (h *heartbeat) start() {
+	go func() {
+		ticker := time.NewTicker(h.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if !h.tick() {
+					return
+				}
+			case <-h.done:
+				return
+			}
+		}
+	}()
+}
+
+// tick 检查存活并发送下一个ping，对端超时后返回false
+func (h *heartbeat) tick() bool {
+	h.mu.Lock()
+	now := time.Now()
+
+	if h.paused != nil && h.paused() {
+		// 重连期间由重连策略决定连接去留，恢复后重新计时
+		h.stats.LastSeen = now
+		h.pending = make(map[uint64]time.Time)
+		h.mu.Unlock()
+		return true
+	}
+
+	silence := now.Sub(h.stats.LastSeen)
+	if silence > h.config.Timeout {
+		h.stopLocked()
+		h.mu.Unlock()
+
+		log.Printf("No heartbeat from peer for %s, declaring it disconnected", silence.Round(time.Second))
+		if h.onTimeout != nil {
+			h.onTimeout()
+		}
+		return false
+	}
+
+	for seq, sent := range h.pending {
+		if now.Sub(sent) > h.config.Timeout {
+			delete(h.pending, seq)
+			h.stats.PongsLost++
+		}
+	}
+
+	h.seq++
+	ping := PingMessage{Seq: h.seq, Timestamp: now.UnixNano()}
+	h.pending[ping.Seq] = now
+	h.stats.PingsSent++
+	h.mu.Unlock()
+
+	msg, err := NewMessage(MessageTypePing, ping)
+	if err != nil {
+		log.Printf("Failed to create ping message: %v", err)
+		return true
+	}
+	if err := h.send(msg); err != nil {
+		log.Printf("Failed to send heartbeat: %v", err)
+	}
+	return true
+}
+
+// touch 收到对端的任何消息都说明对端仍然存活
+func (h *heartbeat) touch() {
+	h.mu.Lock()
+	h.stats.LastSeen = time.Now()
+	h.mu.Unlock()
+}
+
+// handlePong 处理pong并更新往返时间统计
+func (h *heartbeat) handlePong(pong PongMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	h.stats.LastSeen = now
+
+	// 旧版本对端的pong不带序号，只能说明对端存活
+	sent, ok := h.pending[pong.Seq]
+	if !ok {
+		return
+	}
+	delete(h.pending, pong.Seq)
+
+	rtt := now.Sub(sent)
+	h.stats.PongsReceived++
+
+	if h.stats.PongsReceived == 1 {
+		h.stats.SmoothedRTT = rtt
+		h.stats.MinRTT = rtt
+		h.stats.MaxRTT = rtt
+	} else {
+		delta := rtt - h.stats.LastRTT
+		if delta < 0 {
+			delta = -delta
+		}
+		h.stats.Jitter += (delta - h.stats.Jitter) / 16
+		h.stats.SmoothedRTT += (rtt - h.stats.SmoothedRTT) / 8
+		if rtt < h.stats.MinRTT {
+			h.stats.MinRTT = rtt
+		}
+		if rtt > h.stats.MaxRTT {
+			h.stats.MaxRTT = rtt
+		}
+	}
+	h.stats.LastRTT = rtt
+}
+
+// snapshot 获取统计快照
+func (h *heartbeat) snapshot() HeartbeatStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.stats
+}
+
+// stop 停止心跳
+func (h *heartbeat) stop() {
+	h.mu.Lock()
+	h.stopLocked()
+	h.mu.Unlock()
+}
+
+// stopLocked 停止心跳（调用方持有锁）
+func (h *heartbeat) stopLocked() {
+	if !h.stopped {
+		h.stopped = true
+		close(h.done)
+	}
+}
+
+// pongFor 根据ping生成pong：原样带回序号和时间戳，旧版本对端的空ping回复空pong
+func pongFor(payload json.RawMessage) ([]byte, error) {
+	if len(payload) == 0 {
+		return NewMessage(MessageTypePong, nil)
+	}
+
+	ping, err := ParsePing(payload)
+	if err != nil {
+		return nil, err
+	}
+	return NewMessage(MessageTypePong, PongMessage{Seq: ping.Seq, Timestamp: ping.Timestamp})
+}
@@ -0,0 +1,350 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// AppVersion stardewl程序版本
+	AppVersion = "0.1.0-alpha"
+	// ProtocolVersion 数据通道协议版本，消息格式有不兼容的改动时递增
+	ProtocolVersion = 1
+	// MinProtocolVersion 仍能互通的最低协议版本
+	MinProtocolVersion = 1
+)
+
+// 能力（hello中声明，双方都支持的才会使用）
+const (
+	// CapabilityTunnel 游戏端口隧道
+	CapabilityTunnel = "tunnel"
+	// CapabilityModSync Mod列表对比
+	CapabilityModSync = "mod-sync"
+	// CapabilityChat 聊天消息
+	CapabilityChat = "chat"
+	// CapabilityDeflate 接受deflate压缩的负载
+	CapabilityDeflate = "deflate"
+)
+
+// 握手失败的错误代码（error消息的code）
+const (
+	// ErrorCodeIncompatibleVersion 协议版本范围没有交集
+	ErrorCodeIncompatibleVersion = "incompatible_version"
+	// ErrorCodeMissingCapability 对端缺少必需的能力
+	ErrorCodeMissingCapability = "missing_capability"
+	// ErrorCodeHandshakeTimeout 对端没有发送hello（旧版本）
+	ErrorCodeHandshakeTimeout = "handshake_timeout"
+)
+
+const (
+	// handshakeTimeout 控制通道打开后等待对端hello的时间
+	handshakeTimeout = 10 * time.Second
+	// handshakeCloseDelay 拒绝对端后等待error消息发出再关闭连接
+	handshakeCloseDelay = 500 * time.Millisecond
+)
+
+// HelloMessage 控制通道打开后双方发送的第一条消息
+type HelloMessage struct {
+	// ProtocolVersion/MinProtocolVersion 支持的协议版本范围
+	ProtocolVersion    int    `json:"protocol_version"`
+	MinProtocolVersion int    `json:"min_protocol_version"`
+	AppVersion         string `json:"app_version"`
+	// Capabilities 支持的能力
+	Capabilities []string `json:"capabilities"`
+	// Required 对端必须支持的能力，缺少时拒绝连接
+	Required []string `json:"required,omitempty"`
+	// Codecs 支持的消息编码（见codec.go）
+	Codecs []string `json:"codecs,omitempty"`
+}
+
+// PeerInfo 握手协商的结果
+type PeerInfo struct {
+	// ProtocolVersion 双方使用的协议版本
+	ProtocolVersion int `json:"protocol_version"`
+	// AppVersion 对端的程序版本
+	AppVersion string `json:"app_version"`
+	// Capabilities 双方都支持的能力
+	Capabilities []string `json:"capabilities"`
+	// Codec 握手之后使用的消息编码
+	Codec string `json:"codec"`
+}
+
+// HandshakeError 握手失败：对端不兼容，或对端拒绝了本端
+type HandshakeError struct {
+	Code    string
+	Message string
+	// Remote 对端的hello，对端没有发送时为nil
+	Remote *HelloMessage
+}
+
+// Error 实现error接口
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Has 双方是否都支持该能力
+func (i PeerInfo) Has(capability string) bool {
+	return containsString(i.Capabilities, capability)
+}
+
+// newHello 本端的hello
+func newHello(capabilities, required []string) HelloMessage {
+	return HelloMessage{
+		ProtocolVersion:    ProtocolVersion,
+		MinProtocolVersion: MinProtocolVersion,
+		AppVersion:         AppVersion,
+		Capabilities:       capabilities,
+		Required:           required,
+		Codecs:             CodecNames(),
+	}
+}
+
+// negotiate 协商共同的协议版本和能力，不兼容时返回错误
+func negotiate(local, remote HelloMessage) (PeerInfo, *HandshakeError) {
+	remoteMin := remote.MinProtocolVersion
+	if remoteMin <= 0 {
+		remoteMin = remote.ProtocolVersion
+	}
+
+	version := local.ProtocolVersion
+	if remote.ProtocolVersion < version {
+		version = remote.ProtocolVersion
+	}
+	if version < local.MinProtocolVersion || version < remoteMin {
+		return PeerInfo{}, &HandshakeError{
+			Code: ErrorCodeIncompatibleVersion,
+			Message: fmt.Sprintf("peer speaks protocol %d-%d (stardewl %s), this side speaks %d-%d (stardewl %s); the older side needs to update",
+				remoteMin, remote.ProtocolVersion, remote.AppVersion, local.MinProtocolVersion, local.ProtocolVersion, local.AppVersion),
+			Remote: &remote,
+		}
+	}
+
+	var missing []string
+	for _, capability := range local.Required {
+		if !containsString(remote.Capabilities, capability) {
+			missing = append(missing, capability)
+		}
+	}
+	for _, capability := range remote.Required {
+		if !containsString(local.Capabilities, capability) {
+			missing = append(missing, capability)
+		}
+	}
+	if len(missing) > 0 {
+		return PeerInfo{}, &HandshakeError{
+			Code:    ErrorCodeMissingCapability,
+			Message: fmt.Sprintf("required capabilities not supported by both sides: %v (stardewl %s and %s)", missing, local.AppVersion, remote.AppVersion),
+			Remote:  &remote,
+		}
+	}
+
+	var common []string
+	for _, capability := range local.Capabilities {
+		if containsString(remote.Capabilities, capability) {
+			common = append(common, capability)
+		}
+	}
+
+	return PeerInfo{
+		ProtocolVersion: version,
+		AppVersion:      remote.AppVersion,
+		Capabilities:    common,
+		Codec:           negotiateCodec(local.Codecs, remote.Codecs),
+	}, nil
+}
+
+// handshake 一条连接上的hello交换：双方各自发送hello并独立协商，
+// 都完成（本端已发送、收到对端兼容的hello）后调用onComplete；
+// 不兼容时发送结构化的error消息并调用onFail
+type handshake struct {
+	local      HelloMessage
+	send       func([]byte) error
+	onComplete func(PeerInfo)
+	onFail     func(*HandshakeError)
+	sent       bool
+	remote     *PeerInfo
+	done       bool
+	timer      *time.Timer
+	mu         sync.Mutex
+}
+
+// newHandshake 创建握手，控制通道打开后调用start
+func newHandshake(local HelloMessage, send func([]byte) error, onComplete func(PeerInfo), onFail func(*HandshakeError)) *handshake {
+	return &handshake{
+		local:      local,
+		send:       send,
+		onComplete: onComplete,
+		onFail:     onFail,
+	}
+}
+
+// start 发送hello并等待对端的hello
+func (h *handshake) start() {
+	msg, err := NewMessage(MessageTypeHello, h.local)
+	if err != nil {
+		log.Printf("Failed to create hello message: %v", err)
+		return
+	}
+	// 持锁发送：对端的hello此时到达也要等本端hello发出后才能完成握手，
+	// 保证完成回调里发出的消息排在hello之后
+	h.mu.Lock()
+	if h.done {
+		h.mu.Unlock()
+		return
+	}
+	if err := h.send(msg); err != nil {
+		log.Printf("Failed to send hello: %v", err)
+	}
+	h.sent = true
+	h.timer = time.AfterFunc(handshakeTimeout, h.timeout)
+	h.mu.Unlock()
+
+	h.maybeComplete()
+}
+
+// handleHello 处理对端的hello
+func (h *handshake) handleHello(payload json.RawMessage) {
+	remote, err := ParseHello(payload)
+	if err != nil {
+		log.Printf("Failed to parse hello: %v", err)
+		return
+	}
+
+	info, rejection := negotiate(h.local, remote)
+	if rejection != nil {
+		h.reject(rejection)
+		return
+	}
+
+	h.mu.Lock()
+	if h.done || h.remote != nil {
+		h.mu.Unlock()
+		return
+	}
+	h.remote = &info
+	h.mu.Unlock()
+
+	h.maybeComplete()
+}
+
+// handleError 处理对端的error消息，是握手错误时返回true
+func (h *handshake) handleError(msg ErrorMessage) bool {
+	switch msg.Code {
+	case ErrorCodeIncompatibleVersion, ErrorCodeMissingCapability, ErrorCodeHandshakeTimeout:
+	default:
+		return false
+	}
+
+	rejection := &HandshakeError{
+		Code:    msg.Code,
+		Message: "rejected by peer: " + msg.Message,
+	}
+	if len(msg.Details) > 0 {
+		if remote, err := ParseHello(msg.Details); err == nil {
+			rejection.Remote = &remote
+		}
+	}
+
+	if h.finish() {
+		h.onFail(rejection)
+	}
+	return true
+}
+
+// result 协商结果，握手完成前返回false
+func (h *handshake) result() (PeerInfo, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.done || h.remote == nil {
+		return PeerInfo{}, false
+	}
+	return *h.remote, true
+}
+
+// completed 握手是否已成功完成
+func (h *handshake) completed() bool {
+	_, ok := h.result()
+	return ok
+}
+
+// stop 停止等待（连接关闭）
+func (h *handshake) stop() {
+	h.finish()
+}
+
+// maybeComplete 本端已发送且对端兼容时完成握手
+func (h *handshake) maybeComplete() {
+	h.mu.Lock()
+	if h.done || !h.sent || h.remote == nil {
+		h.mu.Unlock()
+		return
+	}
+	h.done = true
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+	info := *h.remote
+	h.mu.Unlock()
+
+	log.Printf("Handshake complete: protocol %d, peer stardewl %s, capabilities %v, codec %s", info.ProtocolVersion, info.AppVersion, info.Capabilities, info.Codec)
+	h.onComplete(info)
+}
+
+// timeout 对端没有在规定时间内发送hello
+func (h *handshake) timeout() {
+	h.reject(&HandshakeError{
+		Code:    ErrorCodeHandshakeTimeout,
+		Message: fmt.Sprintf("no hello from peer within %s; it is probably running an older stardewl, both sides need stardewl %s or newer", handshakeTimeout, AppVersion),
+	})
+}
+
+// reject 告知对端拒绝原因（附上本端的hello）并调用onFail
+func (h *handshake) reject(rejection *HandshakeError) {
+	if !h.finish() {
+		return
+	}
+
+	details, _ := json.Marshal(h.local)
+	msg, err := NewMessage(MessageTypeError, ErrorMessage{
+		Code:    rejection.Code,
+		Message: rejection.Message,
+		Details: details,
+	})
+	if err == nil {
+		if err := h.send(msg); err != nil {
+			log.Printf("Failed to send handshake error: %v", err)
+		}
+	}
+
+	h.onFail(rejection)
+}
+
+// finish 结束握手，已经结束时返回false
+func (h *handshake) finish() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.done {
+		return false
+	}
+	h.done = true
+	h.remote = nil
+	if h.timer != nil {
+		h.timer.Stop()
+	}
+	return true
+}
+
+// containsString 切片中是否有该字符串
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pion/ice/v2"
+	"github.com/pion/transport/v2"
+	"github.com/pion/webrtc/v3"
+)
+
+// ICE传输策略（命令行取值）
+const (
+	// ICEPolicyAll 使用所有候选（默认）
+	ICEPolicyAll = "all"
+	// ICEPolicyRelay 只使用TURN中继，适合受限网络
+	ICEPolicyRelay = "relay"
+	// ICEPolicyHost 只使用本机地址，适合同一局域网，不访问STUN/TURN
+	ICEPolicyHost = "host"
+)
+
+// ICEConfig ICE传输策略与候选过滤
+type ICEConfig struct {
+	// TransportPolicy 为ICETransportPolicyRelay时只使用中继候选
+	TransportPolicy webrtc.ICETransportPolicy
+	// HostOnly 只收集本机候选（忽略STUN/TURN服务器）
+	HostOnly bool
+	// NetworkTypes 允许的网络类型，为空表示全部（udp4/udp6/tcp4/tcp6）
+	NetworkTypes []webrtc.NetworkType
+	// Interfaces 只在这些网卡上收集候选，为空表示全部
+	Interfaces []string
+	// ExcludeInterfaces 不在这些网卡上收集候选
+	ExcludeInterfaces []string
+	// PortMin/PortMax 本地UDP端口范围，为0表示由系统分配
+	PortMin uint16
+	PortMax uint16
+	// Net 替换系统网络栈（例如pion vnet虚拟网络，用于测试），为nil时使用真实网卡
+	Net transport.Net
+}
+
+// NewICEConfig 根据命令行参数创建ICE配置
+//
+// policy为all/relay/host；networkTypes如udp4、tcp4；portRange格式为"最小-最大"，可为空。
+func NewICEConfig(policy string, networkTypes, interfaces, excludeInterfaces []string, portRange string) (ICEConfig, error) {
+	config := ICEConfig{
+		Interfaces:        interfaces,
+		ExcludeInterfaces: excludeInterfaces,
+	}
+
+	switch strings.ToLower(policy) {
+	case "", ICEPolicyAll:
+		config.TransportPolicy = webrtc.ICETransportPolicyAll
+	case ICEPolicyRelay:
+		config.TransportPolicy = webrtc.ICETransportPolicyRelay
+	case ICEPolicyHost:
+		config.HostOnly = true
+	default:
+		return ICEConfig{}, fmt.Errorf("invalid ICE policy %q (expected %s, %s or %s)", policy, ICEPolicyAll, ICEPolicyRelay, ICEPolicyHost)
+	}
+
+	for _, raw := range networkTypes {
+		networkType, err := webrtc.NewNetworkType(strings.ToLower(strings.TrimSpace(raw)))
+		if err != nil {
+			return ICEConfig{}, fmt.Errorf("invalid network type %q (expected udp4, udp6, tcp4 or tcp6)", raw)
+		}
+		config.NetworkTypes = append(config.NetworkTypes, networkType)
+	}
+
+	if portRange != "" {
+		portMin, portMax, err := parsePortRange(portRange)
+		if err != nil {
+			return ICEConfig{}, err
+		}
+		config.PortMin = portMin
+		config.PortMax = portMax
+	}
+
+	return config, nil
+}
+
+// parsePortRange 解析"最小-最大"格式的端口范围
+func parsePortRange(portRange string) (uint16, uint16, error) {
+	minStr, maxStr, ok := strings.Cut(portRange, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid port range %q (expected min-max)", portRange)
+	}
+
+	portMin, err := strconv.ParseUint(strings.TrimSpace(minStr), 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %w", portRange, err)
+	}
+	portMax, err := strconv.ParseUint(strings.TrimSpace(maxStr), 10, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %w", portRange, err)
+	}
+	if portMin == 0 || portMax < portMin {
+		return 0, 0, fmt.Errorf("invalid port range %q", portRange)
+	}
+
+	return uint16(portMin), uint16(portMax), nil
+}
+
+// iceServers 按策略过滤ICE服务器：只用本机候选时不需要STUN/TURN
+func (c ICEConfig) iceServers(servers []webrtc.ICEServer) []webrtc.ICEServer {
+	if c.HostOnly {
+		return nil
+	}
+	return servers
+}
+
+// settingEngine 生成pion的SettingEngine
+func (c ICEConfig) settingEngine() (webrtc.SettingEngine, error) {
+	var settings webrtc.SettingEngine
+
+	if c.Net != nil {
+		settings.SetNet(c.Net)
+		// mDNS总是使用真实网卡的组播，虚拟网络中无法使用
+		settings.SetICEMulticastDNSMode(ice.MulticastDNSModeDisabled)
+	}
+
+	if len(c.NetworkTypes) > 0 {
+		settings.SetNetworkTypes(c.NetworkTypes)
+	}
+
+	if len(c.Interfaces) > 0 || len(c.ExcludeInterfaces) > 0 {
+		settings.SetInterfaceFilter(func(name string) bool {
+			for _, excluded := range c.ExcludeInterfaces {
+				if name == excluded {
+					return false
+				}
+			}
+			if len(c.Interfaces) == 0 {
+				return true
+			}
+			for _, allowed := range c.Interfaces {
+				if name == allowed {
+					return true
+				}
+			}
+			return false
+		})
+	}
+
+	if c.PortMin > 0 || c.PortMax > 0 {
+		if err := settings.SetEphemeralUDPPortRange(c.PortMin, c.PortMax); err != nil {
+			return settings, fmt.Errorf("invalid UDP port range %d-%d: %w", c.PortMin, c.PortMax, err)
+		}
+	}
+
+	return settings, nil
+}
@@ -0,0 +1,561 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// 局域网模式：不需要信令服务器。
+//
+// 主机在TCP端口上充当一个只有自己房间的信令服务器，并在UDP LANDiscoveryPort上应答发现请求；
+// 客户端广播发现请求找到主机后通过TCP交换SDP，消息格式与信令服务器相同（type/data/from/to）。
+const (
+	// LANDiscoveryPort 局域网房间发现端口（UDP）
+	LANDiscoveryPort = 24650
+
+	// lanServiceName 发现报文中的服务标识
+	lanServiceName = "stardewl"
+	// lanHostClientID 局域网模式下主机的客户端ID
+	lanHostClientID = "lan-host"
+	// lanDialTimeout 连接局域网主机的超时
+	lanDialTimeout = 5 * time.Second
+)
+
+// LANRoom 局域网中发现的房间
+type LANRoom struct {
+	// RoomID 房间号（主机生成）
+	RoomID string `json:"room"`
+	// Name 主机名称
+	Name string `json:"name"`
+	// Port 主机的TCP信令端口
+	Port int `json:"port"`
+	// Addr 主机的TCP信令地址（由发现应答的来源IP和Port组成）
+	Addr string `json:"-"`
+}
+
+// lanDiscoveryMessage 发现请求与应答
+type lanDiscoveryMessage struct {
+	Service string `json:"service"`
+	Type    string `json:"type"`
+	LANRoom
+}
+
+// lanEnvelope 局域网信令消息
+type lanEnvelope struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+	From string          `json:"from,omitempty"`
+	To   string          `json:"to,omitempty"`
+}
+
+// newLANEnvelope 创建局域网信令消息
+func newLANEnvelope(msgType, from, to string, data interface{}) (lanEnvelope, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return lanEnvelope{}, fmt.Errorf("failed to serialize %s: %w", msgType, err)
+	}
+	return lanEnvelope{Type: msgType, Data: payload, From: from, To: to}, nil
+}
+
+// lanInbox 在回调设置前缓存收到的消息（与SignalingClient相同的行为）
+type lanInbox struct {
+	onMessage   func(msgType, from string, data []byte)
+	onConnected func()
+	onError     func(err error)
+	queue       []queuedMessage
+	mu          sync.Mutex
+}
+
+// setCallbacks 设置回调并处理缓存的消息
+func (b *lanInbox) setCallbacks(onMessage func(msgType, from string, data []byte), onConnected func(), onError func(err error)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.onMessage = onMessage
+	b.onConnected = onConnected
+	b.onError = onError
+
+	if onMessage != nil {
+		for _, qm := range b.queue {
+			onMessage(qm.msgType, qm.from, qm.data)
+		}
+		b.queue = nil
+	}
+}
+
+// deliver 投递一条消息
+func (b *lanInbox) deliver(msgType, from string, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.onMessage == nil {
+		b.queue = append(b.queue, queuedMessage{msgType: msgType, from: from, data: data})
+		return
+	}
+	b.onMessage(msgType, from, data)
+}
+
+// lanConn 一条局域网信令TCP连接
+type lanConn struct {
+	conn    net.Conn
+	encoder *json.Encoder
+	writeMu sync.Mutex
+}
+
+// newLANConn 包装TCP连接
+func newLANConn(conn net.Conn) *lanConn {
+	return &lanConn{
+		conn:    conn,
+		encoder: json.NewEncoder(conn),
+	}
+}
+
+// write 串行写入一条消息
+func (c *lanConn) write(env lanEnvelope) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.encoder.Encode(env)
+}
+
+// LANHost 局域网模式的主机信令（实现SignalingTransport）
+type LANHost struct {
+	roomID    string
+	name      string
+	listener  net.Listener
+	discovery net.PacketConn
+	clients   map[string]*lanConn
+	nextID    int
+	inbox     lanInbox
+	mu        sync.Mutex
+	closed    bool
+}
+
+// NewLANHost 在局域网上开放房间：监听TCP信令端口并应答发现请求
+func NewLANHost(roomID string) (*LANHost, error) {
+	listener, err := net.Listen("tcp4", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for LAN players: %w", err)
+	}
+
+	discovery, err := net.ListenPacket("udp4", fmt.Sprintf(":%d", LANDiscoveryPort))
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to listen for LAN discovery on UDP %d: %w", LANDiscoveryPort, err)
+	}
+
+	name, _ := os.Hostname()
+	host := &LANHost{
+		roomID:    roomID,
+		name:      name,
+		listener:  listener,
+		discovery: discovery,
+		clients:   make(map[string]*lanConn),
+	}
+
+	go host.acceptLoop()
+	go host.discoveryLoop()
+
+	log.Printf("LAN room %s open on %s", roomID, listener.Addr())
+	return host, nil
+}
+
+// Port 获取TCP信令端口
+func (h *LANHost) Port() int {
+	return h.listener.Addr().(*net.TCPAddr).Port
+}
+
+// discoveryLoop 应答客户端的发现请求
+func (h *LANHost) discoveryLoop() {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := h.discovery.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		var request lanDiscoveryMessage
+		if err := json.Unmarshal(buf[:n], &request); err != nil || request.Service != lanServiceName || request.Type != "discover" {
+			continue
+		}
+
+		response, _ := json.Marshal(lanDiscoveryMessage{
+			Service: lanServiceName,
+			Type:    "room",
+			LANRoom: LANRoom{
+				RoomID: h.roomID,
+				Name:   h.name,
+				Port:   h.Port(),
+			},
+		})
+		if _, err := h.discovery.WriteTo(response, from); err != nil {
+			log.Printf("LAN discovery: failed to answer %s: %v", from, err)
+		}
+	}
+}
+
+// acceptLoop 接受客户端的TCP连接
+func (h *LANHost) acceptLoop() {
+	for {
+		conn, err := h.listener.Accept()
+		if err != nil {
+			return
+		}
+		go h.serveClient(conn)
+	}
+}
+
+// serveClient 处理一个客户端：分配ID，转发其消息给主机
+func (h *LANHost) serveClient(conn net.Conn) {
+	client := newLANConn(conn)
+	defer conn.Close()
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return
+	}
+	h.nextID++
+	clientID := fmt.Sprintf("lan-%d", h.nextID)
+	h.mu.Unlock()
+
+	connected, err := newLANEnvelope("connected", "", "", map[string]string{
+		"status":    "connected",
+		"client_id": clientID,
+	})
+	if err != nil || client.write(connected) != nil {
+		return
+	}
+
+	h.mu.Lock()
+	h.clients[clientID] = client
+	h.mu.Unlock()
+
+	log.Printf("LAN player %s connected from %s", clientID, conn.RemoteAddr())
+	h.notify("client_connected", clientID)
+
+	decoder := json.NewDecoder(conn)
+	for {
+		var env lanEnvelope
+		if err := decoder.Decode(&env); err != nil {
+			break
+		}
+		// 客户端只与主机通信
+		h.inbox.deliver(env.Type, clientID, env.Data)
+	}
+
+	h.mu.Lock()
+	delete(h.clients, clientID)
+	closed := h.closed
+	h.mu.Unlock()
+
+	if !closed {
+		log.Printf("LAN player %s disconnected", clientID)
+		h.notify("client_disconnected", clientID)
+	}
+}
+
+// notify 向主机投递客户端加入/离开事件
+func (h *LANHost) notify(msgType, clientID string) {
+	data, _ := json.Marshal(map[string]string{"client_id": clientID})
+	h.inbox.deliver(msgType, "", data)
+}
+
+// SendMessage 发送消息给所有客户端
+func (h *LANHost) SendMessage(msgType string, data interface{}) error {
+	return h.SendMessageTo("", msgType, data)
+}
+
+// SendMessageTo 发送消息给指定客户端，to为空时发给所有客户端
+func (h *LANHost) SendMessageTo(to, msgType string, data interface{}) error {
+	env, err := newLANEnvelope(msgType, lanHostClientID, to, data)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return fmt.Errorf("LAN host is closed")
+	}
+	var targets []*lanConn
+	if to == "" {
+		for _, client := range h.clients {
+			targets = append(targets, client)
+		}
+	} else if client, exists := h.clients[to]; exists {
+		targets = append(targets, client)
+	}
+	h.mu.Unlock()
+
+	if to != "" && len(targets) == 0 {
+		return fmt.Errorf("LAN player %s not connected", to)
+	}
+
+	for _, client := range targets {
+		if err := client.write(env); err != nil {
+			return fmt.Errorf("failed to send %s: %w", msgType, err)
+		}
+	}
+	return nil
+}
+
+// ClientID 主机的客户端ID
+func (h *LANHost) ClientID() string {
+	return lanHostClientID
+}
+
+// HostID 主机的客户端ID
+func (h *LANHost) HostID() string {
+	return lanHostClientID
+}
+
+// ResumeToken 局域网模式不支持会话恢复
+func (h *LANHost) ResumeToken() string {
+	return ""
+}
+
+// ICEServers 局域网模式不下发ICE服务器
+func (h *LANHost) ICEServers() []webrtc.ICEServer {
+	return nil
+}
+
+// SetCallbacks 设置回调函数
+func (h *LANHost) SetCallbacks(onMessage func(msgType, from string, data []byte), onConnected func(), onError func(err error)) {
+	h.inbox.setCallbacks(onMessage, onConnected, onError)
+}
+
+// Close 关闭房间
+func (h *LANHost) Close() error {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil
+	}
+	h.closed = true
+	clients := h.clients
+	h.clients = make(map[string]*lanConn)
+	h.mu.Unlock()
+
+	h.listener.Close()
+	h.discovery.Close()
+	for _, client := range clients {
+		client.conn.Close()
+	}
+	return nil
+}
+
+// LANClient 局域网模式的客户端信令（实现SignalingTransport）
+type LANClient struct {
+	conn     *lanConn
+	clientID string
+	inbox    lanInbox
+	mu       sync.Mutex
+	closed   bool
+}
+
+// DialLANRoom 连接局域网主机
+func DialLANRoom(room LANRoom) (*LANClient, error) {
+	conn, err := net.DialTimeout("tcp", room.Addr, lanDialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LAN host %s: %w", room.Addr, err)
+	}
+
+	// 主机首先发送connected消息，其中包含分配的客户端ID
+	decoder := json.NewDecoder(conn)
+	conn.SetReadDeadline(time.Now().Add(lanDialTimeout))
+	var env lanEnvelope
+	if err := decoder.Decode(&env); err != nil || env.Type != "connected" {
+		conn.Close()
+		return nil, fmt.Errorf("LAN host %s did not accept the connection", room.Addr)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	client := &LANClient{
+		conn:     newLANConn(conn),
+		clientID: parseClientID(env.Data),
+	}
+
+	log.Printf("Connected to LAN room %s at %s (client: %s)", room.RoomID, room.Addr, client.clientID)
+
+	go client.readLoop(decoder)
+	return client, nil
+}
+
+// readLoop 接收主机的消息，主机断开时投递host_disconnected
+func (c *LANClient) readLoop(decoder *json.Decoder) {
+	for {
+		var env lanEnvelope
+		if err := decoder.Decode(&env); err != nil {
+			break
+		}
+		c.inbox.deliver(env.Type, env.From, env.Data)
+	}
+
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+
+	if !closed {
+		log.Printf("LAN host closed the connection")
+		c.inbox.deliver("host_disconnected", "", []byte(`{}`))
+	}
+}
+
+// SendMessage 发送消息给主机
+func (c *LANClient) SendMessage(msgType string, data interface{}) error {
+	return c.SendMessageTo("", msgType, data)
+}
+
+// SendMessageTo 发送消息（客户端只能发给主机）
+func (c *LANClient) SendMessageTo(to, msgType string, data interface{}) error {
+	env, err := newLANEnvelope(msgType, c.clientID, to, data)
+	if err != nil {
+		return err
+	}
+	return c.conn.write(env)
+}
+
+// ClientID 主机分配的客户端ID
+func (c *LANClient) ClientID() string {
+	return c.clientID
+}
+
+// HostID 主机的客户端ID（主机发出的消息都标注为它）
+func (c *LANClient) HostID() string {
+	return lanHostClientID
+}
+
+// ResumeToken 局域网模式不支持会话恢复
+func (c *LANClient) ResumeToken() string {
+	return ""
+}
+
+// ICEServers 局域网模式不下发ICE服务器
+func (c *LANClient) ICEServers() []webrtc.ICEServer {
+	return nil
+}
+
+// SetCallbacks 设置回调函数
+func (c *LANClient) SetCallbacks(onMessage func(msgType, from string, data []byte), onConnected func(), onError func(err error)) {
+	c.inbox.setCallbacks(onMessage, onConnected, onError)
+}
+
+// Close 断开与主机的连接
+func (c *LANClient) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	return c.conn.conn.Close()
+}
+
+// DiscoverLANRooms 广播发现请求，收集timeout内应答的局域网房间
+func DiscoverLANRooms(timeout time.Duration) ([]LANRoom, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open discovery socket: %w", err)
+	}
+	defer conn.Close()
+
+	request, _ := json.Marshal(lanDiscoveryMessage{
+		Service: lanServiceName,
+		Type:    "discover",
+	})
+
+	// 受限广播在多网卡主机上只从默认网卡发出，再向各网卡的定向广播地址和本机各发一次
+	targets := append(broadcastAddrs(), net.IPv4bcast, net.IPv4(127, 0, 0, 1))
+	sent := 0
+	for _, ip := range targets {
+		if _, err := conn.WriteTo(request, &net.UDPAddr{IP: ip, Port: LANDiscoveryPort}); err == nil {
+			sent++
+		}
+	}
+	if sent == 0 {
+		return nil, fmt.Errorf("failed to send LAN discovery request")
+	}
+
+	rooms := make(map[string]LANRoom)
+	loopback := make(map[string]bool)
+	deadline := time.Now().Add(timeout)
+	conn.SetReadDeadline(deadline)
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			// 超时即结束收集
+			break
+		}
+
+		var response lanDiscoveryMessage
+		if err := json.Unmarshal(buf[:n], &response); err != nil || response.Service != lanServiceName || response.Type != "room" {
+			continue
+		}
+
+		udpAddr, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		room := response.LANRoom
+		room.Addr = net.JoinHostPort(udpAddr.IP.String(), strconv.Itoa(room.Port))
+
+		// 同一房间可能经多个地址应答，优先保留非回环地址
+		if _, exists := rooms[room.RoomID]; exists && (!loopback[room.RoomID] || udpAddr.IP.IsLoopback()) {
+			continue
+		}
+		rooms[room.RoomID] = room
+		loopback[room.RoomID] = udpAddr.IP.IsLoopback()
+	}
+
+	result := make([]LANRoom, 0, len(rooms))
+	for _, room := range rooms {
+		result = append(result, room)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].RoomID < result[j].RoomID
+	})
+	return result, nil
+}
+
+// broadcastAddrs 获取各IPv4网卡的定向广播地址
+func broadcastAddrs() []net.IP {
+	var result []net.IP
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagBroadcast == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			ip := ipNet.IP.To4()
+			if ip == nil {
+				continue
+			}
+			broadcast := make(net.IP, 4)
+			for i := range ip {
+				broadcast[i] = ip[i] | ^ipNet.Mask[len(ipNet.Mask)-4+i]
+			}
+			result = append(result, broadcast)
+		}
+	}
+	return result
+}
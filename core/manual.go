@@ -0,0 +1,233 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// 手动模式：没有信令服务器，通过聊天窗口复制粘贴SDP。
+//
+// Offer/Answer在ICE收集完成后才生成，已包含全部候选，因此不需要交换ICE候选（非trickle）。
+const (
+	// signalBlobPrefix 粘贴块前缀（含格式版本）
+	signalBlobPrefix = "sdl1."
+	// manualPeerID 手动模式下唯一对端的客户端ID
+	manualPeerID = "manual"
+	// maxSignalBlobSize 粘贴内容的最大长度，超出视为无效输入
+	maxSignalBlobSize = 64 * 1024
+)
+
+// EncodeSignalBlob 将SDP（Connection.CreateOffer/CreateAnswer的结果）压缩编码为可粘贴的文本
+func EncodeSignalBlob(sdp string) (string, error) {
+	var buf bytes.Buffer
+	writer, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return "", fmt.Errorf("failed to compress SDP: %w", err)
+	}
+	if _, err := writer.Write([]byte(sdp)); err != nil {
+		return "", fmt.Errorf("failed to compress SDP: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("failed to compress SDP: %w", err)
+	}
+
+	return signalBlobPrefix + base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// DecodeSignalBlob 解码粘贴的文本，返回SDP（忽略聊天软件插入的空白和换行）
+func DecodeSignalBlob(blob string) (string, error) {
+	blob = strings.Join(strings.Fields(blob), "")
+	if !strings.HasPrefix(blob, signalBlobPrefix) {
+		return "", fmt.Errorf("not a stardewl connection blob (expected it to start with %q)", signalBlobPrefix)
+	}
+
+	compressed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(blob, signalBlobPrefix))
+	if err != nil {
+		return "", fmt.Errorf("connection blob is damaged: %w", err)
+	}
+
+	sdp, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(compressed)), maxSignalBlobSize))
+	if err != nil {
+		return "", fmt.Errorf("connection blob is damaged: %w", err)
+	}
+
+	var desc webrtc.SessionDescription
+	if err := json.Unmarshal(sdp, &desc); err != nil {
+		return "", fmt.Errorf("connection blob does not contain a session description: %w", err)
+	}
+
+	return string(sdp), nil
+}
+
+// ManualSignaling 手动复制粘贴的信令传输（实现SignalingTransport）
+//
+// 主机打印Offer并读取客户端粘贴回来的Answer；客户端读取Offer并打印Answer。
+type ManualSignaling struct {
+	isHost    bool
+	in        *bufio.Reader
+	out       io.Writer
+	inbox     lanInbox
+	offered   bool
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+}
+
+// NewManualSignaling 创建手动信令，in/out通常为标准输入输出
+func NewManualSignaling(isHost bool, in io.Reader, out io.Writer) *ManualSignaling {
+	return &ManualSignaling{
+		isHost: isHost,
+		in:     bufio.NewReader(in),
+		out:    out,
+		done:   make(chan struct{}),
+	}
+}
+
+// Done 在Offer/Answer交换完成（或失败）后关闭，此后不再读取输入
+func (m *ManualSignaling) Done() <-chan struct{} {
+	return m.done
+}
+
+// finish 标记交换结束
+func (m *ManualSignaling) finish() {
+	m.closeOnce.Do(func() {
+		close(m.done)
+	})
+}
+
+// SendMessage 发送消息（客户端的Answer）
+func (m *ManualSignaling) SendMessage(msgType string, data interface{}) error {
+	return m.SendMessageTo("", msgType, data)
+}
+
+// SendMessageTo 打印Offer/Answer，其他信令消息（ICE候选等）已包含在SDP中，直接忽略
+func (m *ManualSignaling) SendMessageTo(to, msgType string, data interface{}) error {
+	var key string
+	switch msgType {
+	case "offer":
+		key = "offer"
+	case "answer":
+		key = "answer"
+	default:
+		return nil
+	}
+
+	if msgType == "offer" {
+		m.mu.Lock()
+		offered := m.offered
+		m.offered = true
+		m.mu.Unlock()
+
+		if offered {
+			log.Printf("ICE restart needs a new offer/answer exchange, not supported in manual mode")
+			return nil
+		}
+	}
+
+	fields, ok := data.(map[string]string)
+	if !ok {
+		return fmt.Errorf("unexpected %s payload", msgType)
+	}
+
+	blob, err := EncodeSignalBlob(fields[key])
+	if err != nil {
+		return err
+	}
+
+	if m.isHost {
+		fmt.Fprintln(m.out, "\n=== Send this to the joining player ===")
+		fmt.Fprintln(m.out, blob)
+		fmt.Fprintln(m.out, "=== Then paste their reply below ===")
+		go m.readRemote("answer")
+	} else {
+		fmt.Fprintln(m.out, "\n=== Send this back to the host ===")
+		fmt.Fprintln(m.out, blob)
+		fmt.Fprintln(m.out, "===")
+		m.finish()
+	}
+	return nil
+}
+
+// readRemote 读取对端粘贴的块并作为信令消息投递
+func (m *ManualSignaling) readRemote(msgType string) {
+	var pasted strings.Builder
+	for {
+		line, err := m.in.ReadString('\n')
+		pasted.WriteString(line)
+
+		// 聊天软件可能把长文本拆成多行，逐行累积直到能够解码
+		if sdp, decodeErr := DecodeSignalBlob(pasted.String()); decodeErr == nil {
+			payload, _ := json.Marshal(map[string]string{
+				msgType:     sdp,
+				"client_id": manualPeerID,
+			})
+			m.inbox.deliver(msgType, manualPeerID, payload)
+			if m.isHost {
+				m.finish()
+			}
+			return
+		} else if strings.TrimSpace(line) != "" && (pasted.Len() > maxSignalBlobSize || !strings.HasPrefix(strings.TrimSpace(pasted.String()), signalBlobPrefix)) {
+			fmt.Fprintf(m.out, "⚠️  %v, please paste it again\n", decodeErr)
+			pasted.Reset()
+		}
+
+		if err != nil {
+			log.Printf("Manual signaling: stopped reading input: %v", err)
+			m.finish()
+			return
+		}
+	}
+}
+
+// ClientID 手动模式下的客户端ID
+func (m *ManualSignaling) ClientID() string {
+	if m.isHost {
+		return ""
+	}
+	return manualPeerID
+}
+
+// HostID 手动模式下粘贴的块都标注为唯一对端发出
+func (m *ManualSignaling) HostID() string {
+	return manualPeerID
+}
+
+// ResumeToken 手动模式不支持会话恢复
+func (m *ManualSignaling) ResumeToken() string {
+	return ""
+}
+
+// ICEServers 手动模式不下发ICE服务器
+func (m *ManualSignaling) ICEServers() []webrtc.ICEServer {
+	return nil
+}
+
+// SetCallbacks 设置回调并开始交换：主机立即为唯一的客户端创建Offer，客户端等待粘贴Offer
+func (m *ManualSignaling) SetCallbacks(onMessage func(msgType, from string, data []byte), onConnected func(), onError func(err error)) {
+	m.inbox.setCallbacks(onMessage, onConnected, onError)
+
+	if m.isHost {
+		payload, _ := json.Marshal(map[string]string{"client_id": manualPeerID})
+		m.inbox.deliver("client_connected", "", payload)
+		return
+	}
+
+	fmt.Fprintln(m.out, "\n=== Paste the host's connection blob below ===")
+	go m.readRemote("offer")
+}
+
+// Close 结束手动信令
+func (m *ManualSignaling) Close() error {
+	m.finish()
+	return nil
+}
@@ -6,6 +6,8 @@ import "encoding/json"
 type MessageType string
 
 const (
+	// Hello 握手消息，控制通道打开后双方发送的第一条消息
+	MessageTypeHello MessageType = "hello"
 	// ModsList 发送Mod列表
 	MessageTypeModsList MessageType = "mods_list"
 	// ModsComparison 发送Mod对比结果
@@ -18,12 +20,20 @@ const (
 	MessageTypePong MessageType = "pong"
 	// Error 错误消息
 	MessageTypeError MessageType = "error"
+	// LANHostInfo 主机的局域网发现响应，供客户端应答本地游戏的发现广播
+	MessageTypeLANHostInfo MessageType = "lan_host_info"
+	// Request RPC请求（见rpc.go）
+	MessageTypeRequest MessageType = "request"
+	// Response RPC成功响应，失败时回复带request_id的error消息
+	MessageTypeResponse MessageType = "response"
 )
 
 // Message 通用消息结构
 type Message struct {
 	Type    MessageType     `json:"type"`
 	Payload json.RawMessage `json:"payload,omitempty"`
+	// Encoding 负载的压缩方式（见compression.go），为空表示未压缩
+	Encoding string `json:"encoding,omitempty"`
 }
 
 // ModsListMessage Mod列表消息
@@ -36,10 +46,48 @@ type ModsComparisonMessage struct {
 	Comparison ModComparison `json:"comparison"`
 }
 
+// LANHostInfoMessage 局域网主机信息消息
+type LANHostInfoMessage struct {
+	// Response 游戏服务器返回的Lidgren发现响应负载
+	Response []byte `json:"response"`
+}
+
+// PingMessage 心跳消息
+type PingMessage struct {
+	// Seq 序号，对端在pong中原样带回
+	Seq uint64 `json:"seq"`
+	// Timestamp 发送时间（Unix纳秒）
+	Timestamp int64 `json:"ts"`
+}
+
+// PongMessage 心跳响应，带回对应ping的序号和时间戳
+type PongMessage struct {
+	Seq       uint64 `json:"seq"`
+	Timestamp int64  `json:"ts"`
+}
+
 // ErrorMessage 错误消息
 type ErrorMessage struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
+	// Details 与错误代码相关的结构化信息（握手错误时为发送方的hello）
+	Details json.RawMessage `json:"details,omitempty"`
+	// RequestID 失败的RPC请求ID，与请求无关的错误为0
+	RequestID uint64 `json:"request_id,omitempty"`
+}
+
+// RequestMessage RPC请求
+type RequestMessage struct {
+	// ID 请求ID，响应中原样带回
+	ID     uint64          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// ResponseMessage RPC成功响应
+type ResponseMessage struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
 }
 
 // NewMessage 创建新消息
@@ -62,12 +110,29 @@ func NewMessage(msgType MessageType, payload interface{}) ([]byte, error) {
 	return json.Marshal(msg)
 }
 
-// ParseMessage 解析消息
+// ParseMessage 解析消息，压缩的负载会被解压（解压后不超过默认的16MiB）
 func ParseMessage(data []byte) (Message, error) {
+	return parseMessage(data, defaultMaxReassembledSize)
+}
+
+// parseMessage 解析消息，压缩的负载解压后不超过maxInflated字节
+func parseMessage(data []byte, maxInflated int) (Message, error) {
 	var msg Message
 	if err := json.Unmarshal(data, &msg); err != nil {
 		return msg, err
 	}
+	if err := decompressPayload(&msg, maxInflated); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+// ParseHello 解析握手消息
+func ParseHello(data []byte) (HelloMessage, error) {
+	var msg HelloMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return msg, err
+	}
 	return msg, nil
 }
 
@@ -89,6 +154,54 @@ func ParseModsComparison(data []byte) (ModsComparisonMessage, error) {
 	return msg, nil
 }
 
+// ParseLANHostInfo 解析局域网主机信息消息
+func ParseLANHostInfo(data []byte) (LANHostInfoMessage, error) {
+	var msg LANHostInfoMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+// ParsePing 解析心跳消息
+func ParsePing(data []byte) (PingMessage, error) {
+	var msg PingMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+// ParsePong 解析心跳响应
+func ParsePong(data []byte) (PongMessage, error) {
+	var msg PongMessage
+	if len(data) == 0 {
+		return msg, nil
+	}
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+// ParseRequest 解析RPC请求
+func ParseRequest(data []byte) (RequestMessage, error) {
+	var msg RequestMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
+// ParseResponse 解析RPC响应
+func ParseResponse(data []byte) (ResponseMessage, error) {
+	var msg ResponseMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return msg, err
+	}
+	return msg, nil
+}
+
 // ParseError 解析错误消息
 func ParseError(data []byte) (ErrorMessage, error) {
 	var msg ErrorMessage
@@ -0,0 +1,503 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pion/stun"
+	"github.com/pion/transport/v2"
+	"github.com/pion/transport/v2/stdnet"
+	"github.com/pion/webrtc/v3"
+)
+
+// NAT映射/过滤行为（RFC 4787）
+const (
+	// NATBehaviorEndpointIndependent 与目的地址无关
+	NATBehaviorEndpointIndependent = "endpoint-independent"
+	// NATBehaviorAddressDependent 与目的IP有关
+	NATBehaviorAddressDependent = "address-dependent"
+	// NATBehaviorAddressPortDependent 与目的IP和端口都有关（映射为此行为即对称NAT）
+	NATBehaviorAddressPortDependent = "address-port-dependent"
+	// NATBehaviorUnknown 无法判断（STUN服务器应答不足或不支持RFC 5780）
+	NATBehaviorUnknown = "unknown"
+)
+
+// NAT检测结论
+const (
+	// NATVerdictDirect 与几乎所有玩家都能直连
+	NATVerdictDirect = "direct"
+	// NATVerdictLikelyDirect 对方不是对称NAT时可以直连，否则需要TURN
+	NATVerdictLikelyDirect = "likely-direct"
+	// NATVerdictRelay 对称NAT，多数情况下需要TURN中继
+	NATVerdictRelay = "relay"
+	// NATVerdictBlocked UDP被阻止，只能使用TCP上的TURN中继
+	NATVerdictBlocked = "blocked"
+	// NATVerdictUnknown 应答的STUN服务器太少，无法判断
+	NATVerdictUnknown = "unknown"
+)
+
+const (
+	// defaultNATCheckTimeout 单个STUN请求（含重传）的默认超时
+	defaultNATCheckTimeout = 2 * time.Second
+	// natCheckRetransmit STUN请求的重传间隔
+	natCheckRetransmit = 500 * time.Millisecond
+	// natCheckMaxServers 最多查询的STUN服务器数量
+	natCheckMaxServers = 6
+)
+
+// CHANGE-REQUEST标志（RFC 5780）
+const (
+	stunChangeIP   = 0x04
+	stunChangePort = 0x02
+)
+
+// errSTUNTimeout STUN请求没有应答
+var errSTUNTimeout = errors.New("no response")
+
+// NATCheckConfig NAT检测配置
+type NATCheckConfig struct {
+	// Servers 要查询的STUN服务器（使用stun:和UDP的turn: URL），为空时使用默认ICE服务器
+	Servers []webrtc.ICEServer
+	// Timeout 单个STUN请求（含重传）的超时，为0时为2秒
+	Timeout time.Duration
+	// Net 网络栈（测试时可使用pion vnet），为nil时使用系统网络
+	Net transport.Net
+}
+
+// STUNProbe 一个STUN服务器的查询结果
+type STUNProbe struct {
+	// Server ICE服务器URL
+	Server string `json:"server"`
+	// Address 解析得到的服务器地址
+	Address string `json:"address,omitempty"`
+	// Mapped 服务器看到的本机公网地址
+	Mapped string `json:"mapped,omitempty"`
+	// OtherAddress 服务器的备用地址，支持RFC 5780时才有
+	OtherAddress string        `json:"other_address,omitempty"`
+	RTT          time.Duration `json:"rtt,omitempty"`
+	Error        string        `json:"error,omitempty"`
+}
+
+// NATReport NAT检测结果
+type NATReport struct {
+	// LocalAddress 本机用于检测的UDP地址
+	LocalAddress string `json:"local_address"`
+	// PublicAddress STUN服务器看到的公网地址
+	PublicAddress string `json:"public_address,omitempty"`
+	// UDPBlocked 所有STUN服务器都没有应答
+	UDPBlocked bool `json:"udp_blocked"`
+	// NoNAT 公网地址就是本机地址
+	NoNAT bool `json:"no_nat"`
+	// Mapping/Filtering NAT映射和过滤行为（NATBehavior*）
+	Mapping   string `json:"mapping"`
+	Filtering string `json:"filtering"`
+	// Type 传统NAT分类（full cone、symmetric等）
+	Type string `json:"type"`
+	// Verdict 结论（NATVerdict*）
+	Verdict string `json:"verdict"`
+	// Summary 面向玩家的结论说明
+	Summary string      `json:"summary"`
+	Probes  []STUNProbe `json:"probes"`
+}
+
+// stunServer 解析后的STUN服务器
+type stunServer struct {
+	url  string
+	addr *net.UDPAddr
+	err  error
+}
+
+// stunResponse STUN Binding应答
+type stunResponse struct {
+	mapped *net.UDPAddr
+	other  *net.UDPAddr
+	rtt    time.Duration
+}
+
+// CheckNAT 查询多个STUN服务器，判断本机NAT的映射和过滤行为以及UDP是否被阻止
+//
+// 映射行为通过比较不同服务器看到的公网地址判断；过滤行为需要支持RFC 5780
+// （CHANGE-REQUEST）的服务器，没有时为unknown。
+func CheckNAT(ctx context.Context, config NATCheckConfig) (*NATReport, error) {
+	if len(config.Servers) == 0 {
+		config.Servers = GetDefaultICEServers()
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultNATCheckTimeout
+	}
+	if config.Net == nil {
+		n, err := stdnet.NewNet()
+		if err != nil {
+			return nil, fmt.Errorf("failed to access network: %w", err)
+		}
+		config.Net = n
+	}
+
+	servers := resolveSTUNServers(config.Net, config.Servers)
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no usable STUN servers (only stun: and UDP turn: URLs can be probed)")
+	}
+
+	conn, err := config.Net.ListenPacket("udp4", "0.0.0.0:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open UDP socket: %w", err)
+	}
+	defer conn.Close()
+
+	client := &stunClient{conn: conn, timeout: config.Timeout}
+	report := &NATReport{
+		LocalAddress: conn.LocalAddr().String(),
+		Mapping:      NATBehaviorUnknown,
+		Filtering:    NATBehaviorUnknown,
+	}
+
+	// 测试I：从同一个本地端口向每个服务器发送Binding请求
+	var answered []*stunResponse
+	var answeredServers []*net.UDPAddr
+	for _, server := range servers {
+		probe := STUNProbe{Server: server.url}
+		if server.err != nil {
+			probe.Error = server.err.Error()
+			report.Probes = append(report.Probes, probe)
+			continue
+		}
+		probe.Address = server.addr.String()
+
+		resp, err := client.query(ctx, server.addr, 0)
+		if err != nil {
+			probe.Error = err.Error()
+		} else {
+			probe.Mapped = resp.mapped.String()
+			probe.RTT = resp.rtt
+			if resp.other != nil {
+				probe.OtherAddress = resp.other.String()
+			}
+			answered = append(answered, resp)
+			answeredServers = append(answeredServers, server.addr)
+		}
+		report.Probes = append(report.Probes, probe)
+	}
+
+	if len(answered) == 0 {
+		// 没有一个服务器能解析时是DNS或网络问题，不能说明UDP被阻止
+		resolved := false
+		for _, server := range servers {
+			resolved = resolved || server.err == nil
+		}
+		if !resolved {
+			report.Type = "unknown"
+			report.Verdict = NATVerdictUnknown
+			report.Summary = "None of the STUN servers could be resolved. Check your internet connection and DNS."
+			return report, nil
+		}
+
+		report.UDPBlocked = true
+		classifyNAT(report)
+		return report, nil
+	}
+
+	report.PublicAddress = answered[0].mapped.String()
+	report.NoNAT = isLocalAddress(config.Net, answered[0].mapped, conn.LocalAddr())
+	report.Mapping = client.mappingBehavior(ctx, answered, answeredServers)
+	if report.NoNAT {
+		// 没有NAT时地址不会变化，一个服务器的应答就足够
+		report.Mapping = NATBehaviorEndpointIndependent
+	}
+	report.Filtering = client.filteringBehavior(ctx, answered, answeredServers)
+
+	classifyNAT(report)
+	return report, nil
+}
+
+// resolveSTUNServers 从ICE服务器列表中取出可以发送STUN Binding请求的UDP地址（去重）
+func resolveSTUNServers(n transport.Net, iceServers []webrtc.ICEServer) []stunServer {
+	var servers []stunServer
+	seenHosts := make(map[string]bool)
+	seenAddrs := make(map[string]bool)
+
+	for _, iceServer := range iceServers {
+		for _, raw := range iceServer.URLs {
+			if len(servers) >= natCheckMaxServers {
+				return servers
+			}
+
+			uri, err := stun.ParseURI(raw)
+			if err != nil {
+				continue
+			}
+			// TURN服务器同样应答Binding请求，但只能通过UDP探测
+			if uri.Scheme == stun.SchemeTypeSTUNS || uri.Scheme == stun.SchemeTypeTURNS ||
+				(uri.Scheme == stun.SchemeTypeTURN && uri.Proto != stun.ProtoTypeUDP) {
+				continue
+			}
+
+			host := net.JoinHostPort(uri.Host, fmt.Sprint(uri.Port))
+			if seenHosts[host] {
+				continue
+			}
+			seenHosts[host] = true
+
+			addr, err := n.ResolveUDPAddr("udp4", host)
+			if err != nil {
+				servers = append(servers, stunServer{url: raw, err: fmt.Errorf("failed to resolve %s: %w", host, err)})
+				continue
+			}
+			// 不同主机名可能解析到同一地址
+			if seenAddrs[addr.String()] {
+				continue
+			}
+			seenAddrs[addr.String()] = true
+			servers = append(servers, stunServer{url: raw, addr: addr})
+		}
+	}
+	return servers
+}
+
+// mappingBehavior 判断映射行为
+//
+// 所有服务器看到的地址相同即与目的地址无关。地址不同时，需要同一IP不同端口的对比
+// （同IP的两个服务器，或RFC 5780的OTHER-ADDRESS）才能区分address-dependent，
+// 无法区分时按address-port-dependent处理，两者对P2P打洞的影响相同。
+func (c *stunClient) mappingBehavior(ctx context.Context, answered []*stunResponse, servers []*net.UDPAddr) string {
+	if len(answered) < 2 && answered[0].other == nil {
+		return NATBehaviorUnknown
+	}
+
+	same := true
+	for _, resp := range answered[1:] {
+		if resp.mapped.String() != answered[0].mapped.String() {
+			same = false
+		}
+	}
+
+	// 只有一个服务器应答但支持RFC 5780：向备用IP再查询一次
+	if len(answered) == 1 {
+		other := &net.UDPAddr{IP: answered[0].other.IP, Port: servers[0].Port}
+		resp, err := c.query(ctx, other, 0)
+		if err != nil {
+			return NATBehaviorUnknown
+		}
+		if resp.mapped.String() == answered[0].mapped.String() {
+			return NATBehaviorEndpointIndependent
+		}
+		same = false
+		answered = append(answered, resp)
+		servers = append(servers, other)
+	}
+
+	if same {
+		return NATBehaviorEndpointIndependent
+	}
+
+	// 同一IP不同端口的服务器看到的地址相同：映射只与目的IP有关
+	for i := range servers {
+		for j := i + 1; j < len(servers); j++ {
+			if servers[i].IP.Equal(servers[j].IP) && servers[i].Port != servers[j].Port {
+				if answered[i].mapped.String() == answered[j].mapped.String() {
+					return NATBehaviorAddressDependent
+				}
+				return NATBehaviorAddressPortDependent
+			}
+		}
+	}
+
+	// RFC 5780测试III：向备用IP的主端口和备用端口各查询一次
+	for i, resp := range answered {
+		if resp.other == nil {
+			continue
+		}
+		first, err := c.query(ctx, &net.UDPAddr{IP: resp.other.IP, Port: servers[i].Port}, 0)
+		if err != nil {
+			continue
+		}
+		second, err := c.query(ctx, resp.other, 0)
+		if err != nil {
+			continue
+		}
+		if first.mapped.String() == second.mapped.String() {
+			return NATBehaviorAddressDependent
+		}
+		return NATBehaviorAddressPortDependent
+	}
+
+	return NATBehaviorAddressPortDependent
+}
+
+// filteringBehavior 判断过滤行为：请求支持RFC 5780的服务器从其他IP/端口应答
+func (c *stunClient) filteringBehavior(ctx context.Context, answered []*stunResponse, servers []*net.UDPAddr) string {
+	for i, resp := range answered {
+		if resp.other == nil {
+			continue
+		}
+
+		// 测试II：从备用IP和端口应答，能收到说明任何地址都能发进来
+		if _, err := c.query(ctx, servers[i], stunChangeIP|stunChangePort); err == nil {
+			return NATBehaviorEndpointIndependent
+		}
+		// 测试III：只换端口
+		if _, err := c.query(ctx, servers[i], stunChangePort); err == nil {
+			return NATBehaviorAddressDependent
+		}
+		return NATBehaviorAddressPortDependent
+	}
+	return NATBehaviorUnknown
+}
+
+// classifyNAT 根据映射和过滤行为得出NAT类型和结论
+func classifyNAT(report *NATReport) {
+	switch {
+	case report.UDPBlocked:
+		report.Type = "UDP blocked"
+		report.Verdict = NATVerdictBlocked
+		report.Summary = "No STUN server answered over UDP. Direct connections are impossible; a TURN relay over TCP or TLS is required."
+		return
+	case report.NoNAT:
+		report.Type = "no NAT"
+	case report.Mapping == NATBehaviorUnknown:
+		report.Type = "unknown"
+	case report.Mapping != NATBehaviorEndpointIndependent:
+		report.Type = "symmetric"
+	case report.Filtering == NATBehaviorEndpointIndependent:
+		report.Type = "full cone"
+	case report.Filtering == NATBehaviorAddressDependent:
+		report.Type = "restricted cone"
+	case report.Filtering == NATBehaviorAddressPortDependent:
+		report.Type = "port-restricted cone"
+	default:
+		report.Type = "cone"
+	}
+
+	switch {
+	case report.Mapping == NATBehaviorUnknown:
+		report.Verdict = NATVerdictUnknown
+		report.Summary = "Only one STUN server answered, so the NAT type could not be determined. Try again with more servers (--stun)."
+	case report.Mapping != NATBehaviorEndpointIndependent:
+		report.Verdict = NATVerdictRelay
+		report.Summary = "Symmetric NAT: direct connections only work if the other player has an open or full-cone NAT. Expect to need a TURN relay."
+	case report.NoNAT || report.Filtering == NATBehaviorEndpointIndependent || report.Filtering == NATBehaviorAddressDependent:
+		report.Verdict = NATVerdictDirect
+		report.Summary = "Direct connections should work with almost everyone."
+	default:
+		report.Verdict = NATVerdictLikelyDirect
+		report.Summary = "Direct connections should work unless the other player is behind a symmetric NAT, in which case a TURN relay is needed."
+	}
+}
+
+// isLocalAddress 公网地址是否就是本机网卡地址（没有NAT）
+func isLocalAddress(n transport.Net, mapped *net.UDPAddr, local net.Addr) bool {
+	localAddr, ok := local.(*net.UDPAddr)
+	if !ok || localAddr.Port != mapped.Port {
+		return false
+	}
+
+	interfaces, err := n.Interfaces()
+	if err != nil {
+		return false
+	}
+	for _, iface := range interfaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(mapped.IP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stunClient 在同一个UDP套接字上依次发送STUN请求（映射行为与本地端口有关）
+type stunClient struct {
+	conn    net.PacketConn
+	timeout time.Duration
+}
+
+// query 发送Binding请求并等待应答（按事务ID匹配，CHANGE-REQUEST的应答来自其他地址）
+func (c *stunClient) query(ctx context.Context, server *net.UDPAddr, change byte) (*stunResponse, error) {
+	setters := []stun.Setter{stun.TransactionID, stun.BindingRequest}
+	if change != 0 {
+		setters = append(setters, stun.RawAttribute{Type: stun.AttrChangeRequest, Value: []byte{0, 0, 0, change}})
+	}
+	req, err := stun.Build(setters...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build STUN request: %w", err)
+	}
+
+	deadline := time.Now().Add(c.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	start := time.Now()
+	buf := make([]byte, 1500)
+	for time.Now().Before(deadline) && ctx.Err() == nil {
+		if _, err := c.conn.WriteTo(req.Raw, server); err != nil {
+			return nil, fmt.Errorf("failed to send to %s: %w", server, err)
+		}
+
+		wait := time.Now().Add(natCheckRetransmit)
+		if wait.After(deadline) {
+			wait = deadline
+		}
+		c.conn.SetReadDeadline(wait)
+
+		for {
+			n, _, err := c.conn.ReadFrom(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				return nil, fmt.Errorf("failed to read STUN response: %w", err)
+			}
+
+			resp := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+			// 之前请求的迟到应答或非STUN数据
+			if resp.Decode() != nil || resp.TransactionID != req.TransactionID {
+				continue
+			}
+			return parseSTUNResponse(resp, time.Since(start))
+		}
+	}
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return nil, errSTUNTimeout
+}
+
+// parseSTUNResponse 解析Binding应答中的映射地址和备用地址
+func parseSTUNResponse(resp *stun.Message, rtt time.Duration) (*stunResponse, error) {
+	if resp.Type.Class == stun.ClassErrorResponse {
+		var code stun.ErrorCodeAttribute
+		if err := code.GetFrom(resp); err == nil {
+			return nil, fmt.Errorf("STUN error %d: %s", code.Code, code.Reason)
+		}
+		return nil, fmt.Errorf("STUN error response")
+	}
+
+	result := &stunResponse{rtt: rtt}
+
+	var xorAddr stun.XORMappedAddress
+	var addr stun.MappedAddress
+	if err := xorAddr.GetFrom(resp); err == nil {
+		result.mapped = &net.UDPAddr{IP: xorAddr.IP, Port: xorAddr.Port}
+	} else if err := addr.GetFrom(resp); err == nil {
+		result.mapped = &net.UDPAddr{IP: addr.IP, Port: addr.Port}
+	} else {
+		return nil, fmt.Errorf("STUN response without mapped address")
+	}
+
+	var other stun.MappedAddress
+	if err := other.GetFromAs(resp, stun.AttrOtherAddress); err == nil {
+		result.other = &net.UDPAddr{IP: other.IP, Port: other.Port}
+	}
+
+	return result, nil
+}
@@ -1,34 +1,60 @@
 package core
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"time"
 	"log"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/pion/webrtc/v3"
 )
 
 // P2PConnector P2P连接器
+//
+// 主机为每个加入的客户端建立独立的PeerConnection，客户端只连接主机。
 type P2PConnector struct {
-	signalingClient *SignalingClient
-	connection      *Connection
-	roomID          string
-	isHost          bool
-	modsPath        string
-	onModsChecked   func(ModComparison)
-	onConnected     func()
-	onDisconnected  func()
-	mu              sync.RWMutex
-	connected       bool
-	// ICE候选队列：当远程描述未设置时缓存ICE候选
-	pendingICECandidates []webrtc.ICECandidateInit
-	pendingICEMu         sync.RWMutex
-	// 简化心跳机制
-	heartbeatDone chan bool
+	signalingClient   SignalingTransport
+	roomID            string
+	isHost            bool
+	modsPath          string
+	connConfig        ConnectionConfig
+	tunnelConfig      TunnelConfig
+	heartbeatConfig   HeartbeatConfig
+	hello             HelloMessage
+	maxPeers          int
+	onModsChecked     func(ModComparison)
+	onConnected       func()
+	onDisconnected    func()
+	onReconnecting    func(clientID string, attempt int)
+	onReconnected     func(clientID string)
+	onBulkChannel     func(clientID string, channel *BulkChannel)
+	onHandshakeFailed func(clientID string, err *HandshakeError)
+	mu                sync.RWMutex
+	connected         bool
+	// stateChanged 对端连接或断开时关闭并替换，供WaitForPeers等待
+	stateChanged chan struct{}
+	// 对端：主机按客户端ID索引，客户端只有hostPeerID一个
+	peers map[string]*peer
+	// joining 主机正在为其创建连接、尚未登记的客户端，同样占用名额
+	joining map[string]struct{}
+	// 局域网主机信息广播
+	lanAnnounceDone chan struct{}
+	// methods 注册到每个对端连接上的RPC方法（见rpc.go）
+	methods map[string]RPCHandler
+	// handlers 自定义消息的处理函数（见handlers.go）
+	handlers handlerRegistry
 }
 
+const (
+	// lanAnnounceInterval 主机探测本地游戏并同步局域网发现信息的间隔
+	lanAnnounceInterval = 10 * time.Second
+	// lanProbeTimeout 探测本地游戏服务器的超时
+	lanProbeTimeout = 2 * time.Second
+)
+
 // P2PConfig P2P配置
 type P2PConfig struct {
 	SignalingURL string
@@ -36,44 +62,151 @@ type P2PConfig struct {
 	IsHost       bool
 	ModsPath     string
 	ICEServers   []webrtc.ICEServer
+	// ICE 传输策略与候选过滤（仅中继、仅本机、网卡/网络类型、端口范围）
+	ICE ICEConfig
+	// Tunnel 游戏端口隧道配置
+	Tunnel TunnelConfig
+	// MaxPeers 主机最多接受的客户端数量（默认 MaxFarmhands）
+	MaxPeers int
+	// Reconnect 断线重连策略（零值表示断开即关闭）
+	Reconnect ReconnectPolicy
+	// FlowControl 数据通道流量控制（零值使用默认值）
+	FlowControl FlowControlConfig
+	// Bulk 大文件传输（Mod、存档）的带宽限制，游戏流量始终优先
+	Bulk BulkConfig
+	// Framing 控制通道大消息（如大型Mod列表）的分片与重组（零值使用默认值）
+	Framing FramingConfig
+	// Compression 大负载（Mod列表、对比结果）的deflate压缩，对端支持时才使用
+	Compression CompressionConfig
+	// Heartbeat 数据通道心跳（零值使用默认间隔和超时）
+	Heartbeat HeartbeatConfig
+	// ResumeToken 客户端之前获得的恢复令牌，用于回到原来的位置
+	ResumeToken string
+	// Signaling 自定义信令传输（如局域网模式），设置后忽略SignalingURL
+	Signaling SignalingTransport
+	// Capabilities 额外声明的能力（如CapabilityChat）；mod-sync始终声明，tunnel在启用隧道时声明
+	Capabilities []string
+	// RequiredCapabilities 对端必须支持的能力，缺少时拒绝连接
+	RequiredCapabilities []string
+	// Codecs 允许使用的消息编码（CodecCBOR、CodecJSON），为空时全部允许；只允许JSON便于抓包调试
+	Codecs []string
 }
 
 // NewP2PConnector 创建新的P2P连接器
 func NewP2PConnector(config P2PConfig) (*P2PConnector, error) {
-	// 先创建P2P连接器（但不立即创建信令客户端）
-	connector := &P2PConnector{
-		roomID:    config.RoomID,
-		isHost:    config.IsHost,
-		modsPath:  config.ModsPath,
-		connected: false,
+	maxPeers := config.MaxPeers
+	if maxPeers <= 0 || maxPeers > MaxFarmhands {
+		maxPeers = MaxFarmhands
 	}
 
-	// 创建WebRTC连接配置
-	connConfig := ConnectionConfig{
-		ICEServers: config.ICEServers,
+	hello := newHello(localCapabilities(config), config.RequiredCapabilities)
+	if len(config.Codecs) > 0 {
+		for _, name := range config.Codecs {
+			if _, ok := CodecByName(name); !ok {
+				return nil, fmt.Errorf("unknown codec %q (supported: %v)", name, CodecNames())
+			}
+		}
+		hello.Codecs = config.Codecs
 	}
 
-	// 创建WebRTC连接
-	connection, err := NewConnection(config.RoomID, config.IsHost, connConfig)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create WebRTC connection: %w", err)
+	// 先创建P2P连接器
+	connector := &P2PConnector{
+		roomID:   config.RoomID,
+		isHost:   config.IsHost,
+		modsPath: config.ModsPath,
+		connConfig: ConnectionConfig{
+			ICEServers:  append([]webrtc.ICEServer(nil), config.ICEServers...),
+			ICE:         config.ICE,
+			Reconnect:   config.Reconnect,
+			FlowControl: config.FlowControl,
+			Bulk:        config.Bulk,
+			Framing:     config.Framing,
+			Compression: config.Compression,
+		},
+		tunnelConfig:    config.Tunnel,
+		heartbeatConfig: config.Heartbeat,
+		hello:           hello,
+		maxPeers:        maxPeers,
+		connected:       false,
+		stateChanged:    make(chan struct{}),
+		peers:           make(map[string]*peer),
+		joining:         make(map[string]struct{}),
+		methods:         make(map[string]RPCHandler),
+	}
+	connector.methods[MethodCompareMods] = connector.handleCompareMods
+
+	// 先连接信令服务器：服务器可能下发ICE服务器（例如内置TURN），创建连接前需要知道
+	var signalingClient SignalingTransport
+	var err error
+	if config.Signaling != nil {
+		signalingClient = config.Signaling
+	} else if !config.IsHost && config.ResumeToken != "" {
+		signalingClient, err = ResumeSignalingClient(config.SignalingURL, config.RoomID, config.ResumeToken)
+	} else {
+		signalingClient, err = NewSignalingClient(config.SignalingURL, config.RoomID, config.IsHost)
 	}
-
-	connector.connection = connection
-
-	// 现在创建信令客户端（确保回调已经设置）
-	signalingClient, err := NewSignalingClient(config.SignalingURL, config.RoomID, config.IsHost)
 	if err != nil {
-		connection.Close()
 		return nil, fmt.Errorf("failed to create signaling client: %w", err)
 	}
 
 	connector.signalingClient = signalingClient
+	connector.connConfig.ICEServers = MergeICEServers(connector.connConfig.ICEServers, signalingClient.ICEServers())
+
+	// 客户端只有一个对端（主机），立即创建连接等待offer；
+	// 主机在每个客户端加入时再创建连接（在此之前收到的信令消息会被缓存）
+	if !config.IsHost {
+		hostPeer, err := newPeer(hostPeerID, config.RoomID, false, connector.connConfig, connector.tunnelConfig)
+		if err != nil {
+			signalingClient.Close()
+			return nil, err
+		}
+		connector.addPeer(hostPeer)
+	}
+
+	// 设置信令客户端回调
+	log.Printf("Setting signaling client callbacks for room: %s", config.RoomID)
+	signalingClient.SetCallbacks(
+		connector.handleSignalingMessage,
+		connector.handleSignalingConnected,
+		connector.handleSignalingError,
+	)
+	log.Printf("Signaling client callbacks set successfully")
+
+	return connector, nil
+}
+
+// localCapabilities 本端在hello中声明的能力
+func localCapabilities(config P2PConfig) []string {
+	capabilities := []string{CapabilityModSync}
+	if config.Tunnel.Enabled {
+		capabilities = append(capabilities, CapabilityTunnel)
+	}
+	if !config.Compression.Disabled {
+		capabilities = append(capabilities, CapabilityDeflate)
+	}
+	for _, capability := range config.Capabilities {
+		if !containsString(capabilities, capability) {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	return capabilities
+}
+
+// addPeer 登记对端并设置其连接回调
+func (p *P2PConnector) addPeer(pr *peer) {
+	pr.handshake = newHandshake(p.hello, pr.connection.SendMessage,
+		func(info PeerInfo) {
+			p.handleHandshakeComplete(pr, info)
+		},
+		func(err *HandshakeError) {
+			p.handleHandshakeFailed(pr, err)
+		},
+	)
 
 	// 设置ICE候选回调
-	connection.peerConnection.OnICECandidate(func(candidate *webrtc.ICECandidate) {
+	pr.connection.peerConnection.OnICECandidate(func(candidate *webrtc.ICECandidate) {
 		if candidate == nil {
-			log.Printf("ICE candidate gathering complete for %s", config.RoomID)
+			log.Printf("ICE candidate gathering complete for %s (peer: %s)", p.roomID, pr.clientID)
 			return
 		}
 
@@ -84,8 +217,9 @@ func NewP2PConnector(config P2PConfig) (*P2PConnector, error) {
 		}
 
 		// 发送ICE候选到信令服务器
-		if err := signalingClient.SendMessage("ice_candidate", map[string]string{
+		if err := p.signalingClient.SendMessageTo(p.signalingTarget(pr), "ice_candidate", map[string]string{
 			"candidate": string(candidateJSON),
+			"client_id": p.signalingClientID(pr),
 		}); err != nil {
 			log.Printf("Failed to send ICE candidate: %v", err)
 		} else {
@@ -93,32 +227,103 @@ func NewP2PConnector(config P2PConfig) (*P2PConnector, error) {
 		}
 	})
 
-	// 设置信令客户端回调
-	log.Printf("Setting signaling client callbacks for room: %s", config.RoomID)
-	signalingClient.SetCallbacks(
-		connector.handleSignalingMessage,
-		connector.handleSignalingConnected,
-		connector.handleSignalingError,
+	// 设置WebRTC连接回调
+	pr.connection.SetMessageHandler(func(data []byte) {
+		p.handleDataChannelMessage(pr, data)
+	})
+	pr.connection.SetOpenHandler(func() {
+		p.handleConnectionOpen(pr)
+	})
+	pr.connection.SetCloseHandler(func() {
+		p.handleConnectionClose(pr)
+	})
+	pr.connection.SetBulkChannelHandler(func(channel *BulkChannel) {
+		p.handleBulkChannel(pr, channel)
+	})
+	pr.connection.SetReconnectHandlers(
+		func(attempt int) {
+			p.restartPeer(pr)
+		},
+		func(attempt int) {
+			p.handleReconnecting(pr, attempt)
+		},
+		func() {
+			p.handleReconnected(pr)
+		},
 	)
-	log.Printf("Signaling client callbacks set successfully")
 
-	// 设置WebRTC连接回调
-	connection.SetMessageHandler(connector.handleDataChannelMessage)
-	connection.SetCloseHandler(connector.handleConnectionClose)
+	// 持锁注册RPC方法，与HandleMethod互斥
+	p.mu.Lock()
+	for method, handler := range p.methods {
+		pr.connection.HandleMethod(method, handler)
+	}
+	p.peers[pr.clientID] = pr
+	p.mu.Unlock()
+}
 
-	return connector, nil
+// removePeer 移除并关闭对端
+func (p *P2PConnector) removePeer(clientID string) {
+	p.mu.Lock()
+	pr, exists := p.peers[clientID]
+	if exists {
+		delete(p.peers, clientID)
+	}
+	p.mu.Unlock()
+
+	if exists {
+		pr.close()
+	}
+}
+
+// getPeer 按客户端ID查找对端
+func (p *P2PConnector) getPeer(clientID string) *peer {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.peers[clientID]
+}
+
+// connectedPeers 获取所有已连接的对端
+func (p *P2PConnector) connectedPeers() []*peer {
+	p.mu.RLock()
+	peers := make([]*peer, 0, len(p.peers))
+	for _, pr := range p.peers {
+		peers = append(peers, pr)
+	}
+	p.mu.RUnlock()
+
+	connected := peers[:0]
+	for _, pr := range peers {
+		if pr.isConnected() {
+			connected = append(connected, pr)
+		}
+	}
+	return connected
+}
+
+// signalingClientID 信令消息中标识对端的客户端ID
+//
+// 主机发出的消息标注目标客户端，客户端发出的消息标注自己的ID。
+func (p *P2PConnector) signalingClientID(pr *peer) string {
+	if p.isHost {
+		return pr.clientID
+	}
+	return p.signalingClient.ClientID()
+}
+
+// signalingTarget 信令消息的接收者：主机发给对应客户端，客户端发给主机（由服务器路由）
+func (p *P2PConnector) signalingTarget(pr *peer) string {
+	if p.isHost {
+		return pr.clientID
+	}
+	return ""
 }
 
 // Start starts the P2P connection
 func (p *P2PConnector) Start() error {
 	log.Printf("Starting P2P connection for room: %s (host: %v)", p.roomID, p.isHost)
 
-	// Give signaling connection time to establish
-	time.Sleep(2 * time.Second)
-
-	log.Printf("Signaling connection established for room: %s", p.roomID)
-
-	// 如果是主机，创建并发送offer
+	// 信令连接在NewP2PConnector中已建立（加入确认后才返回），之前到达的信令消息已缓存
+	// 主机等待客户端加入后再逐个发送offer
 	if p.isHost {
 		return p.startAsHost()
 	}
@@ -129,55 +334,106 @@ func (p *P2PConnector) Start() error {
 
 // startAsHost starts as host
 func (p *P2PConnector) startAsHost() error {
-	log.Printf("Creating WebRTC Offer as host...")
-	
+	log.Printf("Waiting for clients to join (up to %d)...", p.maxPeers)
+	return nil
+}
+
+// startAsClient 作为客户端启动
+func (p *P2PConnector) startAsClient() error {
+	log.Printf("Waiting for host offer...")
+	return nil
+}
+
+// connectPeer 主机为新加入的客户端创建连接并发送offer
+func (p *P2PConnector) connectPeer(clientID string) {
+	// 每个client_connected在单独的协程中处理，检查名额和占用名额必须在同一次加锁中完成
+	p.mu.Lock()
+	_, exists := p.peers[clientID]
+	_, pending := p.joining[clientID]
+	count := len(p.peers) + len(p.joining)
+	if pending {
+		// 重复的client_connected：第一次的连接仍在建立，再建一个会覆盖并泄漏它
+		p.mu.Unlock()
+		log.Printf("Connection for client %s is already being set up, ignoring duplicate join", clientID)
+		return
+	}
+	if !exists && count >= p.maxPeers {
+		p.mu.Unlock()
+		log.Printf("Room is full (%d/%d), ignoring client %s", count, p.maxPeers, clientID)
+		return
+	}
+	p.joining[clientID] = struct{}{}
+	p.mu.Unlock()
+
+	if exists {
+		log.Printf("Client %s already has a connection, replacing it", clientID)
+		p.removePeer(clientID)
+	}
+
+	pr, err := newPeer(clientID, p.roomID, true, p.connConfig, p.tunnelConfig)
+	if err != nil {
+		log.Printf("Failed to create connection for client %s: %v", clientID, err)
+		p.releaseSlot(clientID)
+		return
+	}
+	p.addPeer(pr)
+	p.releaseSlot(clientID)
+
+	log.Printf("Creating WebRTC Offer for client %s...", clientID)
+
 	// Create offer
-	offer, err := p.connection.CreateOffer()
+	offer, err := pr.connection.CreateOffer()
 	if err != nil {
-		return fmt.Errorf("failed to create offer: %w", err)
+		log.Printf("Failed to create offer for client %s: %v", clientID, err)
+		p.removePeer(clientID)
+		return
 	}
-	
+
 	log.Printf("Offer created successfully, length: %d bytes", len(offer))
 
 	// 发送offer到信令服务器
-	if err := p.signalingClient.SendMessage("offer", map[string]string{
-		"offer": offer,
+	if err := p.signalingClient.SendMessageTo(clientID, "offer", map[string]string{
+		"offer":     offer,
+		"client_id": clientID,
 	}); err != nil {
-		return fmt.Errorf("failed to send offer: %w", err)
+		log.Printf("Failed to send offer to client %s: %v", clientID, err)
+		p.removePeer(clientID)
+		return
 	}
 
-	log.Printf("Offer sent to signaling server")
-	return nil
+	log.Printf("Offer sent to signaling server (client: %s)", clientID)
 }
 
-// startAsClient 作为客户端启动
-func (p *P2PConnector) startAsClient() error {
-	log.Printf("Waiting for host offer...")
-	return nil
+// releaseSlot 释放connectPeer占用的名额（对端已登记或连接创建失败）
+func (p *P2PConnector) releaseSlot(clientID string) {
+	p.mu.Lock()
+	delete(p.joining, clientID)
+	p.mu.Unlock()
 }
 
-// handleSignalingMessage 处理信令消息
-func (p *P2PConnector) handleSignalingMessage(msgType string, data []byte) {
+// handleSignalingMessage 处理信令消息（from为服务器标记的发送者客户端ID）
+func (p *P2PConnector) handleSignalingMessage(msgType, from string, data []byte) {
 	// log.Printf("P2PConnector.handleSignalingMessage called! Type: %s, Data length: %d", msgType, len(data))
 
 	switch msgType {
 	case "offer":
 		// log.Printf("Processing offer message")
-		p.handleOffer(data)
+		p.handleOffer(from, data)
 	case "answer":
 		// log.Printf("Processing answer message")
-		p.handleAnswer(data)
+		p.handleAnswer(from, data)
 	case "ice_candidate":
 		// log.Printf("Processing ICE candidate message")
-		p.handleICECandidate(data)
+		p.handleICECandidate(from, data)
 	case "client_connected":
-		log.Printf("New client connected to room")
+		p.handleClientConnected(data)
 	case "host_disconnected":
 		log.Printf("Host disconnected from room")
 		p.handleDisconnection()
 	case "client_disconnected":
-		log.Printf("Client disconnected from room")
-		p.handleDisconnection()
+		p.handleClientDisconnected(data)
+	case "ice_restart":
+		p.handleICERestartRequest(from, data)
 	case "error":
 		var errorData struct {
 			Error string `json:"error"`
@@ -190,17 +446,113 @@ func (p *P2PConnector) handleSignalingMessage(msgType string, data []byte) {
 	}
 }
 
+// parseClientID 解析信令消息中的客户端ID
+func parseClientID(data []byte) string {
+	var clientData struct {
+		ClientID string `json:"client_id"`
+	}
+	json.Unmarshal(data, &clientData)
+	return clientData.ClientID
+}
+
+// handleClientConnected 处理新客户端加入
+func (p *P2PConnector) handleClientConnected(data []byte) {
+	if !p.isHost {
+		return
+	}
+
+	var clientData struct {
+		ClientID     string `json:"client_id"`
+		Resumed      bool   `json:"resumed"`
+		SessionAlive bool   `json:"session_alive"`
+	}
+	json.Unmarshal(data, &clientData)
+
+	clientID := clientData.ClientID
+	if clientID == "" {
+		log.Printf("client_connected without client ID, ignoring")
+		return
+	}
+
+	if clientData.Resumed && p.resumePeer(clientID, clientData.SessionAlive) {
+		return
+	}
+
+	log.Printf("New client connected to room: %s", clientID)
+
+	// CreateOffer会等待ICE收集完成，不阻塞信令消息处理
+	go p.connectPeer(clientID)
+}
+
+// resumePeer 客户端凭恢复令牌回到原位置，返回true表示沿用了现有对端
+//
+// 客户端的WebRTC会话仍在时保留连接和隧道，只在需要时重新进行ICE重启；
+// 客户端进程已重启时由connectPeer在原位置上重建连接（不占用新的名额）。
+func (p *P2PConnector) resumePeer(clientID string, sessionAlive bool) bool {
+	pr := p.getPeer(clientID)
+	if pr == nil {
+		log.Printf("Client %s resumed but its connection is gone, reconnecting", clientID)
+		return false
+	}
+
+	if !sessionAlive || pr.connection.IsClosed() {
+		log.Printf("Client %s resumed with a new session, renegotiating", clientID)
+		return false
+	}
+
+	log.Printf("Client %s resumed its session", clientID)
+
+	// 客户端离线期间发出的重启Offer无法送达，重新发起
+	if pr.connection.IsReconnecting() {
+		p.restartPeer(pr)
+	}
+	return true
+}
+
+// handleClientDisconnected 处理客户端离开
+func (p *P2PConnector) handleClientDisconnected(data []byte) {
+	clientID := parseClientID(data)
+	log.Printf("Client disconnected from room: %s", clientID)
+
+	if p.isHost && clientID != "" {
+		p.removePeer(clientID)
+	}
+	p.handleDisconnection()
+}
+
+// isForThisClient 客户端检查信令消息是否发给自己
+//
+// 旧版本信令服务器不下发客户端ID，此时接受所有消息。
+func (p *P2PConnector) isForThisClient(clientID string) bool {
+	own := p.signalingClient.ClientID()
+	return clientID == "" || own == "" || clientID == own
+}
+
+// isFromHost 客户端检查信令消息是否由主机发出（from由信令端标注，对端无法伪造）
+//
+// 旧版本信令服务器不下发主机ID，此时接受所有消息。
+func (p *P2PConnector) isFromHost(from string) bool {
+	host := p.signalingClient.HostID()
+	return host == "" || from == host
+}
+
 // handleOffer 处理收到的Offer
-func (p *P2PConnector) handleOffer(data []byte) {
+func (p *P2PConnector) handleOffer(from string, data []byte) {
 	if p.isHost {
 		log.Printf("Host received offer, ignoring")
 		return
 	}
 
+	if !p.isFromHost(from) {
+		log.Printf("Offer from %s is not from the host, ignoring", from)
+		return
+	}
+
 	log.Printf("Client received offer from host (data length: %d bytes)", len(data))
 
 	var offerData struct {
-		Offer string `json:"offer"`
+		Offer    string `json:"offer"`
+		ClientID string `json:"client_id"`
 	}
 	if err := json.Unmarshal(data, &offerData); err != nil {
 		log.Printf("Failed to parse offer: %v", err)
@@ -208,15 +560,26 @@ func (p *P2PConnector) handleOffer(data []byte) {
 		return
 	}
 
+	if !p.isForThisClient(offerData.ClientID) {
+		log.Printf("Offer is for client %s, ignoring", offerData.ClientID)
+		return
+	}
+
 	if offerData.Offer == "" {
 		log.Printf("Empty offer received")
 		return
 	}
 
+	pr := p.getPeer(hostPeerID)
+	if pr == nil {
+		log.Printf("No connection to host, ignoring offer")
+		return
+	}
+
 	log.Printf("Setting remote description (offer length: %d chars)", len(offerData.Offer))
 
 	// 设置远程描述
-	if err := p.connection.SetRemoteDescription(offerData.Offer); err != nil {
+	if err := pr.setRemoteDescription(offerData.Offer); err != nil {
 		log.Printf(" Failed to set remote description: %v", err)
 		return
 	}
@@ -224,7 +587,7 @@ func (p *P2PConnector) handleOffer(data []byte) {
 	log.Printf("Creating answer...")
 
 	// 创建answer
-	answer, err := p.connection.CreateAnswer()
+	answer, err := pr.connection.CreateAnswer()
 	if err != nil {
 		log.Printf(" Failed to create answer: %v", err)
 		return
@@ -234,7 +597,8 @@ func (p *P2PConnector) handleOffer(data []byte) {
 
 	// 发送answer到信令服务器
 	if err := p.signalingClient.SendMessage("answer", map[string]string{
-		"answer": answer,
+		"answer":    answer,
+		"client_id": p.signalingClient.ClientID(),
 	}); err != nil {
 		log.Printf(" Failed to send answer: %v", err)
 	} else {
@@ -249,71 +613,89 @@ func min(a, b int) int {
 	return b
 }
 
+// senderID 信令消息发送者的客户端ID：优先使用服务器标记的from，旧版本服务器回退到消息体中的client_id
+func senderID(from, clientID string) string {
+	if from != "" {
+		return from
+	}
+	return clientID
+}
+
+// peerForSignal 主机根据信令消息中的客户端ID找到对应的对端
+//
+// 旧版本客户端不带客户端ID，此时只有一个对端时才能确定目标。
+func (p *P2PConnector) peerForSignal(clientID string) *peer {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if clientID != "" {
+		return p.peers[clientID]
+	}
+
+	if len(p.peers) == 1 {
+		for _, pr := range p.peers {
+			return pr
+		}
+	}
+	return nil
+}
+
 // handleAnswer 处理收到的Answer
-func (p *P2PConnector) handleAnswer(data []byte) {
+func (p *P2PConnector) handleAnswer(from string, data []byte) {
 	if !p.isHost {
 		log.Printf("Client received answer, ignoring")
 		return
 	}
 
-	log.Printf("Host received answer from client")
-
 	var answerData struct {
-		Answer string `json:"answer"`
+		Answer   string `json:"answer"`
+		ClientID string `json:"client_id"`
 	}
 	if err := json.Unmarshal(data, &answerData); err != nil {
 		log.Printf("Failed to parse answer: %v", err)
 		return
 	}
 
-	// 设置远程描述
-	if err := p.connection.SetRemoteDescription(answerData.Answer); err != nil {
-		log.Printf("Failed to set remote description: %v", err)
+	clientID := senderID(from, answerData.ClientID)
+	log.Printf("Host received answer from client %s", clientID)
+
+	pr := p.peerForSignal(clientID)
+	if pr == nil {
+		log.Printf("No pending connection for client %s, ignoring answer", clientID)
 		return
 	}
 
-	log.Printf("Remote description set successfully")
-
-	// 处理缓存的ICE候选
-	p.pendingICEMu.Lock()
-	if len(p.pendingICECandidates) > 0 {
-		log.Printf("处理 %d 个缓存的ICE候选", len(p.pendingICECandidates))
-		for _, candidate := range p.pendingICECandidates {
-			// 将ICECandidateInit转换为JSON字符串
-			candidateJSON, err := json.Marshal(candidate)
-			if err != nil {
-				log.Printf("Failed to serialize ICE candidate: %v", err)
-				continue
-			}
-			if err := p.connection.AddICECandidate(string(candidateJSON)); err != nil {
-				log.Printf("Failed to add cached ICE candidate: %v", err)
-			}
-		}
-		// 清空缓存
-		p.pendingICECandidates = nil
+	// 设置远程描述（同时处理缓存的ICE候选）
+	if err := pr.setRemoteDescription(answerData.Answer); err != nil {
+		log.Printf("Failed to set remote description: %v", err)
+		return
 	}
-	p.pendingICEMu.Unlock()
 
-	// 连接建立
-	p.mu.Lock()
-	p.connected = true
-	p.mu.Unlock()
-	log.Printf("P2P connection established")
-	
-	// 启动简化心跳机制
-	p.startSimpleHeartbeat()
+	log.Printf("Remote description set successfully (client: %s)", pr.clientID)
 }
 
 // handleICECandidate 处理ICE候选
-func (p *P2PConnector) handleICECandidate(data []byte) {
+func (p *P2PConnector) handleICECandidate(from string, data []byte) {
 	var iceData struct {
 		Candidate string `json:"candidate"`
+		ClientID  string `json:"client_id"`
 	}
 	if err := json.Unmarshal(data, &iceData); err != nil {
 		log.Printf("Failed to parse ICE candidate: %v", err)
 		return
 	}
 
+	// 找到候选所属的对端
+	var pr *peer
+	if p.isHost {
+		pr = p.peerForSignal(senderID(from, iceData.ClientID))
+	} else if p.isFromHost(from) && p.isForThisClient(iceData.ClientID) {
+		pr = p.getPeer(hostPeerID)
+	}
+	if pr == nil {
+		return
+	}
+
 	// 解析ICE候选
 	var candidate webrtc.ICECandidateInit
 	if err := json.Unmarshal([]byte(iceData.Candidate), &candidate); err != nil {
@@ -321,22 +703,82 @@ func (p *P2PConnector) handleICECandidate(data []byte) {
 		return
 	}
 
-	// 尝试添加ICE候选
-	// 将ICECandidateInit转换为JSON字符串
-	candidateJSON, err := json.Marshal(candidate)
-	if err != nil {
-		log.Printf("Failed to serialize ICE candidate: %v", err)
+	if err := pr.queueOrAddICECandidate(candidate); err != nil {
+		log.Printf("Failed to add ICE candidate: %v", err)
+	} else {
+		log.Printf("ICE candidate added successfully (peer: %s)", pr.clientID)
+	}
+}
+
+// restartPeer 通过信令服务器对对端进行ICE重启
+//
+// 只有主机创建Offer：主机直接发送重启Offer，客户端请求主机发起重启。
+func (p *P2PConnector) restartPeer(pr *peer) {
+	if !p.isHost {
+		if err := p.signalingClient.SendMessage("ice_restart", map[string]string{
+			"client_id": p.signalingClient.ClientID(),
+		}); err != nil {
+			log.Printf("Failed to request ICE restart: %v", err)
+		}
 		return
 	}
 
-	if err := p.connection.AddICECandidate(string(candidateJSON)); err != nil {
-		// 如果失败（可能是远程描述未设置），缓存起来
-		log.Printf("ICE候选添加失败，缓存起来等待远程描述设置: %v", err)
-		p.pendingICEMu.Lock()
-		p.pendingICECandidates = append(p.pendingICECandidates, candidate)
-		p.pendingICEMu.Unlock()
-	} else {
-		log.Printf("ICE candidate added successfully")
+	if !pr.beginRestart() {
+		log.Printf("ICE restart already in progress (client: %s)", pr.clientID)
+		return
+	}
+
+	// 创建Offer会等待ICE收集完成，不阻塞调用方
+	go func() {
+		defer pr.endRestart()
+
+		offer, err := pr.connection.CreateRestartOffer()
+		if err != nil {
+			log.Printf("Failed to create ICE restart offer for client %s: %v", pr.clientID, err)
+			return
+		}
+
+		if err := p.signalingClient.SendMessageTo(pr.clientID, "offer", map[string]string{
+			"offer":     offer,
+			"client_id": pr.clientID,
+		}); err != nil {
+			log.Printf("Failed to send ICE restart offer to client %s: %v", pr.clientID, err)
+			return
+		}
+
+		log.Printf("ICE restart offer sent (client: %s)", pr.clientID)
+	}()
+}
+
+// handleICERestartRequest 主机处理客户端的ICE重启请求
+func (p *P2PConnector) handleICERestartRequest(from string, data []byte) {
+	if !p.isHost {
+		return
+	}
+
+	pr := p.peerForSignal(senderID(from, parseClientID(data)))
+	if pr == nil {
+		log.Printf("ICE restart requested by unknown client %s", from)
+		return
+	}
+
+	log.Printf("Client %s requested ICE restart", pr.clientID)
+	p.restartPeer(pr)
+}
+
+// handleReconnecting 处理对端开始重连
+func (p *P2PConnector) handleReconnecting(pr *peer, attempt int) {
+	log.Printf("Reconnecting to peer %s (attempt %d)", pr.clientID, attempt)
+	if p.onReconnecting != nil {
+		p.onReconnecting(pr.clientID, attempt)
+	}
+}
+
+// handleReconnected 处理对端重连成功
+func (p *P2PConnector) handleReconnected(pr *peer) {
+	log.Printf("Reconnected to peer %s", pr.clientID)
+	if p.onReconnected != nil {
+		p.onReconnected(pr.clientID)
 	}
 }
 
@@ -352,29 +794,107 @@ func (p *P2PConnector) handleSignalingError(err error) {
 }
 
 // handleDataChannelMessage 处理数据通道消息
-func (p *P2PConnector) handleDataChannelMessage(data []byte) {
-	msg, err := ParseMessage(data)
+func (p *P2PConnector) handleDataChannelMessage(pr *peer, data []byte) {
+	msg, err := parseMessage(data, pr.connection.MaxReassembledSize())
 	if err != nil {
 		log.Printf("Failed to parse message: %v", err)
 		return
 	}
 
+	// 任何消息都说明对端存活
+	if hb := pr.getHeartbeat(); hb != nil {
+		hb.touch()
+	}
+
+	switch msg.Type {
+	case MessageTypeHello:
+		pr.handshake.handleHello(msg.Payload)
+		return
+	case MessageTypeError:
+		p.handleError(pr, msg.Payload)
+		return
+	}
+
+	// 握手完成前只处理hello和error（数据通道有序，兼容的对端总是先发送hello）
+	if !pr.handshake.completed() {
+		log.Printf("Ignoring %s from peer %s before handshake", msg.Type, pr.clientID)
+		return
+	}
+
 	switch msg.Type {
 	case MessageTypeModsList:
-		p.handleModsList(msg.Payload)
+		p.handleModsList(pr, msg.Payload)
 	case MessageTypeModsComparison:
-		p.handleModsComparison(msg.Payload)
+		p.handleModsComparison(pr, msg.Payload)
 	case MessageTypePing:
-		p.handlePing()
+		p.handlePing(pr, msg.Payload)
+	case MessageTypePong:
+		p.handlePong(pr, msg.Payload)
 	case MessageTypeGameReady:
-		p.handleGameReady()
+		p.handleGameReady(pr)
+	case MessageTypeLANHostInfo:
+		p.handleLANHostInfo(pr, msg.Payload)
+	case MessageTypeRequest:
+		pr.connection.handleRequest(msg.Payload)
+	case MessageTypeResponse:
+		pr.connection.handleResponse(msg.Payload)
 	default:
-		log.Printf("Unknown message type: %s", msg.Type)
+		if !p.handlers.dispatch(pr.connection.ctx, pr.handle(), msg) {
+			log.Printf("Unknown message type: %s", msg.Type)
+		}
+	}
+}
+
+// handleBulkChannel 处理对端打开的大文件传输通道
+func (p *P2PConnector) handleBulkChannel(pr *peer, channel *BulkChannel) {
+	p.mu.RLock()
+	handler := p.onBulkChannel
+	p.mu.RUnlock()
+
+	if handler == nil {
+		log.Printf("No handler for bulk channel '%s' from peer %s, closing it", channel.Name(), pr.clientID)
+		channel.Close()
+		return
 	}
+	handler(pr.clientID, channel)
+}
+
+// handleLANHostInfo 处理主机的局域网发现信息
+func (p *P2PConnector) handleLANHostInfo(pr *peer, payload json.RawMessage) {
+	if p.isHost || pr.tunnel == nil {
+		return
+	}
+
+	info, err := ParseLANHostInfo(payload)
+	if err != nil {
+		log.Printf("Failed to parse LAN host info: %v", err)
+		return
+	}
+
+	pr.tunnel.SetLANHostInfo(info.Response)
+}
+
+// handleError 处理对端的错误消息
+func (p *P2PConnector) handleError(pr *peer, payload json.RawMessage) {
+	errMsg, err := ParseError(payload)
+	if err != nil {
+		log.Printf("Failed to parse error message: %v", err)
+		return
+	}
+
+	if pr.connection.handleRPCError(errMsg) || pr.handshake.handleError(errMsg) {
+		return
+	}
+	log.Printf("Error from peer %s: %s (%s)", pr.clientID, errMsg.Message, errMsg.Code)
 }
 
 // handleModsList 处理Mod列表
-func (p *P2PConnector) handleModsList(payload json.RawMessage) {
+func (p *P2PConnector) handleModsList(pr *peer, payload json.RawMessage) {
+	if !pr.supports(CapabilityModSync) {
+		log.Printf("Ignoring mods list from peer %s without mod-sync", pr.clientID)
+		return
+	}
+
 	modsMsg, err := ParseModsList(payload)
 	if err != nil {
 		log.Printf("Failed to parse mods list: %v", err)
@@ -403,7 +923,7 @@ func (p *P2PConnector) handleModsList(payload json.RawMessage) {
 	}
 
 	msgData, _ := json.Marshal(msg)
-	p.connection.SendMessage(msgData)
+	pr.connection.SendMessage(msgData)
 
 	// 调用回调
 	if p.onModsChecked != nil {
@@ -411,8 +931,25 @@ func (p *P2PConnector) handleModsList(payload json.RawMessage) {
 	}
 }
 
+// handleCompareMods 处理对端的mods.compare请求：与本地Mod对比并返回结果
+func (p *P2PConnector) handleCompareMods(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	modsMsg, err := ParseModsList(params)
+	if err != nil {
+		return nil, NewRPCError(ErrorCodeInvalidParams, "invalid mods list: %v", err)
+	}
+
+	localMods, err := ScanMods(p.modsPath)
+	if err != nil {
+		return nil, NewRPCError(ErrorCodeUnavailable, "failed to scan local mods: %v", err)
+	}
+
+	return ModsComparisonMessage{
+		Comparison: CompareMods(localMods, modsMsg.Mods),
+	}, nil
+}
+
 // handleModsComparison 处理Mod比较结果
-func (p *P2PConnector) handleModsComparison(payload json.RawMessage) {
+func (p *P2PConnector) handleModsComparison(pr *peer, payload json.RawMessage) {
 	var comparisonMsg ModsComparisonMessage
 	if err := json.Unmarshal(payload, &comparisonMsg); err != nil {
 		log.Printf("Failed to parse mods comparison: %v", err)
@@ -421,7 +958,7 @@ func (p *P2PConnector) handleModsComparison(payload json.RawMessage) {
 
 	comparison := comparisonMsg.Comparison
 
-	log.Printf("Mods comparison received:")
+	log.Printf("Mods comparison received (peer: %s):", pr.clientID)
 	log.Printf("  Only in local: %d", len(comparison.OnlyInLocal))
 	log.Printf("  Only in remote: %d", len(comparison.OnlyInRemote))
 	log.Printf("  Different: %d", len(comparison.Different))
@@ -433,44 +970,144 @@ func (p *P2PConnector) handleModsComparison(payload json.RawMessage) {
 	}
 }
 
-// handlePing 处理心跳
-func (p *P2PConnector) handlePing() {
-	// 发送pong响应
-	pongMsg := Message{
-		Type: MessageTypePong,
+// handlePing 处理心跳，原样带回序号和时间戳
+func (p *P2PConnector) handlePing(pr *peer, payload json.RawMessage) {
+	pongData, err := pongFor(payload)
+	if err != nil {
+		log.Printf("Failed to parse ping: %v", err)
+		return
+	}
+	pr.connection.SendMessage(pongData)
+}
+
+// handlePong 处理心跳响应
+func (p *P2PConnector) handlePong(pr *peer, payload json.RawMessage) {
+	pong, err := ParsePong(payload)
+	if err != nil {
+		log.Printf("Failed to parse pong: %v", err)
+		return
+	}
+
+	if hb := pr.getHeartbeat(); hb != nil {
+		hb.handlePong(pong)
 	}
-	pongData, _ := json.Marshal(pongMsg)
-	p.connection.SendMessage(pongData)
 }
 
 // handleGameReady 处理游戏就绪
-func (p *P2PConnector) handleGameReady() {
-	log.Printf("Remote peer is ready to play")
+func (p *P2PConnector) handleGameReady(pr *peer) {
+	log.Printf("Remote peer is ready to play (peer: %s)", pr.clientID)
+}
+
+// handleConnectionOpen 处理控制通道打开：先交换hello，握手完成后才算连接
+func (p *P2PConnector) handleConnectionOpen(pr *peer) {
+	log.Printf("Control channel open, sending hello (peer: %s)", pr.clientID)
+	pr.handshake.start()
+}
+
+// handleHandshakeComplete 握手完成，按协商的能力启动心跳和隧道
+func (p *P2PConnector) handleHandshakeComplete(pr *peer, info PeerInfo) {
+	if codec, ok := CodecByName(info.Codec); ok {
+		pr.connection.SetCodec(codec)
+	}
+	pr.connection.SetCompression(info.Has(CapabilityDeflate))
+	pr.setConnected(true)
+
+	p.mu.Lock()
+	p.connected = true
+	p.notifyStateChangedLocked()
+	p.mu.Unlock()
+
+	log.Printf("P2P connection established (peer: %s)", pr.clientID)
+
+	pr.startHeartbeat(p.heartbeatConfig)
+
+	if pr.tunnel != nil && !info.Has(CapabilityTunnel) {
+		log.Printf("Peer %s (stardewl %s) does not use the game tunnel, leaving it off", pr.clientID, info.AppVersion)
+	} else if pr.tunnel != nil {
+		if err := pr.tunnel.Start(); err != nil {
+			log.Printf("Failed to start game tunnel: %v", err)
+		}
+		if p.isHost {
+			p.startLANAnnounce()
+		}
+	}
+
+	if p.onConnected != nil {
+		p.onConnected()
+	}
+}
+
+// handleHandshakeFailed 对端不兼容或拒绝了本端，通知上层并关闭连接
+func (p *P2PConnector) handleHandshakeFailed(pr *peer, err *HandshakeError) {
+	log.Printf("Handshake with peer %s failed: %v", pr.clientID, err)
+
+	p.mu.RLock()
+	handler := p.onHandshakeFailed
+	p.mu.RUnlock()
+
+	if handler != nil {
+		handler(pr.clientID, err)
+	}
+
+	// 稍等再关闭，让error消息先送达对端
+	time.AfterFunc(handshakeCloseDelay, func() {
+		pr.connection.Close()
+	})
 }
 
 // handleConnectionClose 处理连接关闭
-func (p *P2PConnector) handleConnectionClose() {
-	log.Printf("WebRTC connection closed")
+func (p *P2PConnector) handleConnectionClose(pr *peer) {
+	log.Printf("WebRTC connection closed (peer: %s)", pr.clientID)
+	pr.setConnected(false)
+	pr.handshake.stop()
+	pr.stopHeartbeat()
+
+	// 主机端移除该客户端，释放名额
+	p.mu.Lock()
+	if p.isHost && p.peers[pr.clientID] == pr {
+		delete(p.peers, pr.clientID)
+	}
+	p.notifyStateChangedLocked()
+	p.mu.Unlock()
+
+	if pr.tunnel != nil {
+		pr.tunnel.Close()
+	}
+
 	p.handleDisconnection()
 }
 
 // handleDisconnection 处理断开连接
+//
+// 主机在最后一个客户端断开时才视为断开。
 func (p *P2PConnector) handleDisconnection() {
+	if p.isHost && len(p.connectedPeers()) > 0 {
+		return
+	}
+
+	// 在锁外调用回调，回调里可以再调用连接器的方法
 	p.mu.Lock()
+	var onDisconnected func()
 	if p.connected {
 		p.connected = false
-		if p.onDisconnected != nil {
-			p.onDisconnected()
-		}
+		onDisconnected = p.onDisconnected
 	}
 	p.mu.Unlock()
+
+	if onDisconnected != nil {
+		onDisconnected()
+	}
 }
 
-// SendModsList 发送Mod列表
+// SendModsList 发送Mod列表（主机发给所有支持mod-sync的已连接客户端）
 func (p *P2PConnector) SendModsList() error {
-	if !p.connection.IsConnected() {
+	if len(p.connectedPeers()) == 0 {
 		return fmt.Errorf("not connected")
 	}
+	peers := p.peersSupporting(CapabilityModSync)
+	if len(peers) == 0 {
+		return fmt.Errorf("no connected peer supports %s", CapabilityModSync)
+	}
 
 	mods, err := ScanMods(p.modsPath)
 	if err != nil {
@@ -488,7 +1125,59 @@ func (p *P2PConnector) SendModsList() error {
 	}
 
 	msgData, _ := json.Marshal(msg)
-	return p.connection.SendMessage(msgData)
+	return p.broadcast(peers, msgData)
+}
+
+// CompareModsWith 把本地Mod列表发给对端对比，返回对端的对比结果（以对端为本地）
+func (p *P2PConnector) CompareModsWith(ctx context.Context, clientID string) (ModComparison, error) {
+	pr := p.getPeer(clientID)
+	if pr == nil || !pr.isConnected() {
+		return ModComparison{}, fmt.Errorf("peer %s not connected", clientID)
+	}
+	if !pr.supports(CapabilityModSync) {
+		return ModComparison{}, fmt.Errorf("peer %s does not support %s", clientID, CapabilityModSync)
+	}
+
+	mods, err := ScanMods(p.modsPath)
+	if err != nil {
+		return ModComparison{}, fmt.Errorf("failed to scan mods: %w", err)
+	}
+
+	result, err := pr.connection.Call(ctx, MethodCompareMods, ModsListMessage{Mods: mods})
+	if err != nil {
+		return ModComparison{}, err
+	}
+	comparisonMsg, err := ParseModsComparison(result)
+	if err != nil {
+		return ModComparison{}, fmt.Errorf("invalid mods comparison from peer %s: %w", clientID, err)
+	}
+	return comparisonMsg.Comparison, nil
+}
+
+// peersSupporting 已连接且协商了该能力的对端
+func (p *P2PConnector) peersSupporting(capability string) []*peer {
+	peers := p.connectedPeers()
+	supported := peers[:0]
+	for _, pr := range peers {
+		if pr.supports(capability) {
+			supported = append(supported, pr)
+		}
+	}
+	return supported
+}
+
+// broadcast 发送消息到多个对端，返回第一个错误
+func (p *P2PConnector) broadcast(peers []*peer, data []byte) error {
+	var firstErr error
+	for _, pr := range peers {
+		if err := pr.connection.SendMessage(data); err != nil {
+			log.Printf("Failed to send to peer %s: %v", pr.clientID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
 }
 
 // SetCallbacks 设置回调函数
@@ -502,68 +1191,264 @@ func (p *P2PConnector) SetCallbacks(
 	p.onDisconnected = onDisconnected
 }
 
+// SetHandshakeFailedHandler 设置握手失败（对端版本不兼容或缺少必需能力）的回调
+func (p *P2PConnector) SetHandshakeFailedHandler(handler func(clientID string, err *HandshakeError)) {
+	p.mu.Lock()
+	p.onHandshakeFailed = handler
+	p.mu.Unlock()
+}
+
+// ResumeToken 获取恢复令牌（客户端凭此在断线后回到同一位置）
+func (p *P2PConnector) ResumeToken() string {
+	return p.signalingClient.ResumeToken()
+}
+
+// SetReconnectCallbacks 设置重连事件回调
+func (p *P2PConnector) SetReconnectCallbacks(
+	onReconnecting func(clientID string, attempt int),
+	onReconnected func(clientID string),
+) {
+	p.onReconnecting = onReconnecting
+	p.onReconnected = onReconnected
+}
+
 // Close 关闭P2P连接器
 func (p *P2PConnector) Close() {
 	p.mu.Lock()
-	defer p.mu.Unlock()
 
-	// 停止心跳
-	p.stopSimpleHeartbeat()
+	p.stopLANAnnounce()
+
+	peers := make([]*peer, 0, len(p.peers))
+	for _, pr := range p.peers {
+		peers = append(peers, pr)
+	}
+	p.peers = make(map[string]*peer)
+	p.connected = false
+	p.mu.Unlock()
 
 	if p.signalingClient != nil {
 		p.signalingClient.Close()
 	}
 
-	if p.connection != nil {
-		p.connection.Close()
+	// 在锁外关闭连接，关闭回调会再次进入连接器
+	for _, pr := range peers {
+		pr.close()
 	}
-
-	p.connected = false
 }
 
-// IsConnected 检查是否已连接
+// IsConnected 检查是否已连接（主机：至少一个客户端已连接）
 func (p *P2PConnector) IsConnected() bool {
 	p.mu.RLock()
-	defer p.mu.RUnlock()
-	return p.connected && p.connection.IsConnected()
+	connected := p.connected
+	p.mu.RUnlock()
+
+	return connected && len(p.connectedPeers()) > 0
+}
+
+// WaitForPeers 等待至少n个对端完成握手（客户端的对端只有主机），直到ctx结束
+func (p *P2PConnector) WaitForPeers(ctx context.Context, n int) error {
+	for {
+		p.mu.RLock()
+		changed := p.stateChanged
+		p.mu.RUnlock()
+
+		if len(p.connectedPeers()) >= n {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %d peer(s): %w", n, ctx.Err())
+		}
+	}
+}
+
+// notifyStateChangedLocked 唤醒WaitForPeers（调用方持有mu）
+func (p *P2PConnector) notifyStateChangedLocked() {
+	close(p.stateChanged)
+	p.stateChanged = make(chan struct{})
+}
+
+// PeerInfo 获取与某个对端协商的协议版本和能力（客户端的对端ID为"host"），握手完成前返回false
+func (p *P2PConnector) PeerInfo(clientID string) (PeerInfo, bool) {
+	pr := p.getPeer(clientID)
+	if pr == nil {
+		return PeerInfo{}, false
+	}
+	return pr.handshake.result()
+}
+
+// HeartbeatStats 获取与某个对端的心跳统计（客户端的对端ID为"host"）
+func (p *P2PConnector) HeartbeatStats(clientID string) (HeartbeatStats, bool) {
+	pr := p.getPeer(clientID)
+	if pr == nil {
+		return HeartbeatStats{}, false
+	}
+
+	hb := pr.getHeartbeat()
+	if hb == nil {
+		return HeartbeatStats{}, false
+	}
+	return hb.snapshot(), true
+}
+
+// OpenBulkChannel 向对端打开一条大文件传输通道（客户端的对端ID为"host"）
+func (p *P2PConnector) OpenBulkChannel(clientID, name string) (*BulkChannel, error) {
+	pr := p.getPeer(clientID)
+	if pr == nil || !pr.isConnected() {
+		return nil, fmt.Errorf("peer %s not connected", clientID)
+	}
+	return pr.connection.OpenBulkChannel(name)
+}
+
+// HandleMethod 注册RPC方法，对现有和之后加入的对端都生效；handler为nil时取消注册
+func (p *P2PConnector) HandleMethod(method string, handler RPCHandler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if handler == nil {
+		delete(p.methods, method)
+	} else {
+		p.methods[method] = handler
+	}
+	for _, pr := range p.peers {
+		pr.connection.HandleMethod(method, handler)
+	}
+}
+
+// Call 调用对端的RPC方法（客户端的对端ID为"host"），对端返回错误时返回*RPCError
+func (p *P2PConnector) Call(ctx context.Context, clientID, method string, req interface{}) (json.RawMessage, error) {
+	pr := p.getPeer(clientID)
+	if pr == nil || !pr.isConnected() {
+		return nil, fmt.Errorf("peer %s not connected", clientID)
+	}
+	return pr.connection.Call(ctx, method, req)
+}
+
+// RegisterHandler 注册自定义消息类型的处理函数，handler为nil时取消注册
+//
+// 消息类型必须是"<命名空间>.<名称>"的形式（见handlers.go），否则返回ErrInvalidMessageType。
+func (p *P2PConnector) RegisterHandler(msgType MessageType, handler HandlerFunc) error {
+	return p.handlers.register(msgType, handler)
+}
+
+// SendTo 向对端发送自定义消息（客户端的对端ID为"host"）
+func (p *P2PConnector) SendTo(clientID string, msgType MessageType, payload interface{}) error {
+	pr := p.getPeer(clientID)
+	if pr == nil || !pr.isConnected() {
+		return fmt.Errorf("peer %s not connected", clientID)
+	}
+	return pr.handle().Send(msgType, payload)
+}
+
+// Broadcast 向所有已连接的对端发送自定义消息
+func (p *P2PConnector) Broadcast(msgType MessageType, payload interface{}) error {
+	peers := p.connectedPeers()
+	if len(peers) == 0 {
+		return fmt.Errorf("not connected")
+	}
+	data, err := newCustomMessage(msgType, payload)
+	if err != nil {
+		return err
+	}
+	return p.broadcast(peers, data)
+}
+
+// SetBulkChannelHandler 设置对端打开的大文件传输通道的处理回调
+func (p *P2PConnector) SetBulkChannelHandler(handler func(clientID string, channel *BulkChannel)) {
+	p.mu.Lock()
+	p.onBulkChannel = handler
+	p.mu.Unlock()
+}
+
+// Stats 获取与每个对端的连接统计（按客户端ID排序，包括尚在建立中的连接）
+func (p *P2PConnector) Stats() []PeerStats {
+	p.mu.RLock()
+	peers := make([]*peer, 0, len(p.peers))
+	for _, pr := range p.peers {
+		peers = append(peers, pr)
+	}
+	p.mu.RUnlock()
+
+	stats := make([]PeerStats, 0, len(peers))
+	for _, pr := range peers {
+		peerStats := PeerStats{
+			ClientID:   pr.clientID,
+			Connection: pr.connection.Stats(),
+		}
+		if hb := pr.getHeartbeat(); hb != nil {
+			peerStats.Heartbeat = hb.snapshot()
+		}
+		stats = append(stats, peerStats)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].ClientID < stats[j].ClientID
+	})
+	return stats
 }
 
-// startSimpleHeartbeat 启动简化心跳机制
-func (p *P2PConnector) startSimpleHeartbeat() {
-	// 如果已经有心跳在运行，先停止
-	if p.heartbeatDone != nil {
-		close(p.heartbeatDone)
+// Peers 获取已连接对端的客户端ID
+func (p *P2PConnector) Peers() []string {
+	peers := p.connectedPeers()
+	ids := make([]string, 0, len(peers))
+	for _, pr := range peers {
+		ids = append(ids, pr.clientID)
 	}
-	
-	p.heartbeatDone = make(chan bool)
-	
+	sort.Strings(ids)
+	return ids
+}
+
+// startLANAnnounce 定期探测本地游戏服务器，把局域网发现响应同步给所有客户端
+func (p *P2PConnector) startLANAnnounce() {
+	p.mu.Lock()
+	if p.lanAnnounceDone != nil {
+		p.mu.Unlock()
+		return
+	}
+	done := make(chan struct{})
+	p.lanAnnounceDone = done
+	p.mu.Unlock()
+
+	gameAddr := p.tunnelConfig.withDefaults().GameAddr
+
 	go func() {
-		ticker := time.NewTicker(30 * time.Second)
+		ticker := time.NewTicker(lanAnnounceInterval)
 		defer ticker.Stop()
-		
+
+		var lastErr string
 		for {
+			response, err := ProbeLANHost(gameAddr, lanProbeTimeout)
+			if err != nil {
+				// 游戏可能尚未开放联机，只在错误变化时记录
+				if err.Error() != lastErr {
+					log.Printf("LAN discovery: game server not answering yet: %v", err)
+					lastErr = err.Error()
+				}
+			} else {
+				lastErr = ""
+				msg, err := NewMessage(MessageTypeLANHostInfo, LANHostInfoMessage{Response: response})
+				if err != nil {
+					log.Printf("Failed to create LAN host info message: %v", err)
+				} else {
+					p.broadcast(p.peersSupporting(CapabilityTunnel), msg)
+				}
+			}
+
 			select {
 			case <-ticker.C:
-				if p.IsConnected() {
-					// 简单记录心跳活动
-					log.Printf("Heartbeat active (room: %s)", p.roomID)
-					// 注意：这里不实际发送ping，只是记录活动
-					// 完整实现应该发送ping消息
-				}
-			case <-p.heartbeatDone:
-				log.Printf("Heartbeat stopped (room: %s)", p.roomID)
+			case <-done:
 				return
 			}
 		}
 	}()
-	
-	log.Printf("Simple heartbeat started (room: %s)", p.roomID)
 }
 
-// stopSimpleHeartbeat 停止简化心跳机制
-func (p *P2PConnector) stopSimpleHeartbeat() {
-	if p.heartbeatDone != nil {
-		close(p.heartbeatDone)
-		p.heartbeatDone = nil
+// stopLANAnnounce 停止局域网主机信息同步
+func (p *P2PConnector) stopLANAnnounce() {
+	if p.lanAnnounceDone != nil {
+		close(p.lanAnnounceDone)
+		p.lanAnnounceDone = nil
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,199 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// MaxFarmhands 星露谷物语最多支持8名玩家，即主机加7名农场帮手
+	MaxFarmhands = 7
+
+	// hostPeerID 客户端一侧对主机的对端标识
+	hostPeerID = "host"
+)
+
+// peer 一个对端：主机为每个加入的客户端维护一个，客户端只有主机这一个
+type peer struct {
+	clientID   string
+	connection *Connection
+	tunnel     *Tunnel
+	connected  bool
+	// ICE候选队列：当远程描述未设置时缓存ICE候选
+	pendingICECandidates []webrtc.ICECandidateInit
+	hasRemoteDescription bool
+	// restarting 正在创建ICE重启Offer（主机）
+	restarting bool
+	// heartbeat 握手完成后的心跳
+	heartbeat *heartbeat
+	// handshake 控制通道上的hello交换（由连接器在登记对端时创建）
+	handshake *handshake
+	mu         sync.Mutex
+}
+
+// newPeer 创建对端及其WebRTC连接
+func newPeer(clientID, roomID string, isHost bool, connConfig ConnectionConfig, tunnelConfig TunnelConfig) (*peer, error) {
+	connection, err := NewConnection(roomID, isHost, connConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create WebRTC connection: %w", err)
+	}
+
+	pr := &peer{
+		clientID:   clientID,
+		connection: connection,
+	}
+
+	// 创建游戏端口隧道
+	if tunnelConfig.Enabled {
+		pr.tunnel = NewTunnel(connection, isHost, tunnelConfig)
+	}
+
+	return pr, nil
+}
+
+// setRemoteDescription 设置远程描述并处理缓存的ICE候选
+func (pr *peer) setRemoteDescription(sdp string) error {
+	if err := pr.connection.SetRemoteDescription(sdp); err != nil {
+		return err
+	}
+
+	pr.mu.Lock()
+	pr.hasRemoteDescription = true
+	pending := pr.pendingICECandidates
+	pr.pendingICECandidates = nil
+	pr.mu.Unlock()
+
+	if len(pending) > 0 {
+		log.Printf("处理 %d 个缓存的ICE候选 (peer: %s)", len(pending), pr.clientID)
+	}
+	for _, candidate := range pending {
+		if err := pr.addICECandidate(candidate); err != nil {
+			log.Printf("Failed to add cached ICE candidate: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// queueOrAddICECandidate 添加ICE候选，远程描述未设置时先缓存
+func (pr *peer) queueOrAddICECandidate(candidate webrtc.ICECandidateInit) error {
+	pr.mu.Lock()
+	if !pr.hasRemoteDescription {
+		pr.pendingICECandidates = append(pr.pendingICECandidates, candidate)
+		pr.mu.Unlock()
+		log.Printf("ICE候选已缓存，等待远程描述设置 (peer: %s)", pr.clientID)
+		return nil
+	}
+	pr.mu.Unlock()
+
+	return pr.addICECandidate(candidate)
+}
+
+// addICECandidate 添加ICE候选
+func (pr *peer) addICECandidate(candidate webrtc.ICECandidateInit) error {
+	// 将ICECandidateInit转换为JSON字符串
+	candidateJSON, err := json.Marshal(candidate)
+	if err != nil {
+		return fmt.Errorf("failed to serialize ICE candidate: %w", err)
+	}
+
+	return pr.connection.AddICECandidate(string(candidateJSON))
+}
+
+// setConnected 更新连接状态
+func (pr *peer) setConnected(connected bool) {
+	pr.mu.Lock()
+	pr.connected = connected
+	pr.mu.Unlock()
+}
+
+// isConnected 检查对端是否已连接
+func (pr *peer) isConnected() bool {
+	pr.mu.Lock()
+	connected := pr.connected
+	pr.mu.Unlock()
+
+	return connected && pr.connection.IsConnected()
+}
+
+// beginRestart 标记开始ICE重启，已有重启进行中时返回false
+func (pr *peer) beginRestart() bool {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	if pr.restarting {
+		return false
+	}
+	pr.restarting = true
+	return true
+}
+
+// endRestart 标记ICE重启Offer已发送（或失败）
+func (pr *peer) endRestart() {
+	pr.mu.Lock()
+	pr.restarting = false
+	pr.mu.Unlock()
+}
+
+// supports 握手是否已完成且双方都支持该能力
+func (pr *peer) supports(capability string) bool {
+	info, ok := pr.handshake.result()
+	return ok && info.Has(capability)
+}
+
+// startHeartbeat 启动心跳，对端超时后关闭连接（触发关闭回调）
+func (pr *peer) startHeartbeat(config HeartbeatConfig) {
+	hb := newHeartbeat(config, pr.connection.SendMessage, pr.connection.IsReconnecting, func() {
+		log.Printf("Peer %s timed out", pr.clientID)
+		pr.connection.Close()
+	})
+
+	pr.mu.Lock()
+	previous := pr.heartbeat
+	pr.heartbeat = hb
+	pr.mu.Unlock()
+
+	if previous != nil {
+		previous.stop()
+	}
+	hb.start()
+}
+
+// stopHeartbeat 停止心跳
+func (pr *peer) stopHeartbeat() {
+	pr.mu.Lock()
+	hb := pr.heartbeat
+	pr.mu.Unlock()
+
+	if hb != nil {
+		hb.stop()
+	}
+}
+
+// handle 供自定义消息处理函数使用的对端
+func (pr *peer) handle() Peer {
+	return peerHandle{id: pr.clientID, connection: pr.connection, handshake: pr.handshake}
+}
+
+// getHeartbeat 获取心跳（握手完成前为nil）
+func (pr *peer) getHeartbeat() *heartbeat {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	return pr.heartbeat
+}
+
+// close 关闭对端的隧道与连接
+func (pr *peer) close() {
+	if pr.handshake != nil {
+		pr.handshake.stop()
+	}
+	pr.stopHeartbeat()
+	if pr.tunnel != nil {
+		pr.tunnel.Close()
+	}
+	pr.connection.Close()
+}
@@ -0,0 +1,182 @@
+package core
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// ReconnectPolicy 断线重连策略
+//
+// ICE进入Disconnected后先等待宽限期（Wi-Fi抖动通常会自行恢复），
+// 仍未恢复或进入Failed时通过信令服务器进行ICE重启，超过次数后才关闭连接。
+type ReconnectPolicy struct {
+	// GracePeriod Disconnected持续多久后开始ICE重启
+	GracePeriod time.Duration
+	// MaxAttempts 最多尝试ICE重启的次数，0表示不重连
+	MaxAttempts int
+	// AttemptTimeout 每次ICE重启等待恢复的时间
+	AttemptTimeout time.Duration
+}
+
+// DefaultReconnectPolicy 默认重连策略
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		GracePeriod:    5 * time.Second,
+		MaxAttempts:    3,
+		AttemptTimeout: 15 * time.Second,
+	}
+}
+
+// enabled 是否启用重连
+func (p ReconnectPolicy) enabled() bool {
+	return p.MaxAttempts > 0
+}
+
+// reconnectState 连接的重连状态
+type reconnectState struct {
+	policy       ReconnectPolicy
+	timer        *time.Timer
+	attempt      int
+	reconnecting bool
+	stopped      bool
+	// onRestart 请求ICE重启（由P2PConnector通过信令完成重新协商）
+	onRestart func(attempt int)
+	// onReconnecting/onReconnected 重连事件
+	onReconnecting func(attempt int)
+	onReconnected  func()
+	mu             sync.Mutex
+}
+
+// SetReconnectHandlers 设置重连回调
+//
+// onRestart在需要ICE重启时调用，负责创建重启Offer（主机）或请求主机重启（客户端）；
+// onReconnecting在每次尝试时调用，onReconnected在连接恢复时调用。
+func (c *Connection) SetReconnectHandlers(onRestart func(attempt int), onReconnecting func(attempt int), onReconnected func()) {
+	r := &c.reconnect
+	r.mu.Lock()
+	r.onRestart = onRestart
+	r.onReconnecting = onReconnecting
+	r.onReconnected = onReconnected
+	r.mu.Unlock()
+}
+
+// IsReconnecting 检查是否正在重连
+func (c *Connection) IsReconnecting() bool {
+	r := &c.reconnect
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reconnecting
+}
+
+// handleICEState 根据ICE状态驱动重连
+func (c *Connection) handleICEState(state webrtc.ICEConnectionState) {
+	r := &c.reconnect
+
+	switch state {
+	case webrtc.ICEConnectionStateConnected, webrtc.ICEConnectionStateCompleted:
+		r.mu.Lock()
+		if r.timer != nil {
+			r.timer.Stop()
+			r.timer = nil
+		}
+		wasReconnecting := r.reconnecting
+		r.reconnecting = false
+		r.attempt = 0
+		onReconnected := r.onReconnected
+		r.mu.Unlock()
+
+		if wasReconnecting {
+			log.Printf("ICE connection recovered (room: %s)", c.connectionID)
+			if onReconnected != nil {
+				onReconnected()
+			}
+		}
+
+	case webrtc.ICEConnectionStateDisconnected:
+		if !r.policy.enabled() {
+			c.close()
+			return
+		}
+
+		// 等待宽限期，短暂断开通常会自行恢复
+		r.mu.Lock()
+		if r.timer == nil && !r.reconnecting && !r.stopped {
+			log.Printf("ICE disconnected, waiting %s before restarting (room: %s)", r.policy.GracePeriod, c.connectionID)
+			r.timer = time.AfterFunc(r.policy.GracePeriod, c.attemptReconnect)
+		}
+		r.mu.Unlock()
+
+	case webrtc.ICEConnectionStateFailed:
+		if !r.policy.enabled() {
+			c.close()
+			return
+		}
+
+		// 失败不会自行恢复，立即重启（正在等待的尝试除外）
+		r.mu.Lock()
+		waiting := r.reconnecting && r.timer != nil
+		if !waiting && r.timer != nil {
+			r.timer.Stop()
+			r.timer = nil
+		}
+		r.mu.Unlock()
+
+		if !waiting {
+			c.attemptReconnect()
+		}
+
+	case webrtc.ICEConnectionStateClosed:
+		c.close()
+	}
+}
+
+// attemptReconnect 发起一次ICE重启，超过次数则关闭连接
+func (c *Connection) attemptReconnect() {
+	r := &c.reconnect
+
+	r.mu.Lock()
+	if r.stopped {
+		r.mu.Unlock()
+		return
+	}
+	r.attempt++
+	attempt := r.attempt
+	if attempt > r.policy.MaxAttempts {
+		r.timer = nil
+		r.mu.Unlock()
+		log.Printf("ICE restart gave up after %d attempts (room: %s)", r.policy.MaxAttempts, c.connectionID)
+		c.close()
+		return
+	}
+	r.reconnecting = true
+	onReconnecting := r.onReconnecting
+	onRestart := r.onRestart
+
+	// 本次尝试超时后进行下一次
+	r.timer = time.AfterFunc(r.policy.AttemptTimeout, c.attemptReconnect)
+	r.mu.Unlock()
+
+	log.Printf("ICE restart attempt %d/%d (room: %s)", attempt, r.policy.MaxAttempts, c.connectionID)
+
+	if onReconnecting != nil {
+		onReconnecting(attempt)
+	}
+	if onRestart != nil {
+		onRestart(attempt)
+	}
+}
+
+// stopReconnect 停止重连（连接关闭时调用）
+func (c *Connection) stopReconnect() {
+	r := &c.reconnect
+	r.mu.Lock()
+	r.stopped = true
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	r.mu.Unlock()
+}
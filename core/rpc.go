@@ -0,0 +1,273 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// RPC错误代码（error消息的code，带request_id）
+const (
+	// ErrorCodeMethodNotFound 对端没有注册该方法
+	ErrorCodeMethodNotFound = "method_not_found"
+	// ErrorCodeInvalidParams 请求参数无法解析
+	ErrorCodeInvalidParams = "invalid_params"
+	// ErrorCodeUnavailable 方法暂时不可用（如对端不支持所需的能力）
+	ErrorCodeUnavailable = "unavailable"
+	// ErrorCodeInternal 处理请求时出错
+	ErrorCodeInternal = "internal_error"
+)
+
+// MethodCompareMods 内置方法：参数为ModsListMessage，返回ModsComparisonMessage
+const MethodCompareMods = "mods.compare"
+
+const (
+	// defaultCallTimeout ctx没有截止时间时请求的超时
+	defaultCallTimeout = 30 * time.Second
+	// rpcHandlerTimeout 处理对端请求的时间上限
+	rpcHandlerTimeout = 60 * time.Second
+	// maxInFlightRequests 每个连接同时处理的对端请求上限，超过时回复unavailable
+	maxInFlightRequests = 16
+)
+
+// RPCHandler 处理对端请求，返回值编码为JSON作为响应；
+// 返回*RPCError时原样传给调用方，其他错误作为internal_error
+type RPCHandler func(ctx context.Context, params json.RawMessage) (interface{}, error)
+
+// RPCError 对端返回的错误（或由处理函数返回给对端的错误）
+type RPCError struct {
+	Code    string
+	Message string
+	// Details 与错误代码相关的结构化信息
+	Details json.RawMessage
+}
+
+// Error 实现error接口
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// NewRPCError 创建RPC错误
+func NewRPCError(code, format string, args ...interface{}) *RPCError {
+	return &RPCError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// rpcResult 请求的结果
+type rpcResult struct {
+	result json.RawMessage
+	err    error
+}
+
+// rpcState 连接上的请求/响应状态
+type rpcState struct {
+	nextID  uint64
+	pending map[uint64]chan rpcResult
+	methods map[string]RPCHandler
+	// inFlight 正在处理的对端请求数
+	inFlight int
+	closed   bool
+	mu       sync.Mutex
+}
+
+// HandleMethod 注册方法的处理函数，handler为nil时取消注册
+func (c *Connection) HandleMethod(method string, handler RPCHandler) {
+	r := &c.rpc
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if handler == nil {
+		delete(r.methods, method)
+		return
+	}
+	if r.methods == nil {
+		r.methods = make(map[string]RPCHandler)
+	}
+	r.methods[method] = handler
+}
+
+// Call 调用对端的方法，等待响应直到ctx结束（ctx没有截止时间时最多等待30秒）
+//
+// 对端返回错误时返回*RPCError。
+func (c *Connection) Call(ctx context.Context, method string, req interface{}) (json.RawMessage, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultCallTimeout)
+		defer cancel()
+	}
+
+	params, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s request: %w", method, err)
+	}
+
+	r := &c.rpc
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil, ErrChannelClosed
+	}
+	r.nextID++
+	id := r.nextID
+	done := make(chan rpcResult, 1)
+	if r.pending == nil {
+		r.pending = make(map[uint64]chan rpcResult)
+	}
+	r.pending[id] = done
+	r.mu.Unlock()
+
+	defer func() {
+		r.mu.Lock()
+		delete(r.pending, id)
+		r.mu.Unlock()
+	}()
+
+	msg, err := NewMessage(MessageTypeRequest, RequestMessage{ID: id, Method: method, Params: params})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s request: %w", method, err)
+	}
+	if err := c.Send(ctx, msg); err != nil {
+		return nil, fmt.Errorf("failed to send %s request: %w", method, err)
+	}
+
+	select {
+	case result := <-done:
+		return result.result, result.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%s request: %w", method, ctx.Err())
+	}
+}
+
+// handleRequest 处理对端的请求（由消息分发调用），在单独的协程中执行处理函数并回复
+func (c *Connection) handleRequest(payload json.RawMessage) {
+	req, err := ParseRequest(payload)
+	if err != nil {
+		log.Printf("Failed to parse request: %v", err)
+		return
+	}
+
+	// 每个请求占用一个协程直到处理完成，限制数量使对端无法靠大量请求耗尽内存
+	c.rpc.mu.Lock()
+	handler := c.rpc.methods[req.Method]
+	busy := handler != nil && c.rpc.inFlight >= maxInFlightRequests
+	if handler != nil && !busy {
+		c.rpc.inFlight++
+	}
+	c.rpc.mu.Unlock()
+
+	if handler == nil {
+		c.replyError(req.ID, NewRPCError(ErrorCodeMethodNotFound, "method %q not found", req.Method))
+		return
+	}
+	if busy {
+		c.replyError(req.ID, NewRPCError(ErrorCodeUnavailable, "too many requests in flight (max %d)", maxInFlightRequests))
+		return
+	}
+
+	go func() {
+		defer func() {
+			c.rpc.mu.Lock()
+			c.rpc.inFlight--
+			c.rpc.mu.Unlock()
+		}()
+
+		ctx, cancel := context.WithTimeout(c.ctx, rpcHandlerTimeout)
+		defer cancel()
+
+		result, err := handler(ctx, req.Params)
+		if err != nil {
+			var rpcErr *RPCError
+			if !errors.As(err, &rpcErr) {
+				rpcErr = NewRPCError(ErrorCodeInternal, "%v", err)
+			}
+			c.replyError(req.ID, rpcErr)
+			return
+		}
+
+		data, err := json.Marshal(result)
+		if err != nil {
+			c.replyError(req.ID, NewRPCError(ErrorCodeInternal, "failed to marshal result: %v", err))
+			return
+		}
+		msg, err := NewMessage(MessageTypeResponse, ResponseMessage{ID: req.ID, Result: data})
+		if err != nil {
+			log.Printf("Failed to create response to %s: %v", req.Method, err)
+			return
+		}
+		if err := c.SendMessage(msg); err != nil {
+			log.Printf("Failed to send response to %s: %v", req.Method, err)
+		}
+	}()
+}
+
+// handleResponse 处理对端的响应（由消息分发调用）
+func (c *Connection) handleResponse(payload json.RawMessage) {
+	resp, err := ParseResponse(payload)
+	if err != nil {
+		log.Printf("Failed to parse response: %v", err)
+		return
+	}
+	c.resolveCall(resp.ID, rpcResult{result: resp.Result})
+}
+
+// handleRPCError 处理带request_id的错误消息，返回false表示不是请求的错误
+func (c *Connection) handleRPCError(msg ErrorMessage) bool {
+	if msg.RequestID == 0 {
+		return false
+	}
+	c.resolveCall(msg.RequestID, rpcResult{err: &RPCError{Code: msg.Code, Message: msg.Message, Details: msg.Details}})
+	return true
+}
+
+// resolveCall 把结果交给等待中的Call，调用方已超时时丢弃
+func (c *Connection) resolveCall(id uint64, result rpcResult) {
+	c.rpc.mu.Lock()
+	done := c.rpc.pending[id]
+	c.rpc.mu.Unlock()
+
+	if done == nil {
+		log.Printf("Dropping response to unknown or expired request %d", id)
+		return
+	}
+	select {
+	case done <- result:
+	default:
+		log.Printf("Dropping duplicate response to request %d", id)
+	}
+}
+
+// replyError 回复请求失败
+func (c *Connection) replyError(id uint64, rpcErr *RPCError) {
+	msg, err := NewMessage(MessageTypeError, ErrorMessage{
+		Code:      rpcErr.Code,
+		Message:   rpcErr.Message,
+		Details:   rpcErr.Details,
+		RequestID: id,
+	})
+	if err != nil {
+		log.Printf("Failed to create error response: %v", err)
+		return
+	}
+	if err := c.SendMessage(msg); err != nil {
+		log.Printf("Failed to send error response: %v", err)
+	}
+}
+
+// closeRPC 连接关闭，等待中的请求立即失败
+func (c *Connection) closeRPC() {
+	r := &c.rpc
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	for id, done := range r.pending {
+		select {
+		case done <- rpcResult{err: ErrChannelClosed}:
+		default:
+		}
+		delete(r.pending, id)
+	}
+}
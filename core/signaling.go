@@ -8,18 +8,58 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v3"
 )
 
+const (
+	// signalingResumeAttempts 信令断线后尝试恢复会话的轮数（每轮内部重试3次）
+	signalingResumeAttempts = 5
+	// signalingResumeDelay 两轮恢复之间的等待时间
+	signalingResumeDelay = 2 * time.Second
+	// signalingJoinTimeout 等待服务器回复加入消息的超时
+	signalingJoinTimeout = 10 * time.Second
+)
+
+// SignalingTransport 信令传输：P2PConnector通过它交换SDP和ICE候选
+//
+// SignalingClient经WebSocket信令服务器转发；局域网模式下由LANHost/LANClient直接通过TCP交换。
+type SignalingTransport interface {
+	// SendMessage 发送消息（主机发给所有客户端，客户端发给主机）
+	SendMessage(msgType string, data interface{}) error
+	// SendMessageTo 发送消息给指定客户端ID的对端
+	SendMessageTo(to, msgType string, data interface{}) error
+	// ClientID 本端的客户端ID
+	ClientID() string
+	// HostID 主机的客户端ID，客户端只接受它发来的Offer和ICE候选（未知时为空）
+	HostID() string
+	// ResumeToken 会话恢复令牌（不支持时为空）
+	ResumeToken() string
+	// ICEServers 信令端下发的ICE服务器
+	ICEServers() []webrtc.ICEServer
+	// SetCallbacks 设置回调，之前收到的消息会被缓存并在此时处理
+	SetCallbacks(onMessage func(msgType, from string, data []byte), onConnected func(), onError func(err error))
+	Close() error
+}
+
 // SignalingClient 信令客户端
 type SignalingClient struct {
 	conn          *websocket.Conn
 	url           string
 	roomID        string
 	isHost        bool
-	onMessage     func(msgType string, data []byte)
+	clientID      string
+	// hostID 服务器下发的主机客户端ID
+	hostID        string
+	// 恢复令牌：断线后凭此回到原来的客户端位置
+	resumeToken   string
+	resumed       bool
+	// 服务器下发的ICE服务器（例如内置TURN）
+	iceServers    []webrtc.ICEServer
+	onMessage     func(msgType, from string, data []byte)
 	onConnected   func()
 	onError       func(err error)
 	mu            sync.RWMutex
+	writeMu       sync.Mutex
 	closed        bool
 	// 消息队列：在回调设置前缓存消息
 	messageQueue  []queuedMessage
@@ -29,19 +69,57 @@ type SignalingClient struct {
 // queuedMessage 队列中的消息
 type queuedMessage struct {
 	msgType string
+	from    string
 	data    []byte
 }
 
 // NewSignalingClient 创建新的信令客户端
 func NewSignalingClient(url, roomID string, isHost bool) (*SignalingClient, error) {
+	return newSignalingClient(url, roomID, isHost, "")
+}
+
+// ResumeSignalingClient 使用恢复令牌重新加入房间，回到之前的客户端位置
+//
+// 令牌过期或无效时服务器按新客户端处理。
+func ResumeSignalingClient(url, roomID, resumeToken string) (*SignalingClient, error) {
+	return newSignalingClient(url, roomID, false, resumeToken)
+}
+
+// newSignalingClient 连接信令服务器并加入房间
+func newSignalingClient(url, roomID string, isHost bool, resumeToken string) (*SignalingClient, error) {
 	log.Printf("🔗 Connecting to signaling server: %s (room: %s, host: %v)", url, roomID, isHost)
 	
-	// 建立WebSocket连接（带重试）
+	client := &SignalingClient{
+		url:          url,
+		roomID:       roomID,
+		isHost:       isHost,
+		resumeToken:  resumeToken,
+		closed:       false,
+		messageQueue: make([]queuedMessage, 0),
+	}
+
+	// 新进程持有的令牌对应的WebRTC会话已不存在
+	conn, err := client.dial(false)
+	if err != nil {
+		return nil, err
+	}
+	client.conn = conn
+	
+	// 启动消息处理协程
+	go client.handleMessages()
+
+	return client, nil
+}
+
+// dial 建立WebSocket连接（带重试）并发送加入消息
+//
+// sessionAlive 表示本进程的WebRTC会话仍然存在，主机据此决定保留还是重建连接。
+func (c *SignalingClient) dial(sessionAlive bool) (*websocket.Conn, error) {
 	var conn *websocket.Conn
 	var err error
 	
 	for i := 0; i < 3; i++ {
-		conn, _, err = websocket.DefaultDialer.Dial(url, nil)
+		conn, _, err = websocket.DefaultDialer.Dial(c.url, nil)
 		if err == nil {
 			break
 		}
@@ -57,50 +135,157 @@ func NewSignalingClient(url, roomID string, isHost bool) (*SignalingClient, erro
 	}
 
 	log.Printf("✅ WebSocket connection established to signaling server")
-	
-	client := &SignalingClient{
-		conn:         conn,
-		url:          url,
-		roomID:       roomID,
-		isHost:       isHost,
-		closed:       false,
-		messageQueue: make([]queuedMessage, 0),
-	}
 
 	// 发送加入消息
 	joinMsg := map[string]interface{}{
-		"connection_id": roomID,
-		"is_host":       isHost,
+		"connection_id": c.roomID,
+		"is_host":       c.isHost,
+	}
+	if token := c.ResumeToken(); token != "" {
+		joinMsg["resume_token"] = token
+		joinMsg["session_alive"] = sessionAlive
 	}
 	
-	log.Printf("📤 Sending join message for room: %s", roomID)
+	log.Printf("📤 Sending join message for room: %s", c.roomID)
 	if err := conn.WriteJSON(joinMsg); err != nil {
 		conn.Close()
 		return nil, fmt.Errorf("failed to send join message: %w", err)
 	}
 
 	log.Printf("✅ Join message sent successfully")
-	
-	// 启动消息处理协程
-	go client.handleMessages()
 
-	return client, nil
+	// 服务器首先回复connected（或error），其中携带客户端ID、恢复令牌和ICE服务器，
+	// 在返回前处理，以便调用方用下发的ICE服务器创建连接
+	conn.SetReadDeadline(time.Now().Add(signalingJoinTimeout))
+	var msg struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+	err = conn.ReadJSON(&msg)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("no response to join message: %w", err)
+	}
+
+	switch msg.Type {
+	case "connected":
+		c.applyConnected(msg.Data)
+	case "error":
+		conn.Close()
+		var errorData struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(msg.Data, &errorData)
+		return nil, fmt.Errorf("signaling server rejected join: %s", errorData.Error)
+	default:
+		conn.Close()
+		return nil, fmt.Errorf("unexpected response to join message: %s", msg.Type)
+	}
+
+	return conn, nil
+}
+
+// applyConnected 处理connected消息中的客户端ID、恢复令牌和ICE服务器
+func (c *SignalingClient) applyConnected(data []byte) {
+	var connectedData struct {
+		ClientID    string             `json:"client_id"`
+		HostID      string             `json:"host_id"`
+		ResumeToken string             `json:"resume_token"`
+		Resumed     bool               `json:"resumed"`
+		ICEServers  []webrtc.ICEServer `json:"ice_servers"`
+	}
+	if err := json.Unmarshal(data, &connectedData); err != nil {
+		log.Printf("Failed to parse connected message: %v", err)
+	}
+
+	c.mu.Lock()
+	c.clientID = connectedData.ClientID
+	c.hostID = connectedData.HostID
+	c.resumeToken = connectedData.ResumeToken
+	c.resumed = connectedData.Resumed
+	if len(connectedData.ICEServers) > 0 {
+		c.iceServers = connectedData.ICEServers
+	}
+	c.mu.Unlock()
+
+	if connectedData.Resumed {
+		log.Printf("Resumed session in room: %s (client: %s)", c.roomID, connectedData.ClientID)
+	} else {
+		log.Printf("Connected to signaling server for room: %s (client: %s)", c.roomID, connectedData.ClientID)
+	}
+	if len(connectedData.ICEServers) > 0 {
+		log.Printf("Signaling server provided %d ICE server(s)", len(connectedData.ICEServers))
+	}
+}
+
+// resume 信令连接意外断开后使用恢复令牌重新加入
+//
+// 只有客户端可以恢复；WebRTC连接不依赖信令，恢复期间游戏流量不受影响。
+func (c *SignalingClient) resume() (*websocket.Conn, bool) {
+	if c.isHost || c.ResumeToken() == "" {
+		return nil, false
+	}
+
+	log.Printf("Signaling connection lost, resuming session in room %s", c.roomID)
+
+	var conn *websocket.Conn
+	var err error
+	for i := 0; i < signalingResumeAttempts; i++ {
+		if c.isClosed() {
+			return nil, false
+		}
+		conn, err = c.dial(true)
+		if err == nil {
+			break
+		}
+		log.Printf("⚠️  Resume attempt %d/%d failed: %v", i+1, signalingResumeAttempts, err)
+		time.Sleep(signalingResumeDelay)
+	}
+	if err != nil {
+		log.Printf("Failed to resume signaling session: %v", err)
+		return nil, false
+	}
+
+	c.writeMu.Lock()
+	c.mu.Lock()
+	closed := c.closed
+	if !closed {
+		c.conn = conn
+	}
+	c.mu.Unlock()
+	c.writeMu.Unlock()
+
+	if closed {
+		conn.Close()
+		return nil, false
+	}
+	return conn, true
 }
 
 // handleMessages 处理来自信令服务器的消息
 func (c *SignalingClient) handleMessages() {
+	conn := c.conn
 	defer func() {
 		c.mu.Lock()
 		c.closed = true
 		c.mu.Unlock()
-		c.conn.Close()
+		conn.Close()
 	}()
 
 	for {
-		_, message, err := c.conn.ReadMessage()
+		_, message, err := conn.ReadMessage()
 		if err != nil {
 			if !c.isClosed() {
 				log.Printf("Signaling connection closed: %v", err)
+				if resumed, ok := c.resume(); ok {
+					conn.Close()
+					conn = resumed
+					if c.onConnected != nil {
+						c.onConnected()
+					}
+					continue
+				}
 				if c.onError != nil {
 					c.onError(err)
 				}
@@ -120,6 +305,7 @@ func (c *SignalingClient) handleMessages() {
 			var msg struct {
 				Type string          `json:"type"`
 				Data json.RawMessage `json:"data"`
+				From string          `json:"from"`
 			}
 			
 			if err := json.Unmarshal(message, &msg); err != nil {
@@ -132,7 +318,7 @@ func (c *SignalingClient) handleMessages() {
 
 			// 处理连接成功消息
 			if msg.Type == "connected" {
-				log.Printf("Connected to signaling server for room: %s", c.roomID)
+				c.applyConnected(msg.Data)
 				if c.onConnected != nil {
 					c.onConnected()
 				}
@@ -143,14 +329,14 @@ func (c *SignalingClient) handleMessages() {
 			c.queueMu.Lock()
 			if c.onMessage != nil {
 				log.Printf("Calling onMessage callback for type: %s", msg.Type)
-				c.onMessage(msg.Type, msg.Data)
+				c.onMessage(msg.Type, msg.From, msg.Data)
 				
 				// 如果有队列中的消息，也处理它们
 				if len(c.messageQueue) > 0 {
 					log.Printf("Processing %d queued messages", len(c.messageQueue))
 					for _, qm := range c.messageQueue {
 						log.Printf("  -> Processing queued message: %s", qm.msgType)
-						c.onMessage(qm.msgType, qm.data)
+						c.onMessage(qm.msgType, qm.from, qm.data)
 					}
 					// 清空队列
 					c.messageQueue = make([]queuedMessage, 0)
@@ -160,6 +346,7 @@ func (c *SignalingClient) handleMessages() {
 				log.Printf("📦 Queueing message (callback not set yet): %s", msg.Type)
 				c.messageQueue = append(c.messageQueue, queuedMessage{
 					msgType: msg.Type,
+					from:    msg.From,
 					data:    msg.Data,
 				})
 			}
@@ -168,8 +355,13 @@ func (c *SignalingClient) handleMessages() {
 	}
 }
 
-// SendMessage 发送消息到信令服务器
+// SendMessage 发送消息到信令服务器（不指定接收者）
 func (c *SignalingClient) SendMessage(msgType string, data interface{}) error {
+	return c.SendMessageTo("", msgType, data)
+}
+
+// SendMessageTo 发送消息给房间内指定客户端ID的对端，to为空时由服务器按旧规则转发
+func (c *SignalingClient) SendMessageTo(to, msgType string, data interface{}) error {
 	if c.isClosed() {
 		return fmt.Errorf("signaling client is closed")
 	}
@@ -178,7 +370,12 @@ func (c *SignalingClient) SendMessage(msgType string, data interface{}) error {
 		"type": msgType,
 		"data": data,
 	}
+	if to != "" {
+		msg["to"] = to
+	}
 
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
 	return c.conn.WriteJSON(msg)
 }
 
@@ -186,9 +383,45 @@ func (c *SignalingClient) SendMessage(msgType string, data interface{}) error {
 func (c *SignalingClient) Close() error {
 	c.mu.Lock()
 	c.closed = true
+	conn := c.conn
 	c.mu.Unlock()
 	
-	return c.conn.Close()
+	return conn.Close()
+}
+
+// ClientID 获取信令服务器分配的客户端ID（收到connected消息前为空）
+func (c *SignalingClient) ClientID() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.clientID
+}
+
+// HostID 获取服务器下发的主机客户端ID（旧版本服务器不下发，此时为空）
+func (c *SignalingClient) HostID() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.hostID
+}
+
+// ResumeToken 获取服务器下发的恢复令牌（旧版本服务器不下发，为空）
+func (c *SignalingClient) ResumeToken() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.resumeToken
+}
+
+// ICEServers 获取信令服务器下发的ICE服务器（未下发时为空）
+func (c *SignalingClient) ICEServers() []webrtc.ICEServer {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.iceServers
+}
+
+// Resumed 检查本次加入是否恢复了之前的客户端位置
+func (c *SignalingClient) Resumed() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.resumed
 }
 
 // isClosed 检查客户端是否已关闭
@@ -200,7 +433,7 @@ func (c *SignalingClient) isClosed() bool {
 
 // SetCallbacks 设置回调函数
 func (c *SignalingClient) SetCallbacks(
-	onMessage func(msgType string, data []byte),
+	onMessage func(msgType, from string, data []byte),
 	onConnected func(),
 	onError func(err error),
 ) {
@@ -216,7 +449,7 @@ func (c *SignalingClient) SetCallbacks(
 		log.Printf("🔄 Processing %d queued messages after setting callbacks", len(c.messageQueue))
 		for _, qm := range c.messageQueue {
 			log.Printf("  -> Processing queued: %s", qm.msgType)
-			onMessage(qm.msgType, qm.data)
+			onMessage(qm.msgType, qm.from, qm.data)
 		}
 		// 清空队列
 		c.messageQueue = make([]queuedMessage, 0)
@@ -227,6 +460,11 @@ func (c *SignalingClient) SetCallbacks(
 
 // WaitForConnection 等待连接建立
 func (c *SignalingClient) WaitForConnection(timeout time.Duration) bool {
+	// 加入时已同步收到connected消息
+	if c.ClientID() != "" {
+		return true
+	}
+
 	connected := make(chan bool, 1)
 	
 	originalOnConnected := c.onConnected
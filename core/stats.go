@@ -0,0 +1,268 @@
+package core
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// 候选对路径（用于判断是否直连）
+const (
+	// PathHost 双方都使用本机地址（同一局域网）
+	PathHost = "host"
+	// PathSTUN 经NAT打洞直连（srflx/prflx）
+	PathSTUN = "srflx"
+	// PathRelay 经TURN中继
+	PathRelay = "relay"
+)
+
+// ConnectionStats 一条WebRTC连接的统计（pion GetStats加上stardewl自己的计数）
+type ConnectionStats struct {
+	ConnectionID string `json:"connection_id"`
+	// ICEState ICE连接状态
+	ICEState string `json:"ice_state"`
+	// CandidatePair 选中的候选对，尚未连通时为nil
+	CandidatePair *CandidatePairStats `json:"candidate_pair,omitempty"`
+	// RTT SCTP平滑往返时间
+	RTT time.Duration `json:"rtt"`
+	// BytesSent/BytesReceived ICE传输层总字节数（含所有数据通道和协议开销）
+	BytesSent     uint64 `json:"bytes_sent"`
+	BytesReceived uint64 `json:"bytes_received"`
+	// MessagesSent/MessagesReceived 控制通道上的stardewl消息数（发送按分片计）
+	MessagesSent     uint64 `json:"messages_sent"`
+	MessagesReceived uint64 `json:"messages_received"`
+	// SendErrors 控制通道发送失败次数
+	SendErrors uint64 `json:"send_errors"`
+	// DroppedMessages 分片没有收齐或超过大小上限而丢弃的消息数
+	DroppedMessages uint64 `json:"dropped_messages"`
+	// MaxMessageSize 控制通道单条SCTP消息的上限，更大的消息会分片发送
+	MaxMessageSize int `json:"max_message_size"`
+	// Channels 每个数据通道的统计（控制通道和游戏隧道）
+	Channels []ChannelStats `json:"channels"`
+}
+
+// CandidatePairStats 选中的ICE候选对
+type CandidatePairStats struct {
+	// LocalType/RemoteType 候选类型：host/srflx/prflx/relay
+	LocalType     string `json:"local_type"`
+	RemoteType    string `json:"remote_type"`
+	LocalAddress  string `json:"local_address"`
+	RemoteAddress string `json:"remote_address"`
+	Protocol      string `json:"protocol"`
+}
+
+// ChannelStats 一个数据通道的统计
+type ChannelStats struct {
+	Label            string `json:"label"`
+	State            string `json:"state"`
+	BytesSent        uint64 `json:"bytes_sent"`
+	BytesReceived    uint64 `json:"bytes_received"`
+	MessagesSent     uint32 `json:"messages_sent"`
+	MessagesReceived uint32 `json:"messages_received"`
+	// BufferedAmount 已排队尚未发出的字节数
+	BufferedAmount uint64 `json:"buffered_amount"`
+}
+
+// PeerStats 与一个对端的连接统计
+type PeerStats struct {
+	ClientID   string          `json:"client_id"`
+	Connection ConnectionStats `json:"connection"`
+	// Heartbeat 心跳往返时间，控制通道未打开时为零值
+	Heartbeat HeartbeatStats `json:"heartbeat"`
+}
+
+// Path 连接路径：任一端为中继即为relay，任一端经NAT映射即为srflx，否则为host
+func (p CandidatePairStats) Path() string {
+	switch {
+	case p.LocalType == "relay" || p.RemoteType == "relay":
+		return PathRelay
+	case p.LocalType == "host" && p.RemoteType == "host":
+		return PathHost
+	default:
+		return PathSTUN
+	}
+}
+
+// String 格式化候选对
+func (p CandidatePairStats) String() string {
+	return fmt.Sprintf("%s %s (%s) <-> %s (%s)", p.Protocol, p.LocalAddress, p.LocalType, p.RemoteAddress, p.RemoteType)
+}
+
+// BufferedAmount 所有数据通道排队的字节数
+func (s ConnectionStats) BufferedAmount() uint64 {
+	var total uint64
+	for _, channel := range s.Channels {
+		total += channel.BufferedAmount
+	}
+	return total
+}
+
+// String 格式化为一行摘要
+func (s PeerStats) String() string {
+	path := "not connected"
+	if s.Connection.CandidatePair != nil {
+		path = s.Connection.CandidatePair.Path()
+	}
+
+	rtt := s.Connection.RTT
+	if s.Heartbeat.SmoothedRTT > 0 {
+		rtt = s.Heartbeat.SmoothedRTT
+	}
+
+	return fmt.Sprintf("%s: %s, rtt %s, jitter %s, sent %s, received %s, buffered %s, channels %d",
+		s.ClientID, path, rtt.Round(time.Millisecond), s.Heartbeat.Jitter.Round(time.Millisecond),
+		formatBytes(s.Connection.BytesSent), formatBytes(s.Connection.BytesReceived),
+		formatBytes(s.Connection.BufferedAmount()), len(s.Connection.Channels))
+}
+
+// String 格式化为一行摘要
+func (c ChannelStats) String() string {
+	return fmt.Sprintf("%s (%s): sent %s, received %s, buffered %s",
+		c.Label, c.State, formatBytes(c.BytesSent), formatBytes(c.BytesReceived), formatBytes(c.BufferedAmount))
+}
+
+// formatBytes 格式化字节数
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// messageCounters 控制通道消息计数
+type messageCounters struct {
+	sent       atomic.Uint64
+	received   atomic.Uint64
+	sendErrors atomic.Uint64
+	dropped    atomic.Uint64
+}
+
+// channelSet 连接上打开的数据通道（统计排队字节数用，关闭的通道在统计时移除）
+type channelSet struct {
+	channels []*webrtc.DataChannel
+	mu       sync.Mutex
+}
+
+// add 记录数据通道
+func (s *channelSet) add(dc *webrtc.DataChannel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.channels {
+		if existing == dc {
+			return
+		}
+	}
+	s.channels = append(s.channels, dc)
+}
+
+// list 获取仍未关闭的数据通道
+func (s *channelSet) list() []*webrtc.DataChannel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	open := s.channels[:0]
+	for _, dc := range s.channels {
+		if dc.ReadyState() != webrtc.DataChannelStateClosed {
+			open = append(open, dc)
+		}
+	}
+	s.channels = open
+	return append([]*webrtc.DataChannel(nil), open...)
+}
+
+// Stats 获取连接统计
+func (c *Connection) Stats() ConnectionStats {
+	c.mu.RLock()
+	pc := c.peerConnection
+	c.mu.RUnlock()
+
+	stats := ConnectionStats{
+		ConnectionID:     c.connectionID,
+		ICEState:         webrtc.ICEConnectionStateClosed.String(),
+		MessagesSent:     c.counters.sent.Load(),
+		MessagesReceived: c.counters.received.Load(),
+		SendErrors:       c.counters.sendErrors.Load(),
+		DroppedMessages:  c.counters.dropped.Load(),
+		MaxMessageSize:   c.MaxMessageSize(),
+	}
+	if pc == nil {
+		return stats
+	}
+
+	stats.ICEState = pc.ICEConnectionState().String()
+	stats.CandidatePair = selectedCandidatePair(pc)
+
+	// 按数据通道ID索引pion的统计
+	channelReports := make(map[int32]webrtc.DataChannelStats)
+	for _, report := range pc.GetStats() {
+		switch s := report.(type) {
+		case webrtc.DataChannelStats:
+			channelReports[s.DataChannelIdentifier] = s
+		case webrtc.TransportStats:
+			stats.BytesSent = s.BytesSent
+			stats.BytesReceived = s.BytesReceived
+		case webrtc.SCTPTransportStats:
+			stats.RTT = time.Duration(s.SmoothedRoundTripTime * float64(time.Second))
+		}
+	}
+
+	for _, dc := range c.channels.list() {
+		channel := ChannelStats{
+			Label:          dc.Label(),
+			State:          dc.ReadyState().String(),
+			BufferedAmount: dc.BufferedAmount(),
+		}
+		if id := dc.ID(); id != nil {
+			if report, ok := channelReports[int32(*id)]; ok {
+				channel.BytesSent = report.BytesSent
+				channel.BytesReceived = report.BytesReceived
+				channel.MessagesSent = report.MessagesSent
+				channel.MessagesReceived = report.MessagesReceived
+			}
+		}
+		stats.Channels = append(stats.Channels, channel)
+	}
+
+	// 控制通道排在最前，其余按标签排序
+	sort.SliceStable(stats.Channels, func(i, j int) bool {
+		a, b := stats.Channels[i].Label, stats.Channels[j].Label
+		if (a == controlChannelLabel) != (b == controlChannelLabel) {
+			return a == controlChannelLabel
+		}
+		return a < b
+	})
+
+	return stats
+}
+
+// selectedCandidatePair 获取选中的候选对
+func selectedCandidatePair(pc *webrtc.PeerConnection) *CandidatePairStats {
+	sctp := pc.SCTP()
+	if sctp == nil || sctp.Transport() == nil {
+		return nil
+	}
+
+	pair, err := sctp.Transport().ICETransport().GetSelectedCandidatePair()
+	if err != nil || pair == nil || pair.Local == nil || pair.Remote == nil {
+		return nil
+	}
+
+	return &CandidatePairStats{
+		LocalType:     pair.Local.Typ.String(),
+		RemoteType:    pair.Remote.Typ.String(),
+		LocalAddress:  fmt.Sprintf("%s:%d", pair.Local.Address, pair.Local.Port),
+		RemoteAddress: fmt.Sprintf("%s:%d", pair.Remote.Address, pair.Remote.Port),
+		Protocol:      pair.Local.Protocol.String(),
+	}
+}
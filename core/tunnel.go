@@ -0,0 +1,600 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// DefaultGamePort 星露谷物语局域网联机默认端口（TCP/UDP）
+	DefaultGamePort = 24642
+
+	// 隧道数据通道标签前缀，后缀为流编号
+	tunnelTCPLabelPrefix = "tunnel-tcp:"
+	tunnelUDPLabelPrefix = "tunnel-udp:"
+
+	// tunnelReadBufferSize TCP转发时单条数据通道消息的最大负载
+	tunnelReadBufferSize = 16 * 1024
+	// udpReadBufferSize UDP数据报缓冲区，足够容纳任意数据报，保证一个数据报对应一条SCTP消息
+	udpReadBufferSize = 64 * 1024
+	// tunnelWriteQueueSize 等待写入本地套接字的消息数量上限
+	tunnelWriteQueueSize = 256
+	// udpSessionIdleTimeout UDP会话空闲超时
+	udpSessionIdleTimeout = 2 * time.Minute
+	// tunnelDialTimeout 主机端连接本地游戏服务器的超时
+	tunnelDialTimeout = 5 * time.Second
+	// tunnelDropLogInterval 丢弃数据报的汇总日志最短间隔
+	tunnelDropLogInterval = 10 * time.Second
+)
+
+// UDPRelayMode UDP转发所用数据通道的可靠性模式
+type UDPRelayMode string
+
+const (
+	// UDPRelayUnreliable 无序、不重传（默认）。Lidgren自己负责可靠性，避免队头阻塞
+	UDPRelayUnreliable UDPRelayMode = "unreliable"
+	// UDPRelayReliable 有序、可靠，适合丢包严重但对延迟不敏感的网络
+	UDPRelayReliable UDPRelayMode = "reliable"
+)
+
+// TunnelConfig 游戏端口隧道配置
+type TunnelConfig struct {
+	// Enabled 是否启用隧道
+	Enabled bool
+	// GameAddr 主机端本地游戏服务器地址（默认 127.0.0.1:24642）
+	GameAddr string
+	// ListenAddr 客户端本地监听地址，游戏通过"加入局域网游戏"连接到这里（默认 127.0.0.1:24642）
+	ListenAddr string
+	// UDPMode UDP转发模式（由打开通道的客户端决定，默认 unreliable）
+	UDPMode UDPRelayMode
+	// LANDiscovery 客户端应答局域网发现广播，使远程农场出现在"加入局域网游戏"列表中
+	// （UDP端口会监听所有网卡以接收广播）
+	LANDiscovery bool
+}
+
+// withDefaults 填充默认值
+func (c TunnelConfig) withDefaults() TunnelConfig {
+	defaultAddr := fmt.Sprintf("127.0.0.1:%d", DefaultGamePort)
+	if c.GameAddr == "" {
+		c.GameAddr = defaultAddr
+	}
+	if c.ListenAddr == "" {
+		c.ListenAddr = defaultAddr
+	}
+	if c.UDPMode == "" {
+		c.UDPMode = UDPRelayUnreliable
+	}
+	return c
+}
+
+// ParseUDPRelayMode 解析UDP转发模式
+func ParseUDPRelayMode(mode string) (UDPRelayMode, error) {
+	switch UDPRelayMode(strings.ToLower(mode)) {
+	case "", UDPRelayUnreliable:
+		return UDPRelayUnreliable, nil
+	case UDPRelayReliable:
+		return UDPRelayReliable, nil
+	default:
+		return "", fmt.Errorf("unknown UDP relay mode %q (expected %q or %q)", mode, UDPRelayUnreliable, UDPRelayReliable)
+	}
+}
+
+// udpChannelInit UDP转发数据通道参数
+func (c TunnelConfig) udpChannelInit() *webrtc.DataChannelInit {
+	if c.UDPMode == UDPRelayReliable {
+		return nil
+	}
+
+	ordered := false
+	maxRetransmits := uint16(0)
+	return &webrtc.DataChannelInit{
+		Ordered:        &ordered,
+		MaxRetransmits: &maxRetransmits,
+	}
+}
+
+// Tunnel 把星露谷的游戏端口通过WebRTC数据通道转发到对端
+//
+// 主机端：对端每打开一个隧道数据通道，就连接一次本地游戏服务器并双向转发。
+// 客户端：在本地监听游戏端口，每个TCP连接或UDP来源地址对应一个新的数据通道。
+type Tunnel struct {
+	connection  *Connection
+	isHost      bool
+	config      TunnelConfig
+	tcpListener net.Listener
+	udpConn     *net.UDPConn
+	discovery   *DiscoveryResponder
+	udpSessions map[string]*udpSession
+	streams     map[*tunnelStream]struct{}
+	nextID      atomic.Uint32
+	mu          sync.Mutex
+	closed      bool
+}
+
+// tunnelStream 一条数据通道与一个本地套接字之间的转发
+type tunnelStream struct {
+	dc     *webrtc.DataChannel
+	sender *flowControl
+	writes chan []byte
+	// dropped UDP转发写入队列已满时丢弃的数据报数
+	dropped atomic.Uint64
+	// sendDropped UDP转发发送队列已满时丢弃的数据报数
+	sendDropped atomic.Uint64
+	// sendDropsLogged 已写入汇总日志的发送丢弃数
+	sendDropsLogged atomic.Uint64
+	// lastDropLog 上次汇总日志的时间（UnixNano）
+	lastDropLog atomic.Int64
+	once        sync.Once
+	done        chan struct{}
+}
+
+// udpSession 客户端一个UDP来源地址对应的会话
+type udpSession struct {
+	stream   *tunnelStream
+	addr     *net.UDPAddr
+	lastSeen atomic.Int64
+}
+
+// NewTunnel 为连接创建游戏端口隧道
+func NewTunnel(connection *Connection, isHost bool, config TunnelConfig) *Tunnel {
+	t := &Tunnel{
+		connection:  connection,
+		isHost:      isHost,
+		config:      config.withDefaults(),
+		udpSessions: make(map[string]*udpSession),
+		streams:     make(map[*tunnelStream]struct{}),
+	}
+
+	if isHost {
+		connection.SetDataChannelHandler(t.handleDataChannel)
+	}
+
+	return t
+}
+
+// Start 启动隧道（客户端开始监听本地游戏端口）
+func (t *Tunnel) Start() error {
+	if t.isHost {
+		log.Printf("Tunnel ready, forwarding to local game server %s", t.config.GameAddr)
+		return nil
+	}
+
+	tcpListener, err := net.Listen("tcp", t.config.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s/tcp: %w", t.config.ListenAddr, err)
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", t.config.ListenAddr)
+	if err != nil {
+		tcpListener.Close()
+		return fmt.Errorf("failed to resolve %s: %w", t.config.ListenAddr, err)
+	}
+	if t.config.LANDiscovery {
+		// 局域网发现请求是广播，只有监听所有地址的套接字才能收到
+		udpAddr = &net.UDPAddr{Port: udpAddr.Port}
+		log.Printf("LAN discovery enabled: UDP tunnel listening on %s instead of %s, reachable from the local network", udpAddr, t.config.ListenAddr)
+	}
+
+	udpConn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		tcpListener.Close()
+		return fmt.Errorf("failed to listen on %s/udp: %w", t.config.ListenAddr, err)
+	}
+
+	t.mu.Lock()
+	t.tcpListener = tcpListener
+	t.udpConn = udpConn
+	if t.config.LANDiscovery {
+		t.discovery = NewDiscoveryResponder(udpConn)
+	}
+	t.mu.Unlock()
+
+	go t.acceptTCP()
+	go t.readUDP()
+	go t.expireUDPSessions()
+
+	log.Printf("Tunnel listening on %s (tcp/udp), use \"Join LAN game\" to connect", t.config.ListenAddr)
+	return nil
+}
+
+// SetLANHostInfo 设置远程主机的局域网发现响应（客户端）
+func (t *Tunnel) SetLANHostInfo(response []byte) {
+	t.mu.Lock()
+	discovery := t.discovery
+	t.mu.Unlock()
+
+	if discovery != nil {
+		discovery.SetHostInfo(response)
+	}
+}
+
+// ListenAddr 客户端实际监听的地址
+func (t *Tunnel) ListenAddr() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.tcpListener != nil {
+		return t.tcpListener.Addr().String()
+	}
+	return t.config.ListenAddr
+}
+
+// Close 关闭隧道及所有转发
+func (t *Tunnel) Close() {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return
+	}
+	t.closed = true
+
+	if t.tcpListener != nil {
+		t.tcpListener.Close()
+	}
+	if t.udpConn != nil {
+		t.udpConn.Close()
+	}
+
+	streams := make([]*tunnelStream, 0, len(t.streams))
+	for s := range t.streams {
+		streams = append(streams, s)
+	}
+	t.mu.Unlock()
+
+	for _, s := range streams {
+		s.close()
+	}
+}
+
+// isClosed 检查隧道是否已关闭
+func (t *Tunnel) isClosed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
+}
+
+// newLabel 生成隧道数据通道标签
+func (t *Tunnel) newLabel(prefix string) string {
+	return fmt.Sprintf("%s%d", prefix, t.nextID.Add(1))
+}
+
+// newStream 创建一条转发并登记
+func (t *Tunnel) newStream(dc *webrtc.DataChannel) *tunnelStream {
+	s := &tunnelStream{
+		dc:     dc,
+		sender: newFlowControl(dc, t.connection.flowControlConfig, nil),
+		writes: make(chan []byte, tunnelWriteQueueSize),
+		done:   make(chan struct{}),
+	}
+
+	t.mu.Lock()
+	t.streams[s] = struct{}{}
+	t.mu.Unlock()
+
+	// 数据通道收到的数据排队写入本地套接字；回调运行在整个SCTP关联的读循环里，
+	// 不能在这里等待，否则一个慢套接字会卡住同一连接上的所有数据通道。
+	// UDP转发在队列满时丢弃数据报（游戏会处理丢包），TCP转发无法丢弃数据，只能关闭这条转发
+	datagram := strings.HasPrefix(dc.Label(), tunnelUDPLabelPrefix)
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		select {
+		case s.writes <- msg.Data:
+			return
+		case <-s.done:
+			return
+		default:
+		}
+
+		if datagram {
+			s.dropped.Add(1)
+			return
+		}
+		s.once.Do(func() {
+			log.Printf("Tunnel '%s' closed: the game connection could not keep up", dc.Label())
+			go s.shutdown()
+		})
+	})
+	dc.OnClose(func() {
+		s.close()
+		t.mu.Lock()
+		delete(t.streams, s)
+		t.mu.Unlock()
+	})
+
+	return s
+}
+
+// close 关闭转发（可重复调用）
+func (s *tunnelStream) close() {
+	s.once.Do(s.shutdown)
+}
+
+// shutdown 释放转发的资源，只能执行一次
+func (s *tunnelStream) shutdown() {
+	if dropped := s.dropped.Load(); dropped > 0 {
+		log.Printf("Tunnel '%s' dropped %d datagrams, the game could not keep up", s.dc.Label(), dropped)
+	}
+	if dropped := s.sendDropped.Load() - s.sendDropsLogged.Load(); dropped > 0 {
+		log.Printf("Tunnel '%s' dropped %d outgoing datagrams, the peer could not keep up", s.dc.Label(), dropped)
+	}
+	close(s.done)
+	s.sender.close()
+	s.dc.Close()
+}
+
+// trySendDatagram 非阻塞发送一个数据报，丢弃时计数并定期输出汇总日志
+func (s *tunnelStream) trySendDatagram(data []byte) {
+	err := s.sender.trySend(data)
+	if err == nil {
+		return
+	}
+
+	total := s.sendDropped.Add(1)
+	now := time.Now().UnixNano()
+	last := s.lastDropLog.Load()
+	if time.Duration(now-last) < tunnelDropLogInterval || !s.lastDropLog.CompareAndSwap(last, now) {
+		return
+	}
+
+	dropped := total - s.sendDropsLogged.Swap(total)
+	log.Printf("Tunnel '%s' dropped %d outgoing datagrams (last error: %v)", s.dc.Label(), dropped, err)
+}
+
+// pumpToChannel 从本地连接读取数据并发送到数据通道
+func (s *tunnelStream) pumpToChannel(conn net.Conn) {
+	buf := make([]byte, tunnelReadBufferSize)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			// 阻塞发送：对端来不及接收时暂停读取本地连接，由TCP把压力传回游戏
+			if sendErr := s.sender.send(context.Background(), buf[:n]); sendErr != nil {
+				log.Printf("Tunnel send on '%s' failed: %v", s.dc.Label(), sendErr)
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	s.close()
+}
+
+// pumpDatagrams 从本地UDP连接读取数据报，每个数据报作为一条消息发送
+//
+// 不可靠通道上单个数据报发送失败不影响后续数据报。
+func (s *tunnelStream) pumpDatagrams(conn net.Conn) {
+	buf := make([]byte, udpReadBufferSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			break
+		}
+		if s.dc.ReadyState() != webrtc.DataChannelStateOpen {
+			break
+		}
+		// 非阻塞发送：积压时丢弃数据报而不是让延迟越来越大（游戏会重传）
+		s.trySendDatagram(buf[:n])
+	}
+	s.close()
+}
+
+// pumpToConn 把数据通道收到的数据写入本地连接
+func (s *tunnelStream) pumpToConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		select {
+		case data := <-s.writes:
+			if _, err := conn.Write(data); err != nil {
+				log.Printf("Tunnel write on '%s' failed: %v", s.dc.Label(), err)
+				s.close()
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// handleDataChannel 主机端处理对端打开的隧道数据通道
+func (t *Tunnel) handleDataChannel(dc *webrtc.DataChannel) {
+	label := dc.Label()
+
+	var network string
+	switch {
+	case strings.HasPrefix(label, tunnelTCPLabelPrefix):
+		network = "tcp"
+	case strings.HasPrefix(label, tunnelUDPLabelPrefix):
+		network = "udp"
+	default:
+		log.Printf("Unknown data channel '%s', closing it", label)
+		dc.Close()
+		return
+	}
+
+	if t.isClosed() {
+		dc.Close()
+		return
+	}
+
+	s := t.newStream(dc)
+
+	// 在OnDataChannel回调之外连接游戏服务器，避免阻塞其他数据通道
+	go func() {
+		conn, err := net.DialTimeout(network, t.config.GameAddr, tunnelDialTimeout)
+		if err != nil {
+			log.Printf("Tunnel '%s': failed to reach game server %s: %v", label, t.config.GameAddr, err)
+			s.close()
+			return
+		}
+
+		log.Printf("Tunnel '%s' connected to game server %s/%s", label, t.config.GameAddr, network)
+		go s.pumpToConn(conn)
+		if network == "udp" {
+			s.pumpDatagrams(conn)
+		} else {
+			s.pumpToChannel(conn)
+		}
+		conn.Close()
+	}()
+}
+
+// acceptTCP 客户端接受游戏的TCP连接
+func (t *Tunnel) acceptTCP() {
+	for {
+		conn, err := t.tcpListener.Accept()
+		if err != nil {
+			if !t.isClosed() {
+				log.Printf("Tunnel TCP listener stopped: %v", err)
+			}
+			return
+		}
+		go t.openTCPStream(conn)
+	}
+}
+
+// openTCPStream 为一个本地TCP连接打开隧道数据通道
+func (t *Tunnel) openTCPStream(conn net.Conn) {
+	label := t.newLabel(tunnelTCPLabelPrefix)
+	dc, err := t.connection.CreateDataChannel(label, nil)
+	if err != nil {
+		log.Printf("Failed to open tunnel channel for %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	log.Printf("Tunnel '%s' opened for game connection %s", label, conn.RemoteAddr())
+	s := t.newStream(dc)
+	dc.OnOpen(func() {
+		go s.pumpToConn(conn)
+		go s.pumpToChannel(conn)
+	})
+
+	// 通道关闭（包括打开失败）时释放本地连接
+	go func() {
+		<-s.done
+		conn.Close()
+	}()
+}
+
+// readUDP 客户端读取游戏发出的UDP数据报
+func (t *Tunnel) readUDP() {
+	t.mu.Lock()
+	udpConn := t.udpConn
+	discovery := t.discovery
+	t.mu.Unlock()
+
+	buf := make([]byte, udpReadBufferSize)
+	for {
+		n, addr, err := udpConn.ReadFromUDP(buf)
+		if err != nil {
+			if !t.isClosed() && !errors.Is(err, net.ErrClosed) {
+				log.Printf("Tunnel UDP listener stopped: %v", err)
+			}
+			return
+		}
+
+		if discovery != nil && discovery.HandlePacket(buf[:n], addr) {
+			continue
+		}
+
+		session, err := t.udpSession(addr)
+		if err != nil {
+			log.Printf("Failed to open UDP tunnel for %s: %v", addr, err)
+			continue
+		}
+		session.lastSeen.Store(time.Now().UnixNano())
+
+		if session.stream.dc.ReadyState() != webrtc.DataChannelStateOpen {
+			// 通道尚未打开，丢弃数据报（游戏会重传）
+			continue
+		}
+		session.stream.trySendDatagram(buf[:n])
+	}
+}
+
+// udpSession 获取或创建UDP来源地址对应的会话
+func (t *Tunnel) udpSession(addr *net.UDPAddr) (*udpSession, error) {
+	key := addr.String()
+
+	t.mu.Lock()
+	session, exists := t.udpSessions[key]
+	t.mu.Unlock()
+	if exists {
+		return session, nil
+	}
+
+	label := t.newLabel(tunnelUDPLabelPrefix)
+	dc, err := t.connection.CreateDataChannel(label, t.config.udpChannelInit())
+	if err != nil {
+		return nil, err
+	}
+
+	session = &udpSession{
+		stream: t.newStream(dc),
+		addr:   addr,
+	}
+	session.lastSeen.Store(time.Now().UnixNano())
+
+	// 对端返回的数据报写回游戏的来源地址
+	go func() {
+		for {
+			select {
+			case data := <-session.stream.writes:
+				if _, err := t.udpConn.WriteToUDP(data, addr); err != nil {
+					log.Printf("Tunnel UDP write to %s failed: %v", addr, err)
+				}
+			case <-session.stream.done:
+				t.mu.Lock()
+				if t.udpSessions[key] == session {
+					delete(t.udpSessions, key)
+				}
+				t.mu.Unlock()
+				return
+			}
+		}
+	}()
+
+	t.mu.Lock()
+	t.udpSessions[key] = session
+	t.mu.Unlock()
+
+	log.Printf("Tunnel '%s' opened for game endpoint %s/udp (%s)", label, addr, t.config.UDPMode)
+	return session, nil
+}
+
+// expireUDPSessions 清理空闲的UDP会话
+func (t *Tunnel) expireUDPSessions() {
+	ticker := time.NewTicker(udpSessionIdleTimeout / 4)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if t.isClosed() {
+			return
+		}
+
+		now := time.Now().UnixNano()
+		var idle []*udpSession
+
+		t.mu.Lock()
+		for key, session := range t.udpSessions {
+			if time.Duration(now-session.lastSeen.Load()) > udpSessionIdleTimeout {
+				idle = append(idle, session)
+				delete(t.udpSessions, key)
+			}
+		}
+		t.mu.Unlock()
+
+		for _, session := range idle {
+			log.Printf("Closing idle UDP tunnel for %s", session.addr)
+			session.stream.close()
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Package e2e 端到端测试环境
+//
+// 在进程内启动信令服务器（httptest），主机和客户端的P2PConnector通过pion虚拟网络连接，
+// 可以模拟NAT、延迟和丢包，不需要单独运行信令服务器或访问公网STUN：
+//
+//	h, err := e2e.New(e2e.Options{Host: e2e.Conditions{NAT: e2e.SymmetricNAT}, Relay: true})
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer h.Close()
+//
+//	joiner, err := h.AddJoiner(e2e.Conditions{NAT: e2e.SymmetricNAT, Latency: 50 * time.Millisecond, Loss: 0.02})
+//	...
+//	if err := h.WaitConnected(ctx); err != nil {
+//		t.Fatal(err)
+//	}
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/pion/turn/v2"
+	"github.com/pion/webrtc/v3"
+	"github.com/submlit21/stardewl-ink/core"
+	"github.com/submlit21/stardewl-ink/signaling/server"
+)
+
+// Options 测试环境配置
+type Options struct {
+	// Host 主机一端的网络条件
+	Host Conditions
+	// Relay 启用信令服务器的内置TURN并向各端签发凭据（两端都是对称NAT时只能经中继连通）
+	Relay bool
+	// Configure 创建每个P2PConnector之前调整其配置（隧道、心跳、流量控制等），可为nil
+	Configure func(config *core.P2PConfig)
+}
+
+// Harness 进程内的端到端测试环境：信令服务器、虚拟网络、主机和客户端
+type Harness struct {
+	// SignalingURL 进程内信令服务器的WebSocket地址
+	SignalingURL string
+	// RoomID 房间连接码
+	RoomID string
+	// Host 主机的连接器
+	Host *core.P2PConnector
+
+	options   Options
+	signaling *server.Server
+	turn      *turn.Server
+	listener  *httptest.Server
+	network   *network
+	joiners   []*core.P2PConnector
+	mu        sync.Mutex
+}
+
+// New 启动信令服务器和虚拟网络，创建房间并启动主机
+func New(options Options) (*Harness, error) {
+	network, err := newNetwork()
+	if err != nil {
+		return nil, err
+	}
+
+	// 内置TURN总是在虚拟网络上运行并应答STUN，Relay只决定是否签发中继凭据
+	turnConfig := server.TURNConfig{
+		Enabled:  options.Relay,
+		Port:     serverPort,
+		PublicIP: serverIP,
+		Realm:    "stardewl",
+	}
+	if err := turnConfig.Prepare(); err != nil {
+		network.close()
+		return nil, err
+	}
+
+	signaling := server.New(turnConfig)
+	turnServer, err := signaling.ServeTURN(network.turnListener, network.serverNet)
+	if err != nil {
+		signaling.Close()
+		network.close()
+		return nil, fmt.Errorf("failed to start TURN server: %w", err)
+	}
+	listener := httptest.NewServer(signaling.Handler())
+
+	h := &Harness{
+		SignalingURL: "ws" + strings.TrimPrefix(listener.URL, "http") + "/ws",
+		options:      options,
+		signaling:    signaling,
+		turn:         turnServer,
+		listener:     listener,
+		network:      network,
+	}
+
+	// 主机用/create签发的ICE服务器，客户端用connected消息中下发的（与命令行客户端相同）
+	var issued []webrtc.ICEServer
+	h.RoomID, issued, err = h.createRoom()
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+
+	h.Host, err = h.newConnector(true, options.Host, issued)
+	if err != nil {
+		h.Close()
+		return nil, fmt.Errorf("failed to start host: %w", err)
+	}
+
+	return h, nil
+}
+
+// AddJoiner 在新的NAT路由器后面启动一个客户端并加入房间
+func (h *Harness) AddJoiner(conditions Conditions) (*core.P2PConnector, error) {
+	joiner, err := h.newConnector(false, conditions, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start joiner: %w", err)
+	}
+
+	h.mu.Lock()
+	h.joiners = append(h.joiners, joiner)
+	h.mu.Unlock()
+
+	return joiner, nil
+}
+
+// Joiners 已加入的客户端
+func (h *Harness) Joiners() []*core.P2PConnector {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]*core.P2PConnector(nil), h.joiners...)
+}
+
+// WaitConnected 等待主机与所有客户端的控制通道都已打开，直到ctx结束
+func (h *Harness) WaitConnected(ctx context.Context) error {
+	joiners := h.Joiners()
+
+	if err := h.Host.WaitForPeers(ctx, len(joiners)); err != nil {
+		return fmt.Errorf("host: %w", err)
+	}
+	for i, joiner := range joiners {
+		if err := joiner.WaitForPeers(ctx, 1); err != nil {
+			return fmt.Errorf("joiner %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Close 关闭所有连接器、信令服务器和虚拟网络
+func (h *Harness) Close() {
+	for _, joiner := range h.Joiners() {
+		joiner.Close()
+	}
+	if h.Host != nil {
+		h.Host.Close()
+	}
+
+	h.signaling.Close()
+	h.listener.Close()
+	h.turn.Close()
+	h.network.close()
+}
+
+// createRoom 在进程内信令服务器上创建房间，返回连接码和为房间签发的ICE服务器
+func (h *Harness) createRoom() (string, []webrtc.ICEServer, error) {
+	resp, err := http.Post(h.listener.URL+"/create", "application/json", nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create room: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("failed to create room, status: %d", resp.StatusCode)
+	}
+
+	var room struct {
+		Code       string             `json:"code"`
+		ICEServers []webrtc.ICEServer `json:"ice_servers"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&room); err != nil {
+		return "", nil, fmt.Errorf("failed to parse room response: %w", err)
+	}
+	return room.Code, room.ICEServers, nil
+}
+
+// newConnector 在新的虚拟网络站点上创建并启动连接器，issued为信令服务器签发的ICE服务器
+func (h *Harness) newConnector(isHost bool, conditions Conditions, issued []webrtc.ICEServer) (*core.P2PConnector, error) {
+	siteNet, err := h.network.addSite(conditions)
+	if err != nil {
+		return nil, err
+	}
+
+	config := core.P2PConfig{
+		SignalingURL: h.SignalingURL,
+		RoomID:       h.RoomID,
+		IsHost:       isHost,
+		ICEServers:   core.MergeICEServers(issued, h.network.iceServers()),
+		ICE: core.ICEConfig{
+			// vnet只支持UDP over IPv4
+			NetworkTypes: []webrtc.NetworkType{webrtc.NetworkTypeUDP4},
+			Net:          siteNet,
+		},
+	}
+	if h.options.Configure != nil {
+		h.options.Configure(&config)
+	}
+
+	connector, err := core.NewP2PConnector(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := connector.Start(); err != nil {
+		connector.Close()
+		return nil, err
+	}
+	return connector, nil
+}
@@ -0,0 +1,156 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/submlit21/stardewl-ink/core"
+)
+
+// startPair 启动主机和一个客户端并等待握手完成
+func startPair(t *testing.T, options Options, joiner Conditions) (*Harness, *core.P2PConnector) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping end-to-end test in short mode")
+	}
+
+	h, err := New(options)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(h.Close)
+
+	h.Host.HandleMethod("test.echo", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		return params, nil
+	})
+
+	j, err := h.AddJoiner(joiner)
+	if err != nil {
+		t.Fatalf("AddJoiner: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := h.WaitConnected(ctx); err != nil {
+		t.Fatalf("WaitConnected: %v", err)
+	}
+	return h, j
+}
+
+// echo 经控制通道往返一次，返回耗时
+func echo(t *testing.T, j *core.P2PConnector, n int) time.Duration {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	result, err := j.Call(ctx, "host", "test.echo", n)
+	if err != nil {
+		t.Fatalf("echo %d: %v", n, err)
+	}
+	if string(result) != fmt.Sprint(n) {
+		t.Fatalf("echo %d returned %s", n, result)
+	}
+	return time.Since(start)
+}
+
+// candidatePath 客户端选中的连接路径
+func candidatePath(t *testing.T, j *core.P2PConnector) string {
+	t.Helper()
+
+	stats := j.Stats()
+	if len(stats) != 1 || stats[0].Connection.CandidatePair == nil {
+		t.Fatalf("no selected candidate pair: %+v", stats)
+	}
+	return stats[0].Connection.CandidatePair.Path()
+}
+
+// TestNoNAT 两端都有公网地址，直接连通
+func TestNoNAT(t *testing.T) {
+	_, j := startPair(t, Options{Host: Conditions{NAT: NoNAT}}, Conditions{NAT: NoNAT})
+
+	if path := candidatePath(t, j); path == core.PathRelay {
+		t.Fatalf("path %s, want a direct connection", path)
+	}
+	echo(t, j, 1)
+}
+
+// TestSymmetricNATRelay 两端都是对称NAT，只能经TURN中继连通
+func TestSymmetricNATRelay(t *testing.T) {
+	_, j := startPair(t, Options{Host: Conditions{NAT: SymmetricNAT}, Relay: true}, Conditions{NAT: SymmetricNAT})
+
+	if path := candidatePath(t, j); path != core.PathRelay {
+		t.Fatalf("path %s, want %s", path, core.PathRelay)
+	}
+	echo(t, j, 1)
+}
+
+// TestLossyHighLatencyLink 高延迟、丢包的链路上消息仍然按序可靠送达，往返时间反映模拟的延迟
+func TestLossyHighLatencyLink(t *testing.T) {
+	const latency = 100 * time.Millisecond
+	_, j := startPair(t, Options{Host: Conditions{NAT: PortRestrictedConeNAT}},
+		Conditions{NAT: PortRestrictedConeNAT, Latency: latency, Loss: 0.05})
+
+	// 延迟作用在客户端路由器的收发两个方向上
+	if rtt := echo(t, j, 0); rtt < 2*latency {
+		t.Fatalf("round trip %s, want at least %s", rtt, 2*latency)
+	}
+	for i := 1; i <= 20; i++ {
+		echo(t, j, i)
+	}
+}
+
+// TestRequestFloodRejected 对端同时发起的请求超过上限时，多出的请求立即得到unavailable
+func TestRequestFloodRejected(t *testing.T) {
+	h, j := startPair(t, Options{Host: Conditions{NAT: NoNAT}}, Conditions{NAT: NoNAT})
+
+	release := make(chan struct{})
+	h.Host.HandleMethod("test.block", func(ctx context.Context, params json.RawMessage) (interface{}, error) {
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return true, nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	const calls = 64
+	errs := make(chan error, calls)
+	for i := 0; i < calls; i++ {
+		go func() {
+			_, err := j.Call(ctx, "host", "test.block", nil)
+			errs <- err
+		}()
+	}
+
+	// 被拒绝的请求不等处理中的请求完成就返回
+	var rpcErr *core.RPCError
+	select {
+	case err := <-errs:
+		if !errors.As(err, &rpcErr) || rpcErr.Code != core.ErrorCodeUnavailable {
+			t.Fatalf("first result %v, want %s", err, core.ErrorCodeUnavailable)
+		}
+	case <-ctx.Done():
+		t.Fatal("no request was rejected")
+	}
+
+	close(release)
+	succeeded := 0
+	for i := 1; i < calls; i++ {
+		if err := <-errs; err == nil {
+			succeeded++
+		} else if !errors.As(err, &rpcErr) || rpcErr.Code != core.ErrorCodeUnavailable {
+			t.Fatalf("call failed: %v", err)
+		}
+	}
+	if succeeded == 0 || succeeded == calls-1 {
+		t.Fatalf("%d of %d calls succeeded, want some accepted and some rejected", succeeded, calls)
+	}
+}
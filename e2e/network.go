@@ -0,0 +1,212 @@
+package e2e
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/transport/v2/vnet"
+	"github.com/pion/webrtc/v3"
+)
+
+// NAT 模拟的NAT类型（RFC 4787的映射/过滤行为）
+type NAT int
+
+const (
+	// NoNAT 1:1映射的公网地址，任何外部地址都能直接访问
+	NoNAT NAT = iota
+	// FullConeNAT 映射和过滤都与目的地址无关
+	FullConeNAT
+	// RestrictedConeNAT 映射与目的地址无关，只接受访问过的IP发来的包
+	RestrictedConeNAT
+	// PortRestrictedConeNAT 映射与目的地址无关，只接受访问过的IP:端口发来的包（多数家用路由器）
+	PortRestrictedConeNAT
+	// SymmetricNAT 每个目的IP:端口使用不同的映射，两端都是对称NAT时只能经TURN中继
+	SymmetricNAT
+)
+
+// String NAT类型名称
+func (n NAT) String() string {
+	switch n {
+	case NoNAT:
+		return "none"
+	case FullConeNAT:
+		return "full-cone"
+	case RestrictedConeNAT:
+		return "restricted-cone"
+	case PortRestrictedConeNAT:
+		return "port-restricted-cone"
+	case SymmetricNAT:
+		return "symmetric"
+	default:
+		return fmt.Sprintf("NAT(%d)", int(n))
+	}
+}
+
+// natType 对应的vnet NAT配置
+func (n NAT) natType() *vnet.NATType {
+	natType := &vnet.NATType{
+		Mode:            vnet.NATModeNormal,
+		MappingBehavior: vnet.EndpointIndependent,
+		MappingLifeTime: 30 * time.Second,
+	}
+
+	switch n {
+	case NoNAT:
+		natType.Mode = vnet.NATModeNAT1To1
+	case FullConeNAT:
+		natType.FilteringBehavior = vnet.EndpointIndependent
+	case RestrictedConeNAT:
+		natType.FilteringBehavior = vnet.EndpointAddrDependent
+	case SymmetricNAT:
+		natType.MappingBehavior = vnet.EndpointAddrPortDependent
+		natType.FilteringBehavior = vnet.EndpointAddrPortDependent
+	default:
+		natType.FilteringBehavior = vnet.EndpointAddrPortDependent
+	}
+	return natType
+}
+
+// Conditions 一端（主机或某个客户端）的网络条件
+//
+// 延迟和丢包作用在该端的NAT路由器上，收发两个方向都会经过，
+// 例如Latency为50ms时该端到服务器的往返时间增加100ms。
+type Conditions struct {
+	NAT NAT
+	// Latency 每个包经过路由器时增加的固定延迟
+	Latency time.Duration
+	// Jitter 每批包额外增加的随机延迟上限
+	Jitter time.Duration
+	// Loss 丢包率（0~1）
+	Loss float64
+}
+
+// 虚拟网络中的STUN/TURN服务器地址
+const (
+	// serverIP WAN上信令服务器内置STUN/TURN的地址，也是中继地址
+	serverIP = "1.2.3.4"
+	// serverPort STUN/TURN端口
+	serverPort = 3478
+)
+
+// network 虚拟网络：WAN上是信令服务器的内置STUN/TURN，每个参与方在自己的NAT路由器后面
+//
+// 第i个参与方的内网地址为10.0.i.2，公网地址为203.0.113.i。
+type network struct {
+	wan *vnet.Router
+	// serverNet 服务器所在的WAN网络，中继套接字也在这里分配
+	serverNet *vnet.Net
+	// turnListener 服务器的STUN/TURN监听，交给信令服务器的ServeTURN
+	turnListener  net.PacketConn
+	loggerFactory logging.LoggerFactory
+	sites         int
+	mu            sync.Mutex
+}
+
+// newNetwork 创建并启动虚拟WAN，在服务器地址上打开STUN/TURN监听
+func newNetwork() (*network, error) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	wan, err := vnet.NewRouter(&vnet.RouterConfig{
+		CIDR:          "0.0.0.0/0",
+		LoggerFactory: loggerFactory,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create WAN router: %w", err)
+	}
+
+	serverNet, err := vnet.NewNet(&vnet.NetConfig{StaticIPs: []string{serverIP}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create STUN/TURN server network: %w", err)
+	}
+	if err := wan.AddNet(serverNet); err != nil {
+		return nil, fmt.Errorf("failed to attach STUN/TURN server: %w", err)
+	}
+
+	if err := wan.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start WAN router: %w", err)
+	}
+
+	listener, err := serverNet.ListenPacket("udp4", fmt.Sprintf("%s:%d", serverIP, serverPort))
+	if err != nil {
+		wan.Stop()
+		return nil, fmt.Errorf("failed to listen on %s:%d: %w", serverIP, serverPort, err)
+	}
+
+	return &network{
+		wan:           wan,
+		serverNet:     serverNet,
+		turnListener:  listener,
+		loggerFactory: loggerFactory,
+	}, nil
+}
+
+// iceServers 虚拟网络中的STUN服务器；TURN服务器和凭据由信令服务器签发
+func (n *network) iceServers() []webrtc.ICEServer {
+	return []webrtc.ICEServer{{URLs: []string{fmt.Sprintf("stun:%s:%d", serverIP, serverPort)}}}
+}
+
+// addSite 在WAN上添加一个按conditions配置的NAT路由器，返回其后面的主机网络
+func (n *network) addSite(conditions Conditions) (*vnet.Net, error) {
+	n.mu.Lock()
+	n.sites++
+	site := n.sites
+	n.mu.Unlock()
+
+	if site > 254 {
+		return nil, fmt.Errorf("too many peers in virtual network")
+	}
+
+	localIP := fmt.Sprintf("10.0.%d.2", site)
+	publicIP := fmt.Sprintf("203.0.113.%d", site)
+	if conditions.NAT == NoNAT {
+		// 1:1 NAT的静态地址格式为"公网地址/内网地址"
+		publicIP += "/" + localIP
+	}
+
+	lan, err := vnet.NewRouter(&vnet.RouterConfig{
+		CIDR:          fmt.Sprintf("10.0.%d.0/24", site),
+		StaticIPs:     []string{publicIP},
+		NATType:       conditions.NAT.natType(),
+		MinDelay:      conditions.Latency,
+		MaxJitter:     conditions.Jitter,
+		LoggerFactory: n.loggerFactory,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create router for site %d: %w", site, err)
+	}
+
+	if conditions.Loss > 0 {
+		loss := conditions.Loss
+		lan.AddChunkFilter(func(vnet.Chunk) bool {
+			return rand.Float64() >= loss
+		})
+	}
+
+	hostNet, err := vnet.NewNet(&vnet.NetConfig{StaticIPs: []string{localIP}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create network for site %d: %w", site, err)
+	}
+	if err := lan.AddNet(hostNet); err != nil {
+		return nil, fmt.Errorf("failed to attach network for site %d: %w", site, err)
+	}
+	if err := n.wan.AddRouter(lan); err != nil {
+		return nil, fmt.Errorf("failed to attach router for site %d: %w", site, err)
+	}
+
+	// WAN已经启动，之后添加的路由器需要单独启动
+	if err := lan.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start router for site %d: %w", site, err)
+	}
+
+	return hostNet, nil
+}
+
+// close 停止虚拟网络
+func (n *network) close() {
+	n.turnListener.Close()
+	n.wan.Stop()
+}
@@ -0,0 +1,962 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	mathrand "math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Server 信令服务器：房间管理、WebSocket信令转发和TURN凭据签发
+//
+// 可以嵌入其他程序（例如端到端测试）在进程内运行，各Server之间状态互不影响。
+type Server struct {
+	upgrader websocket.Upgrader
+
+	// 房间管理
+	rooms map[string]*RoomInfo
+	
+	// 连接管理
+	connections map[string]*Connection
+	mu          sync.RWMutex
+
+	// turn TURN配置，用于签发下发给客户端的ICE服务器
+	turn *TURNConfig
+	// roomCreations 各来源IP最近创建房间的时间，用于限制创建频率（受mu保护）
+	roomCreations map[string][]time.Time
+	// turnRooms 签发过TURN凭据的房间及其最晚的凭据过期时间（受mu保护）
+	//
+	// 房间在P2P建立后就会删除，而中继要用到整局游戏结束，所以单独记录，过期后才清理。
+	// 记录只在内存中，服务器重启后之前签发的凭据不能再用于内置TURN。
+	turnRooms map[string]time.Time
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// New 创建信令服务器并启动过期连接清理；turn需已调用Prepare，未启用TURN时传零值
+func New(turn TURNConfig) *Server {
+	s := &Server{
+		upgrader: websocket.Upgrader{
+			CheckOrigin: func(r *http.Request) bool {
+				return true // 允许所有来源，生产环境应该限制
+			},
+		},
+		rooms:         make(map[string]*RoomInfo),
+		connections:   make(map[string]*Connection),
+		turn:          &turn,
+		roomCreations: make(map[string][]time.Time),
+		turnRooms:     make(map[string]time.Time),
+		done:          make(chan struct{}),
+	}
+
+	// 启动清理goroutine
+	go s.cleanupConnections()
+	return s
+}
+
+// Handler 信令服务器的HTTP路由
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", s.handleWebSocket)
+	mux.HandleFunc("/create", s.handleCreateRoom)
+	mux.HandleFunc("/join/", s.handleJoinRoom)
+	mux.HandleFunc("/health", s.handleHealth)
+	return mux
+}
+
+// Close 停止清理goroutine并断开所有WebSocket连接
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		for _, conn := range s.connections {
+			conn.conn.Close()
+		}
+	})
+}
+
+// resumeGracePeriod 客户端断开后保留其位置的时间，期间可凭恢复令牌回到原位置
+const resumeGracePeriod = 2 * time.Minute
+
+const (
+	// roomCreateLimit 每个来源IP在roomCreateWindow内最多创建的房间数
+	//
+	// 每个房间都能换到TURN凭据，限制创建频率使服务器不至于成为任意使用的开放中继。
+	roomCreateLimit  = 10
+	roomCreateWindow = time.Minute
+)
+
+// Connection 表示一个WebSocket连接
+type Connection struct {
+	conn     *websocket.Conn
+	roomID   string
+	clientID string
+	isHost   bool
+	lastSeen time.Time
+	// 多个客户端的转发可能同时写入同一连接（例如主机），需要串行化
+	writeMu sync.Mutex
+}
+
+// WriteJSON 串行写入JSON消息
+func (c *Connection) WriteJSON(v interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteJSON(v)
+}
+
+// RoomInfo 表示一个房间的信息
+type RoomInfo struct {
+	ID        string
+	CreatedAt time.Time
+	Host      *Connection
+	Clients   map[string]*Connection
+	// 缓存主机发送的消息，以便新连接的客户端能立即收到
+	PendingMessages []Message
+	// Sessions 客户端会话，按恢复令牌索引
+	Sessions map[string]*Session
+}
+
+// Session 客户端会话：断开后在宽限期内保留客户端ID
+type Session struct {
+	Token    string
+	ClientID string
+	// Conn 当前连接，断开期间为nil
+	Conn *Connection
+	// expiry 宽限期计时器，到期后通知主机客户端已离开
+	expiry *time.Timer
+}
+
+// Message 信令消息
+//
+// From 由服务器填写为发送者的客户端ID；To 指定接收者的客户端ID，
+// 为空时保持旧行为（主机消息发给所有客户端，客户端消息发给主机）。
+type Message struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+	From string          `json:"from,omitempty"`
+	To   string          `json:"to,omitempty"`
+}
+
+// OfferMessage Offer消息
+type OfferMessage struct {
+	ConnectionID string `json:"connection_id"`
+	SDP          string `json:"sdp"`
+}
+
+// AnswerMessage Answer消息
+type AnswerMessage struct {
+	ConnectionID string `json:"connection_id"`
+	SDP          string `json:"sdp"`
+}
+
+// ICECandidateMessage ICE候选消息
+type ICECandidateMessage struct {
+	ConnectionID string `json:"connection_id"`
+	Candidate    string `json:"candidate"`
+}
+
+// JoinRoomMessage 加入房间消息
+//
+// ResumeToken 为之前加入时下发的恢复令牌；SessionAlive 表示客户端的WebRTC会话仍然存在
+// （只是信令连接断开），主机据此决定保留还是重建对该客户端的连接。
+type JoinRoomMessage struct {
+	ConnectionID string `json:"connection_id"`
+	IsHost       bool   `json:"is_host"`
+	ResumeToken  string `json:"resume_token,omitempty"`
+	SessionAlive bool   `json:"session_alive,omitempty"`
+}
+
+// ConnectionCodeMessage 连接码消息，附带为该房间签发的ICE服务器
+type ConnectionCodeMessage struct {
+	Code       string      `json:"code"`
+	ICEServers []ICEServer `json:"ice_servers,omitempty"`
+}
+
+// ErrorMessage 错误消息
+type ErrorMessage struct {
+	Error string `json:"error"`
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Failed to upgrade connection: %v\n", err)
+		return
+	}
+	defer conn.Close()
+
+	// 读取连接ID
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		log.Printf("Failed to read connection ID: %v\n", err)
+		return
+	}
+
+	var joinMsg JoinRoomMessage
+	if err := json.Unmarshal(message, &joinMsg); err != nil {
+		sendError(conn, "Invalid join message")
+		return
+	}
+
+	connectionID := joinMsg.ConnectionID
+	if connectionID == "" {
+		sendError(conn, "Connection ID is required")
+		return
+	}
+
+	// 检查房间是否存在
+	s.mu.RLock()
+	room, roomExists := s.rooms[connectionID]
+	s.mu.RUnlock()
+	
+	if !roomExists {
+		sendError(conn, "Room not found")
+		return
+	}
+
+	// 如果是主机，检查是否已有主机
+	if joinMsg.IsHost {
+		s.mu.Lock()
+		if room.Host != nil {
+			s.mu.Unlock()
+			sendError(conn, "Room already has a host")
+			return
+		}
+		s.mu.Unlock()
+	}
+
+	// 注册连接
+	connection := &Connection{
+		conn:     conn,
+		roomID:   connectionID,
+		isHost:   joinMsg.IsHost,
+		lastSeen: time.Now(),
+	}
+
+	// 客户端凭恢复令牌回到原来的位置，否则分配新的客户端ID
+	var session *Session
+	resumed := false
+	if !joinMsg.IsHost {
+		session, resumed = s.claimSession(room, joinMsg.ResumeToken, connection)
+		if session == nil {
+			sendError(conn, "Failed to create session")
+			return
+		}
+		connection.clientID = session.ClientID
+	} else {
+		// 主机的客户端ID在房间内固定，主机重连后客户端仍能识别它的信令
+		connection.clientID = hostClientID(connectionID)
+	}
+	clientID := connection.clientID
+
+	// 先发送连接成功消息（携带客户端ID和恢复令牌），客户端据此识别发给自己的信令
+	connectedData := map[string]interface{}{
+		"status":    "connected",
+		"client_id": clientID,
+		"host_id":   hostClientID(connectionID),
+	}
+	if session != nil {
+		connectedData["resume_token"] = session.Token
+		connectedData["resumed"] = resumed
+	}
+	if servers := s.iceServersForRoom(connectionID); len(servers) > 0 {
+		connectedData["ice_servers"] = servers
+	}
+	data, _ := json.Marshal(connectedData)
+	successMsg := Message{
+		Type: "connected",
+		Data: data,
+	}
+	if err := conn.WriteJSON(successMsg); err != nil {
+		log.Printf("Failed to send connected message: %v\n", err)
+		if session != nil {
+			s.mu.Lock()
+			s.releaseSession(room, session, connection)
+			s.mu.Unlock()
+		}
+		return
+	}
+
+	// 持锁只登记连接并复制需要发送的内容，写WebSocket（可能很慢）放到锁外，
+	// 避免一个房间的重放阻塞整个服务器
+	var existingClients []string
+	var pending []Message
+	var host *Connection
+
+	s.mu.Lock()
+	// 添加到全局连接映射
+	s.connections[clientID] = connection
+	
+	// 添加到房间
+	if joinMsg.IsHost {
+		room.Host = connection
+		log.Printf("Host connected to room %s (clientID: %s)\n", connectionID, clientID)
+		
+		for existingID := range room.Clients {
+			existingClients = append(existingClients, existingID)
+		}
+	} else {
+		// 同一会话的旧连接（尚未检测到断开）被新连接取代
+		if previous, exists := room.Clients[clientID]; exists && previous != connection {
+			log.Printf("Client %s reconnected, replacing its previous connection", clientID)
+			previous.conn.Close()
+		}
+		room.Clients[clientID] = connection
+		if resumed {
+			log.Printf("Client resumed session in room %s (clientID: %s)\n", connectionID, clientID)
+		} else {
+			log.Printf("Client connected to room %s (clientID: %s)\n", connectionID, clientID)
+		}
+		
+		pending = append([]Message(nil), room.PendingMessages...)
+		host = room.Host
+	}
+	s.mu.Unlock()
+
+	if joinMsg.IsHost {
+		// 主机连接前已加入的客户端，逐个通知主机
+		for _, existingID := range existingClients {
+			notifyHostNewClient(connection, existingID, false, false)
+		}
+	} else {
+		// 发送缓存的pending消息给新客户端（分批发送，避免WebSocket过载）
+		log.Printf("Sending %d pending messages to new client", len(pending))
+		
+		// 分批发送，每条消息之间有点延迟
+		for i, msg := range pending {
+			log.Printf("  -> Sending pending message %d/%d: %s", i+1, len(pending), msg.Type)
+			
+			if err := connection.WriteJSON(msg); err != nil {
+				log.Printf("Failed to send pending message %d to client: %v", i+1, err)
+				break
+			}
+			
+			// 小延迟，避免WebSocket过载
+			time.Sleep(50 * time.Millisecond)
+		}
+		
+		// 如果有主机，通知主机有新客户端（主机会为该客户端单独发送offer）
+		if host != nil {
+			notifyHostNewClient(host, clientID, resumed, resumed && joinMsg.SessionAlive)
+		}
+	}
+
+	// 处理消息
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			log.Printf("Connection %s closed: %v\n", clientID, err)
+			break
+		}
+
+		connection.lastSeen = time.Now()
+
+		var msg Message
+		if err := json.Unmarshal(message, &msg); err != nil {
+			log.Printf("Failed to parse message: %v\n", err)
+			continue
+		}
+
+		s.handleMessage(connection, msg)
+	}
+
+	// 清理连接
+	var notifyClients []*Connection
+	s.mu.Lock()
+	// 客户端恢复会话后，同一客户端ID已登记为新连接，不能删掉
+	if s.connections[clientID] == connection {
+		delete(s.connections, clientID)
+	}
+	
+	// 从房间中移除
+	if room, exists := s.rooms[connectionID]; exists {
+		if joinMsg.IsHost {
+			room.Host = nil
+			log.Printf("Host disconnected from room %s\n", connectionID)
+			
+			for _, client := range room.Clients {
+				notifyClients = append(notifyClients, client)
+			}
+		} else if room.Clients[clientID] == connection {
+			delete(room.Clients, clientID)
+			log.Printf("Client disconnected from room %s, keeping its slot for %s\n", connectionID, resumeGracePeriod)
+			
+			// 宽限期内客户端可凭令牌恢复，到期后才通知主机客户端已离开
+			s.releaseSession(room, session, connection)
+		}
+		
+		// 房间为空且没有等待恢复的会话时清理房间，否则由会话过期时清理
+		if room.isEmpty() {
+			delete(s.rooms, connectionID)
+			log.Printf("Room %s cleaned up (empty)\n", connectionID)
+		}
+	}
+	
+	s.mu.Unlock()
+
+	// 通知所有客户端主机已断开
+	for _, client := range notifyClients {
+		msg := Message{
+			Type: "host_disconnected",
+			Data: json.RawMessage(`{}`),
+		}
+		if err := client.WriteJSON(msg); err != nil {
+			log.Printf("Failed to notify client about host disconnect: %v\n", err)
+		}
+	}
+	log.Printf("Connection removed: %s\n", clientID)
+}
+
+func (s *Server) handleMessage(conn *Connection, msg Message) {
+	// 标记发送者，接收方据此区分不同的农场帮手（忽略客户端自己填写的值）
+	msg.From = conn.clientID
+
+	switch msg.Type {
+	case "offer":
+		s.handleOffer(conn, msg)
+	case "answer":
+		s.handleAnswer(conn, msg)
+	case "ice_candidate":
+		s.handleICECandidate(conn, msg)
+	case "ice_restart":
+		// 客户端请求主机发起ICE重启（只有主机创建Offer）
+		log.Printf("Forwarding ICE restart request from %s in room %s", conn.clientID, conn.roomID)
+		s.forwardMessage(conn, msg, s.forwardToHost)
+	case "ping":
+		handlePing(conn)
+	default:
+		log.Printf("Unknown message type: %s\n", msg.Type)
+	}
+}
+
+func (s *Server) handleOffer(conn *Connection, msg Message) {
+	var offerMsg OfferMessage
+	if err := json.Unmarshal(msg.Data, &offerMsg); err != nil {
+		log.Printf("Failed to parse offer: %v\n", err)
+		return
+	}
+
+	log.Printf("Forwarding offer from %s (host: %v) to room %s", 
+		conn.clientID, conn.isHost, conn.roomID)
+	
+	// 转发给指定客户端，未指定时转发给房间内的其他客户端
+	s.forwardMessage(conn, msg, s.forwardToRoom)
+}
+
+func (s *Server) handleAnswer(conn *Connection, msg Message) {
+	var answerMsg AnswerMessage
+	if err := json.Unmarshal(msg.Data, &answerMsg); err != nil {
+		log.Printf("Failed to parse answer: %v\n", err)
+		return
+	}
+
+	log.Printf("Forwarding answer from %s (host: %v) to host in room %s", 
+		conn.clientID, conn.isHost, conn.roomID)
+	
+	// 转发给主机
+	s.forwardMessage(conn, msg, s.forwardToHost)
+}
+
+func (s *Server) handleICECandidate(conn *Connection, msg Message) {
+	var iceMsg ICECandidateMessage
+	if err := json.Unmarshal(msg.Data, &iceMsg); err != nil {
+		log.Printf("Failed to parse ICE candidate: %v\n", err)
+		return
+	}
+
+	log.Printf("Forwarding ICE candidate from %s (host: %v) in room %s", 
+		conn.clientID, conn.isHost, conn.roomID)
+	
+	// 转发给指定客户端，未指定时转发给房间内的其他客户端
+	s.forwardMessage(conn, msg, s.forwardToRoom)
+}
+
+func handlePing(conn *Connection) {
+	// 更新最后活跃时间
+	conn.lastSeen = time.Now()
+	
+	// 发送pong响应
+	pongMsg := Message{
+		Type: "pong",
+		Data: json.RawMessage(`{}`),
+	}
+	
+	if err := conn.WriteJSON(pongMsg); err != nil {
+		log.Printf("Failed to send pong: %v\n", err)
+	}
+}
+
+// forwardMessage 指定了接收者时只投递给该连接，否则使用旧的转发方式
+func (s *Server) forwardMessage(sender *Connection, msg Message, fallback func(string, *Connection, Message)) {
+	if msg.To == "" {
+		fallback(sender.roomID, sender, msg)
+		return
+	}
+	s.forwardToPeer(sender.roomID, sender, msg)
+}
+
+// forwardToPeer 投递给房间内指定客户端ID的连接（主机或客户端）
+func (s *Server) forwardToPeer(roomID string, sender *Connection, msg Message) {
+	// 客户端只能与主机交换信令，否则一个农场帮手可以向另一个的会话注入Offer或ICE候选
+	if !sender.isHost && msg.To != hostClientID(roomID) {
+		log.Printf("Dropping %s from client %s: clients may only signal the host", msg.Type, sender.clientID)
+		sendPeerError(sender, "Clients may only signal the host", msg.To)
+		return
+	}
+
+	s.mu.RLock()
+	var target *Connection
+	if room, exists := s.rooms[roomID]; exists {
+		if room.Host != nil && room.Host.clientID == msg.To {
+			target = room.Host
+		} else {
+			target = room.Clients[msg.To]
+		}
+	}
+	s.mu.RUnlock()
+
+	if target == nil || target == sender {
+		log.Printf("Dropping %s from %s: peer %s not in room %s", msg.Type, sender.clientID, msg.To, roomID)
+		sendPeerError(sender, "Peer not found", msg.To)
+		return
+	}
+
+	log.Printf("  -> Sending %s from %s to %s", msg.Type, sender.clientID, msg.To)
+	if err := target.WriteJSON(msg); err != nil {
+		log.Printf("Failed to forward message to %s: %v\n", msg.To, err)
+	}
+}
+
+// sendPeerError 告知发送者消息无法投递给指定客户端
+func sendPeerError(sender *Connection, errorMsg, clientID string) {
+	data, _ := json.Marshal(map[string]string{
+		"error":     errorMsg,
+		"client_id": clientID,
+	})
+	errMsg := Message{
+		Type: "error",
+		Data: data,
+	}
+	if err := sender.WriteJSON(errMsg); err != nil {
+		log.Printf("Failed to send error message: %v\n", err)
+	}
+}
+
+func (s *Server) forwardToRoom(roomID string, sender *Connection, msg Message) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	room, exists := s.rooms[roomID]
+	if !exists {
+		return
+	}
+
+	// 转发给房间内的所有其他连接
+	if sender.isHost {
+		// 如果是主机发送的，缓存消息以便新客户端连接时能收到
+		room.PendingMessages = append(room.PendingMessages, msg)
+		log.Printf("Cached %s message from host in room %s (total cached: %d)", 
+			msg.Type, roomID, len(room.PendingMessages))
+		
+		// 转发给所有已连接的客户端
+		log.Printf("Forwarding %s from host to %d clients in room %s", 
+			msg.Type, len(room.Clients), roomID)
+		
+		for clientID, client := range room.Clients {
+			if client.clientID != sender.clientID {
+				log.Printf("  -> Sending to client %s", clientID)
+				if err := client.WriteJSON(msg); err != nil {
+					log.Printf("Failed to forward message to client %s: %v\n", client.clientID, err)
+				}
+			}
+		}
+	} else {
+		// 如果是客户端发送的，转发给主机
+		log.Printf("Forwarding %s from client to host in room %s", msg.Type, roomID)
+		
+		if room.Host != nil && room.Host.clientID != sender.clientID {
+			log.Printf("  -> Sending to host %s", room.Host.clientID)
+			if err := room.Host.WriteJSON(msg); err != nil {
+				log.Printf("Failed to forward message to host %s: %v\n", room.Host.clientID, err)
+			}
+		}
+		// 也可以选择转发给其他客户端（如果需要P2P）
+	}
+}
+
+func (s *Server) forwardToHost(roomID string, sender *Connection, msg Message) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	room, exists := s.rooms[roomID]
+	if !exists {
+		return
+	}
+
+	// 转发给主机
+	if room.Host != nil && room.Host.clientID != sender.clientID {
+		if err := room.Host.WriteJSON(msg); err != nil {
+			log.Printf("Failed to forward message to host %s: %v\n", room.Host.clientID, err)
+		}
+	}
+}
+
+func (s *Server) handleCreateRoom(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if ip := remoteIP(r); !s.allowRoomCreation(ip) {
+		log.Printf("Room creation rate limit exceeded for %s\n", ip)
+		http.Error(w, "Too many rooms created, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	// 生成唯一的连接码
+	connectionID := s.generateUniqueConnectionCode()
+	
+	// 预创建房间（等待主机连接）
+	s.mu.Lock()
+	// 只创建房间记录，不创建连接
+	s.rooms[connectionID] = &RoomInfo{
+		ID:              connectionID,
+		CreatedAt:       time.Now(),
+		Host:            nil,
+		Clients:         make(map[string]*Connection),
+		PendingMessages: make([]Message, 0),
+		Sessions:        make(map[string]*Session),
+	}
+	s.mu.Unlock()
+	
+	response := ConnectionCodeMessage{
+		Code:       connectionID,
+		ICEServers: s.iceServersForRoom(connectionID),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+	
+	log.Printf("Room created: %s (waiting for host)\n", connectionID)
+}
+
+func (s *Server) handleJoinRoom(w http.ResponseWriter, r *http.Request) {
+	connectionID := r.URL.Path[len("/join/"):]
+	if connectionID == "" {
+		http.Error(w, "Connection ID is required", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.RLock()
+	room, exists := s.rooms[connectionID]
+	s.mu.RUnlock()
+
+	if !exists {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	// 检查房间是否有主机连接（房间可能已创建但主机还未连接）
+	s.mu.RLock()
+	hasHost := room.Host != nil
+	s.mu.RUnlock()
+	
+	response := map[string]interface{}{
+		"status": "room_exists",
+		"code":   connectionID,
+		"ready":  hasHost,  // 房间是否就绪（有主机连接）
+	}
+	
+	if !hasHost {
+		response["message"] = "Room exists but host not connected yet"
+	}
+	if servers := s.iceServersForRoom(connectionID); len(servers) > 0 {
+		response["ice_servers"] = servers
+	}
+
+	// 返回成功响应
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	connectionCount := len(s.connections)
+	s.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    "healthy",
+		"timestamp": time.Now().Unix(),
+		"connections": connectionCount,
+	})
+}
+
+// allowRoomCreation 记录一次房间创建，来源IP超过频率限制时返回false
+func (s *Server) allowRoomCreation(ip string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	recent := pruneRoomCreations(s.roomCreations[ip], now)
+	if len(recent) >= roomCreateLimit {
+		s.roomCreations[ip] = recent
+		return false
+	}
+	s.roomCreations[ip] = append(recent, now)
+	return true
+}
+
+// pruneRoomCreations 去掉窗口之外的创建记录
+func pruneRoomCreations(times []time.Time, now time.Time) []time.Time {
+	recent := times[:0]
+	for _, t := range times {
+		if now.Sub(t) < roomCreateWindow {
+			recent = append(recent, t)
+		}
+	}
+	return recent
+}
+
+// remoteIP 请求的来源IP（不信任X-Forwarded-For，反向代理后所有请求共享同一限额）
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (s *Server) generateUniqueConnectionCode() string {
+	// 生成6位数字连接码
+	mathrand.Seed(time.Now().UnixNano())
+	
+	for {
+		code := fmt.Sprintf("%06d", mathrand.Intn(1000000))
+		
+		// 检查是否已存在
+		s.mu.RLock()
+		_, exists := s.rooms[code]
+		s.mu.RUnlock()
+		
+		if !exists {
+			return code
+		}
+		
+		// 如果代码已存在，重试
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func sendError(conn *websocket.Conn, errorMsg string) {
+	msg := Message{
+		Type: "error",
+		Data: json.RawMessage(fmt.Sprintf(`{"error": "%s"}`, errorMsg)),
+	}
+	
+	if err := conn.WriteJSON(msg); err != nil {
+		log.Printf("Failed to send error message: %v\n", err)
+	}
+}
+
+// notifyHostNewClient 通知主机有新客户端连接
+//
+// resumed 表示客户端凭恢复令牌回到了原位置，主机应将其视为同一个对端；
+// sessionAlive 表示客户端的WebRTC会话仍然存在。
+func notifyHostNewClient(host *Connection, clientID string, resumed, sessionAlive bool) {
+	data, _ := json.Marshal(map[string]interface{}{
+		"client_id":     clientID,
+		"resumed":       resumed,
+		"session_alive": sessionAlive,
+	})
+	msg := Message{
+		Type: "client_connected",
+		Data: data,
+	}
+	
+	if err := host.WriteJSON(msg); err != nil {
+		log.Printf("Failed to notify host about new client: %v\n", err)
+	}
+}
+
+// claimSession 按恢复令牌找回客户端会话，令牌无效或已过期时创建新会话
+//
+// 返回的bool表示是否恢复了已有会话。
+func (s *Server) claimSession(room *RoomInfo, token string, conn *Connection) (*Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if token != "" {
+		if session, exists := room.Sessions[token]; exists {
+			if session.expiry != nil {
+				session.expiry.Stop()
+				session.expiry = nil
+			}
+			session.Conn = conn
+			return session, true
+		}
+		log.Printf("Unknown or expired resume token in room %s, assigning new client ID", room.ID)
+	}
+
+	token, err := generateResumeToken()
+	if err != nil {
+		log.Printf("Failed to generate resume token: %v\n", err)
+		return nil, false
+	}
+
+	session := &Session{
+		Token:    token,
+		ClientID: fmt.Sprintf("%s-%d", room.ID, time.Now().UnixNano()),
+		Conn:     conn,
+	}
+	room.Sessions[token] = session
+	return session, false
+}
+
+// releaseSession 连接断开后保留会话，宽限期到期仍未恢复则通知主机客户端已离开
+//
+// 调用方需持有mu。
+func (s *Server) releaseSession(room *RoomInfo, session *Session, conn *Connection) {
+	if session.Conn != conn {
+		return
+	}
+	session.Conn = nil
+
+	session.expiry = time.AfterFunc(resumeGracePeriod, func() {
+		s.mu.Lock()
+		// 已恢复或已被清理
+		if session.Conn != nil || room.Sessions[session.Token] != session {
+			s.mu.Unlock()
+			return
+		}
+		delete(room.Sessions, session.Token)
+		log.Printf("Session of client %s in room %s expired\n", session.ClientID, room.ID)
+
+		host := room.Host
+		if room.isEmpty() && s.rooms[room.ID] == room {
+			delete(s.rooms, room.ID)
+			log.Printf("Room %s cleaned up (last session expired)\n", room.ID)
+		}
+		s.mu.Unlock()
+
+		// 通知主机客户端已断开（在锁外写WebSocket）
+		if host != nil {
+			data, _ := json.Marshal(map[string]string{"client_id": session.ClientID})
+			msg := Message{
+				Type: "client_disconnected",
+				Data: data,
+			}
+			if err := host.WriteJSON(msg); err != nil {
+				log.Printf("Failed to notify host about client disconnect: %v\n", err)
+			}
+		}
+	})
+}
+
+// sessionFor 找到连接所属的会话
+//
+// 调用方需持有mu。
+func (room *RoomInfo) sessionFor(conn *Connection) *Session {
+	for _, session := range room.Sessions {
+		if session.Conn == conn {
+			return session
+		}
+	}
+	return nil
+}
+
+// isEmpty 房间没有主机、客户端，也没有等待恢复的会话
+//
+// 调用方需持有mu。
+func (room *RoomInfo) isEmpty() bool {
+	return room.Host == nil && len(room.Clients) == 0 && len(room.Sessions) == 0
+}
+
+// hostClientID 房间主机的客户端ID
+func hostClientID(roomID string) string {
+	return roomID + "-host"
+}
+
+// generateResumeToken 生成不可猜测的恢复令牌
+func generateResumeToken() (string, error) {
+	return randomHex(16)
+}
+
+func (s *Server) cleanupConnections() {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+
+		s.mu.Lock()
+		now := time.Now()
+		
+		// 清理过期的连接
+		for clientID, conn := range s.connections {
+			if now.Sub(conn.lastSeen) > 10*time.Minute {
+				conn.conn.Close()
+				delete(s.connections, clientID)
+				
+				// 从房间中移除
+				if room, exists := s.rooms[conn.roomID]; exists {
+					if conn.isHost {
+						room.Host = nil
+						log.Printf("Removed host from room %s: %s\n", conn.roomID, clientID)
+					} else {
+						delete(room.Clients, clientID)
+						log.Printf("Removed client from room %s: %s\n", conn.roomID, clientID)
+						if session := room.sessionFor(conn); session != nil {
+							s.releaseSession(room, session, conn)
+						}
+					}
+					
+					// 如果房间为空，清理房间
+					if room.isEmpty() {
+						delete(s.rooms, conn.roomID)
+						log.Printf("Cleaned up empty room: %s\n", conn.roomID)
+					}
+				}
+				
+				log.Printf("Cleaned up stale connection: %s\n", clientID)
+			}
+		}
+		
+		// 清理房间创建记录
+		for ip, times := range s.roomCreations {
+			if len(pruneRoomCreations(times, now)) == 0 {
+				delete(s.roomCreations, ip)
+			}
+		}
+
+		// 清理凭据均已过期的TURN房间记录
+		for roomID, expiry := range s.turnRooms {
+			if now.After(expiry) {
+				delete(s.turnRooms, roomID)
+			}
+		}
+		
+		// 清理过期的空房间
+		for roomID, room := range s.rooms {
+			if now.Sub(room.CreatedAt) > 30*time.Minute && room.isEmpty() {
+				delete(s.rooms, roomID)
+				log.Printf("Cleaned up expired empty room: %s\n", roomID)
+			}
+		}
+		
+		s.mu.Unlock()
+	}
+}
\ No newline at end of file
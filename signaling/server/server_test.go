@@ -0,0 +1,178 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testRoom 在测试服务器上创建房间，返回房间号
+func testRoom(t *testing.T, ts *httptest.Server) string {
+	t.Helper()
+	resp, err := http.Post(ts.URL+"/create", "application/json", nil)
+	if err != nil {
+		t.Fatalf("create room: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var created ConnectionCodeMessage
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("decode room: %v", err)
+	}
+	return created.Code
+}
+
+// testJoin 加入房间，返回WebSocket连接和connected消息的内容
+func testJoin(t *testing.T, ts *httptest.Server, join JoinRoomMessage) (*websocket.Conn, map[string]interface{}) {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if err := conn.WriteJSON(join); err != nil {
+		t.Fatalf("send join: %v", err)
+	}
+	msg := testRead(t, conn)
+	if msg.Type != "connected" {
+		t.Fatalf("join %s: got %s %s", join.ConnectionID, msg.Type, msg.Data)
+	}
+	var data map[string]interface{}
+	json.Unmarshal(msg.Data, &data)
+	return conn, data
+}
+
+// testRead 读取下一条消息，跳过client_connected通知
+func testRead(t *testing.T, conn *websocket.Conn) Message {
+	t.Helper()
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var msg Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if msg.Type != "client_connected" {
+			return msg
+		}
+	}
+}
+
+// TestClientCannotSignalOtherClient 农场帮手不能向另一个农场帮手投递信令
+func TestClientCannotSignalOtherClient(t *testing.T) {
+	s := New(TURNConfig{})
+	defer s.Close()
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	room := testRoom(t, ts)
+	host, hostData := testJoin(t, ts, JoinRoomMessage{ConnectionID: room, IsHost: true})
+	victim, victimData := testJoin(t, ts, JoinRoomMessage{ConnectionID: room})
+	attacker, attackerData := testJoin(t, ts, JoinRoomMessage{ConnectionID: room})
+
+	if victimData["host_id"] != hostData["client_id"] || attackerData["host_id"] != hostData["client_id"] {
+		t.Fatalf("clients were told host %v/%v, host is %v", victimData["host_id"], attackerData["host_id"], hostData["client_id"])
+	}
+
+	offer := Message{
+		Type: "offer",
+		Data: json.RawMessage(`{"offer": "v=0"}`),
+		To:   victimData["client_id"].(string),
+	}
+	if err := attacker.WriteJSON(offer); err != nil {
+		t.Fatalf("send offer: %v", err)
+	}
+	if reply := testRead(t, attacker); reply.Type != "error" {
+		t.Fatalf("attacker got %s, want error", reply.Type)
+	}
+
+	// 主机的信令照常送达，且受害者没有收到攻击者的Offer
+	offer.From = ""
+	if err := host.WriteJSON(offer); err != nil {
+		t.Fatalf("send offer: %v", err)
+	}
+	msg := testRead(t, victim)
+	if msg.Type != "offer" || msg.From != hostData["client_id"] {
+		t.Fatalf("victim got %s from %s, want offer from host", msg.Type, msg.From)
+	}
+
+	// 客户端仍可以指定主机为接收者
+	answer := Message{
+		Type: "answer",
+		Data: json.RawMessage(`{"answer": "v=0"}`),
+		To:   hostData["client_id"].(string),
+	}
+	if err := victim.WriteJSON(answer); err != nil {
+		t.Fatalf("send answer: %v", err)
+	}
+	if msg := testRead(t, host); msg.Type != "answer" || msg.From != victimData["client_id"] {
+		t.Fatalf("host got %s from %s, want answer from victim", msg.Type, msg.From)
+	}
+}
+
+// TestPeerNotFoundErrorIsValidJSON 接收者ID中的引号不会破坏错误消息
+func TestPeerNotFoundErrorIsValidJSON(t *testing.T) {
+	s := New(TURNConfig{})
+	defer s.Close()
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	room := testRoom(t, ts)
+	host, _ := testJoin(t, ts, JoinRoomMessage{ConnectionID: room, IsHost: true})
+
+	to := `x", "error": "injected`
+	if err := host.WriteJSON(Message{Type: "offer", Data: json.RawMessage(`{}`), To: to}); err != nil {
+		t.Fatalf("send offer: %v", err)
+	}
+
+	reply := testRead(t, host)
+	var data map[string]string
+	if err := json.Unmarshal(reply.Data, &data); err != nil {
+		t.Fatalf("error payload is not valid JSON: %v (%s)", err, reply.Data)
+	}
+	if data["error"] != "Peer not found" || data["client_id"] != to {
+		t.Fatalf("unexpected error payload %v", data)
+	}
+}
+
+// TestResumeAfterLastClientLeft 房间里唯一的客户端断开后，在宽限期内仍可凭令牌恢复
+func TestResumeAfterLastClientLeft(t *testing.T) {
+	s := New(TURNConfig{})
+	defer s.Close()
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	room := testRoom(t, ts)
+	conn, data := testJoin(t, ts, JoinRoomMessage{ConnectionID: room})
+	conn.Close()
+
+	// 等待服务器处理断开
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		s.mu.RLock()
+		info := s.rooms[room]
+		left := info == nil || len(info.Clients) == 0
+		s.mu.RUnlock()
+		if left {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server did not notice the disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, resumed := testJoin(t, ts, JoinRoomMessage{
+		ConnectionID: room,
+		ResumeToken:  data["resume_token"].(string),
+	})
+	if resumed["resumed"] != true || resumed["client_id"] != data["client_id"] {
+		t.Fatalf("rejoin got client %v (resumed: %v), want %v resumed", resumed["client_id"], resumed["resumed"], data["client_id"])
+	}
+}
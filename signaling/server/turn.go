@@ -0,0 +1,372 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pion/transport/v2"
+	"github.com/pion/turn/v2"
+)
+
+// TURNConfig TURN服务器配置
+//
+// 对称NAT或运营商级NAT下仅靠STUN无法打通，内置TURN让信令服务器同时充当中继；
+// 也可以只为外部coturn签发凭据（URLs + Secret）。
+type TURNConfig struct {
+	Enabled bool
+	// Port TURN监听端口（UDP和TCP）
+	Port int
+	// PublicIP 客户端访问TURN所用的公网IP，也是中继地址
+	PublicIP string
+	Realm    string
+	// Username/Password 静态凭据（不推荐），设置后不再签发临时凭据
+	Username string
+	Password string
+	// Secret 与coturn use-auth-secret相同的共享密钥，未设置时启动时随机生成
+	Secret string
+	// CredentialTTL 临时凭据的有效期（需覆盖整局游戏，TURN分配刷新时也要验证）
+	CredentialTTL time.Duration
+	// URLs 外部TURN服务器地址（逗号分隔），使用Secret签发凭据
+	URLs string
+	// RelayMinPort/RelayMaxPort 中继端口范围，为0时由系统分配
+	RelayMinPort int
+	RelayMaxPort int
+}
+
+// ICEServer 下发给客户端的ICE服务器（JSON格式与webrtc.ICEServer一致）
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// RegisterTURNFlags 注册TURN命令行参数，默认值取自环境变量
+func RegisterTURNFlags(fs *flag.FlagSet, cfg *TURNConfig) {
+	fs.BoolVar(&cfg.Enabled, "turn", envBool("TURN_ENABLED"), "Run an embedded TURN server (env TURN_ENABLED). Credentials go to every signaling session, so anyone who can reach this server can create a room and relay through it; room creation is rate-limited per IP")
+	fs.IntVar(&cfg.Port, "turn-port", envInt("TURN_PORT", 3478), "TURN listening port, UDP and TCP (env TURN_PORT)")
+	fs.StringVar(&cfg.PublicIP, "turn-public-ip", os.Getenv("TURN_PUBLIC_IP"), "Public IP advertised to clients and used for relays (env TURN_PUBLIC_IP, default: auto-detect)")
+	fs.StringVar(&cfg.Realm, "turn-realm", envString("TURN_REALM", "stardewl"), "TURN realm (env TURN_REALM)")
+	fs.StringVar(&cfg.Username, "turn-username", os.Getenv("TURN_USERNAME"), "Static TURN username, disables time-limited credentials (env TURN_USERNAME)")
+	fs.StringVar(&cfg.Password, "turn-password", os.Getenv("TURN_PASSWORD"), "Static TURN password (env TURN_PASSWORD)")
+	fs.StringVar(&cfg.Secret, "turn-secret", os.Getenv("TURN_SECRET"), "Shared secret for time-limited credentials, as coturn use-auth-secret (env TURN_SECRET, default: random)")
+	fs.DurationVar(&cfg.CredentialTTL, "turn-credential-ttl", envDuration("TURN_CREDENTIAL_TTL", 24*time.Hour), "Lifetime of issued TURN credentials (env TURN_CREDENTIAL_TTL)")
+	fs.StringVar(&cfg.URLs, "turn-urls", os.Getenv("TURN_URLS"), "External TURN URLs to issue credentials for, comma separated; like -turn, anyone who can reach this server gets relay credentials (env TURN_URLS)")
+	fs.IntVar(&cfg.RelayMinPort, "turn-relay-min-port", envInt("TURN_RELAY_MIN_PORT", 0), "Lowest relay port (env TURN_RELAY_MIN_PORT)")
+	fs.IntVar(&cfg.RelayMaxPort, "turn-relay-max-port", envInt("TURN_RELAY_MAX_PORT", 0), "Highest relay port (env TURN_RELAY_MAX_PORT)")
+}
+
+// staticCredentials 是否使用静态凭据
+func (cfg *TURNConfig) staticCredentials() bool {
+	return cfg.Username != "" && cfg.Password != ""
+}
+
+// Prepare 校验配置并补全默认值（公网IP、共享密钥）
+func (cfg *TURNConfig) Prepare() error {
+	if cfg.Enabled && cfg.PublicIP == "" {
+		ip, err := detectOutboundIP()
+		if err != nil {
+			return fmt.Errorf("failed to detect public IP, set -turn-public-ip: %w", err)
+		}
+		cfg.PublicIP = ip
+		log.Printf("TURN public IP not set, using %s (set -turn-public-ip if clients connect from the internet)", ip)
+	}
+
+	if cfg.URLs != "" && cfg.Secret == "" && !cfg.staticCredentials() {
+		return fmt.Errorf("-turn-urls requires -turn-secret (the coturn static-auth-secret)")
+	}
+
+	if (cfg.Enabled || cfg.URLs != "") && cfg.Secret == "" && !cfg.staticCredentials() {
+		secret, err := randomHex(32)
+		if err != nil {
+			return fmt.Errorf("failed to generate TURN secret: %w", err)
+		}
+		cfg.Secret = secret
+	}
+
+	if cfg.CredentialTTL <= 0 {
+		cfg.CredentialTTL = 24 * time.Hour
+	}
+	return nil
+}
+
+// StartTURN 按配置启动内置TURN服务器
+func (s *Server) StartTURN() (*turn.Server, error) {
+	cfg := s.turn
+	relayIP := net.ParseIP(cfg.PublicIP)
+	if relayIP == nil {
+		return nil, fmt.Errorf("invalid TURN public IP: %s", cfg.PublicIP)
+	}
+
+	udpListener, err := net.ListenPacket("udp4", fmt.Sprintf("0.0.0.0:%d", cfg.Port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on UDP port %d: %w", cfg.Port, err)
+	}
+
+	tcpListener, err := net.Listen("tcp4", fmt.Sprintf("0.0.0.0:%d", cfg.Port))
+	if err != nil {
+		udpListener.Close()
+		return nil, fmt.Errorf("failed to listen on TCP port %d: %w", cfg.Port, err)
+	}
+
+	server, err := s.newTURNServer(udpListener, tcpListener, relayIP, nil)
+	if err != nil {
+		udpListener.Close()
+		tcpListener.Close()
+		return nil, fmt.Errorf("failed to start TURN server: %w", err)
+	}
+
+	if cfg.staticCredentials() {
+		log.Printf("TURN server listening on port %d (public IP: %s, realm: %s, static credentials)", cfg.Port, cfg.PublicIP, cfg.Realm)
+	} else {
+		log.Printf("TURN server listening on port %d (public IP: %s, realm: %s, credentials valid for %s)", cfg.Port, cfg.PublicIP, cfg.Realm, cfg.CredentialTTL)
+	}
+
+	return server, nil
+}
+
+// ServeTURN 在调用方打开的UDP监听上运行内置TURN服务器（只有UDP），中继套接字在relayNet上分配
+//
+// 供嵌入信令服务器的程序使用，例如端到端测试在虚拟网络上运行中继；
+// 认证、凭据和中继权限与StartTURN相同，中继地址取自PublicIP。
+func (s *Server) ServeTURN(udpListener net.PacketConn, relayNet transport.Net) (*turn.Server, error) {
+	relayIP := net.ParseIP(s.turn.PublicIP)
+	if relayIP == nil {
+		return nil, fmt.Errorf("invalid TURN public IP: %s", s.turn.PublicIP)
+	}
+	return s.newTURNServer(udpListener, nil, relayIP, relayNet)
+}
+
+// newTURNServer 在已打开的监听上运行TURN服务器，tcpListener可为nil，relayNet为nil时使用系统网络
+func (s *Server) newTURNServer(udpListener net.PacketConn, tcpListener net.Listener, relayIP net.IP, relayNet transport.Net) (*turn.Server, error) {
+	cfg := s.turn
+	config := turn.ServerConfig{
+		Realm:       cfg.Realm,
+		AuthHandler: s.authenticateTURN,
+		PacketConnConfigs: []turn.PacketConnConfig{
+			{
+				PacketConn:            udpListener,
+				RelayAddressGenerator: relayAddressGenerator(cfg, relayIP, relayNet),
+				PermissionHandler:     turnPermissionHandler(relayIP),
+			},
+		},
+	}
+	if tcpListener != nil {
+		config.ListenerConfigs = []turn.ListenerConfig{
+			{
+				Listener:              tcpListener,
+				RelayAddressGenerator: relayAddressGenerator(cfg, relayIP, relayNet),
+				PermissionHandler:     turnPermissionHandler(relayIP),
+			},
+		}
+	}
+	return turn.NewServer(config)
+}
+
+// turnPermissionHandler 中继的对端只能是本服务器的中继地址或公网地址
+//
+// 双方都只能走中继时，对端地址是本服务器分配的另一个中继。未设置-turn-public-ip时
+// 自动检测到的中继IP可能是内网地址，因此中继IP总是放行，其余地址交给permitTURNPeer。
+func turnPermissionHandler(relayIP net.IP) func(net.Addr, net.IP) bool {
+	return func(clientAddr net.Addr, peerIP net.IP) bool {
+		return peerIP.Equal(relayIP) || permitTURNPeer(clientAddr, peerIP)
+	}
+}
+
+// permitTURNPeer 只允许中继到公网地址
+//
+// 凭据发给每个信令会话，不加限制时任何人都能借中继访问信令服务器所在网络的
+// 本机、内网和链路本地服务。游戏对端总是通过公网地址使用中继。
+func permitTURNPeer(clientAddr net.Addr, peerIP net.IP) bool {
+	if peerIP.IsLoopback() || peerIP.IsPrivate() || peerIP.IsUnspecified() ||
+		peerIP.IsLinkLocalUnicast() || peerIP.IsLinkLocalMulticast() ||
+		peerIP.IsInterfaceLocalMulticast() || peerIP.IsMulticast() ||
+		peerIP.Equal(net.IPv4bcast) {
+		log.Printf("TURN: refused permission for %s to reach %s", clientAddr, peerIP)
+		return false
+	}
+	return true
+}
+
+// authenticateTURN 内置TURN服务器的认证：返回用户的长期凭据密钥
+func (s *Server) authenticateTURN(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+	cfg := s.turn
+	if cfg.staticCredentials() {
+		if username != cfg.Username {
+			log.Printf("TURN: rejected unknown user %q from %s", username, srcAddr)
+			return nil, false
+		}
+		return turn.GenerateAuthKey(cfg.Username, realm, cfg.Password), true
+	}
+
+	now := time.Now()
+	password, err := verifyTURNCredential(cfg.Secret, username, now)
+	if err != nil {
+		log.Printf("TURN: rejected %s: %v", srcAddr, err)
+		return nil, false
+	}
+
+	// 凭据限定在本服务器签发过的房间：用的是turnRooms而不是rooms，P2P建立后双方会关闭
+	// 信令连接、房间随之删除，中继的刷新和权限请求在整局游戏中都要继续通过认证
+	_, roomID, _ := strings.Cut(username, ":")
+	s.mu.RLock()
+	expiry, issued := s.turnRooms[roomID]
+	s.mu.RUnlock()
+	if !issued || now.After(expiry) {
+		log.Printf("TURN: rejected %s: no credentials issued for room %s", srcAddr, roomID)
+		return nil, false
+	}
+
+	return turn.GenerateAuthKey(username, realm, password), true
+}
+
+// iceServersForRoom 生成下发给房间的ICE服务器并记录签发，未配置TURN时返回nil
+//
+// 在/create、/join/{code}和WebSocket的connected消息中调用，调用方不能持有mu。
+func (s *Server) iceServersForRoom(roomID string) []ICEServer {
+	cfg := s.turn
+	var urls []string
+	var servers []ICEServer
+
+	if cfg.Enabled {
+		host := net.JoinHostPort(cfg.PublicIP, strconv.Itoa(cfg.Port))
+		// TURN服务器同时应答STUN请求
+		servers = append(servers, ICEServer{URLs: []string{"stun:" + host}})
+		urls = append(urls, "turn:"+host+"?transport=udp", "turn:"+host+"?transport=tcp")
+	}
+	for _, url := range strings.Split(cfg.URLs, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	if len(urls) == 0 {
+		return servers
+	}
+
+	username, credential := cfg.Username, cfg.Password
+	if !cfg.staticCredentials() {
+		now := time.Now()
+		username, credential = issueTURNCredential(cfg.Secret, roomID, cfg.CredentialTTL, now)
+
+		s.mu.Lock()
+		s.turnRooms[roomID] = now.Add(cfg.CredentialTTL)
+		s.mu.Unlock()
+	}
+
+	return append(servers, ICEServer{
+		URLs:       urls,
+		Username:   username,
+		Credential: credential,
+	})
+}
+
+// issueTURNCredential 按coturn use-auth-secret规则签发临时凭据
+//
+// 用户名为"过期时间戳:房间号"，密码为base64(HMAC-SHA1(secret, 用户名))。
+// 内置TURN认证时还要求该房间在本服务器签发过凭据；外部coturn只校验签名和有效期。
+func issueTURNCredential(secret, roomID string, ttl time.Duration, now time.Time) (string, string) {
+	username := fmt.Sprintf("%d:%s", now.Add(ttl).Unix(), roomID)
+	return username, turnPassword(secret, username)
+}
+
+// verifyTURNCredential 校验临时凭据的用户名，返回对应的密码
+func verifyTURNCredential(secret, username string, now time.Time) (string, error) {
+	expiry, roomID, ok := strings.Cut(username, ":")
+	if !ok || roomID == "" {
+		return "", fmt.Errorf("malformed username %q", username)
+	}
+
+	timestamp, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("malformed username %q", username)
+	}
+	if now.Unix() > timestamp {
+		return "", fmt.Errorf("credential for room %s expired at %s", roomID, time.Unix(timestamp, 0).Format(time.RFC3339))
+	}
+
+	return turnPassword(secret, username), nil
+}
+
+// turnPassword 计算临时凭据的密码
+func turnPassword(secret, username string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// relayAddressGenerator 根据配置选择中继地址分配方式
+func relayAddressGenerator(cfg *TURNConfig, relayIP net.IP, relayNet transport.Net) turn.RelayAddressGenerator {
+	if cfg.RelayMinPort > 0 && cfg.RelayMaxPort >= cfg.RelayMinPort {
+		return &turn.RelayAddressGeneratorPortRange{
+			RelayAddress: relayIP,
+			Address:      "0.0.0.0",
+			MinPort:      uint16(cfg.RelayMinPort),
+			MaxPort:      uint16(cfg.RelayMaxPort),
+			Net:          relayNet,
+		}
+	}
+	return &turn.RelayAddressGeneratorStatic{
+		RelayAddress: relayIP,
+		Address:      "0.0.0.0",
+		Net:          relayNet,
+	}
+}
+
+// detectOutboundIP 获取默认路由所在网卡的IP（不发送任何数据）
+func detectOutboundIP() (string, error) {
+	conn, err := net.Dial("udp4", "8.8.8.8:80")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// randomHex 生成随机十六进制字符串
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// envString 读取环境变量，未设置时返回默认值
+func envString(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// envInt 读取整数环境变量，未设置或无效时返回默认值
+func envInt(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+// envDuration 读取时长环境变量（如"12h"），未设置或无效时返回默认值
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+// envBool 读取布尔环境变量
+func envBool(key string) bool {
+	value, _ := strconv.ParseBool(os.Getenv(key))
+	return value
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/turn/v2"
+)
+
+// TestTURNCredentialCoturnCompatible 凭据与coturn use-auth-secret的计算方式一致
+func TestTURNCredentialCoturnCompatible(t *testing.T) {
+	const secret = "north-of-pelican-town"
+	now := time.Unix(1700000000, 0)
+
+	username, credential := issueTURNCredential(secret, "123456", time.Hour, now)
+
+	// coturn：用户名为"<过期时间戳>:<任意>"，密码为base64(HMAC-SHA1(secret, 用户名))
+	wantUsername := fmt.Sprintf("%d:%s", now.Add(time.Hour).Unix(), "123456")
+	if username != wantUsername {
+		t.Fatalf("username %q, want %q", username, wantUsername)
+	}
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(wantUsername))
+	wantCredential := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	if credential != wantCredential {
+		t.Fatalf("credential %q, want %q", credential, wantCredential)
+	}
+
+	password, err := verifyTURNCredential(secret, username, now.Add(59*time.Minute))
+	if err != nil {
+		t.Fatalf("verify valid credential: %v", err)
+	}
+	if password != wantCredential {
+		t.Fatalf("verified password %q, want %q", password, wantCredential)
+	}
+
+	// 另一个密钥算出的密码不同，TURN服务器的消息完整性校验会失败
+	if other, _ := verifyTURNCredential("other-secret", username, now); other == wantCredential {
+		t.Fatal("different secret produced the same password")
+	}
+}
+
+// TestTURNCredentialRejected 过期和格式错误的用户名被拒绝
+func TestTURNCredentialRejected(t *testing.T) {
+	const secret = "secret"
+	now := time.Unix(1700000000, 0)
+	username, _ := issueTURNCredential(secret, "123456", time.Hour, now)
+
+	if _, err := verifyTURNCredential(secret, username, now.Add(time.Hour+time.Second)); err == nil {
+		t.Fatal("expired credential accepted")
+	}
+	for _, name := range []string{"", "123456", "abc:123456", fmt.Sprintf("%d:", now.Unix()+60)} {
+		if _, err := verifyTURNCredential(secret, name, now); err == nil {
+			t.Errorf("malformed username %q accepted", name)
+		}
+	}
+}
+
+// TestAuthenticateTURNWithoutRoom 房间删除后（P2P建立后信令连接关闭）凭据在有效期内仍然可用，
+// 但没有在本服务器签发过的房间号不能通过认证
+func TestAuthenticateTURNWithoutRoom(t *testing.T) {
+	cfg := TURNConfig{Secret: "secret", Realm: "stardewl", URLs: "turn:turn.example.com:3478", CredentialTTL: time.Hour}
+	s := New(cfg)
+	defer s.Close()
+
+	src := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000}
+	servers := s.iceServersForRoom("654321")
+	if len(servers) != 1 {
+		t.Fatalf("got %d ICE servers, want 1", len(servers))
+	}
+	username, credential := servers[0].Username, servers[0].Credential
+
+	key, ok := s.authenticateTURN(username, cfg.Realm, src)
+	if !ok {
+		t.Fatal("valid credential rejected after the room was removed")
+	}
+	if want := turn.GenerateAuthKey(username, cfg.Realm, credential); string(key) != string(want) {
+		t.Fatal("auth key does not match the issued credential")
+	}
+
+	expired, _ := issueTURNCredential(cfg.Secret, "654321", -time.Minute, time.Now())
+	if _, ok := s.authenticateTURN(expired, cfg.Realm, src); ok {
+		t.Fatal("expired credential accepted")
+	}
+
+	// 签名正确，但本服务器从未为这个房间签发凭据（例如共用密钥的其他服务器签发的）
+	unissued, _ := issueTURNCredential(cfg.Secret, "111111", time.Hour, time.Now())
+	if _, ok := s.authenticateTURN(unissued, cfg.Realm, src); ok {
+		t.Fatal("credential for a room without issued credentials accepted")
+	}
+}
+
+// TestRoomResponsesIssueCredentials /create和/join/{code}下发该房间的TURN凭据，且按来源IP限制创建频率
+func TestRoomResponsesIssueCredentials(t *testing.T) {
+	s := New(TURNConfig{Secret: "secret", Realm: "stardewl", URLs: "turn:turn.example.com:3478", CredentialTTL: time.Hour})
+	defer s.Close()
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	src := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000}
+	for i := 0; i < roomCreateLimit; i++ {
+		resp, err := http.Post(ts.URL+"/create", "application/json", nil)
+		if err != nil {
+			t.Fatalf("create room: %v", err)
+		}
+		var created ConnectionCodeMessage
+		err = json.NewDecoder(resp.Body).Decode(&created)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("decode room: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("create room %d: status %d", i+1, resp.StatusCode)
+		}
+		if len(created.ICEServers) != 1 || !strings.HasSuffix(created.ICEServers[0].Username, ":"+created.Code) {
+			t.Fatalf("/create issued %+v for room %s", created.ICEServers, created.Code)
+		}
+		if _, ok := s.authenticateTURN(created.ICEServers[0].Username, "stardewl", src); !ok {
+			t.Fatal("credential from /create rejected")
+		}
+	}
+
+	resp, err := http.Post(ts.URL+"/create", "application/json", nil)
+	if err != nil {
+		t.Fatalf("create room: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("status %d after %d rooms, want %d", resp.StatusCode, roomCreateLimit, http.StatusTooManyRequests)
+	}
+
+	// /join/{code}同样下发该房间的凭据
+	s.mu.Lock()
+	s.rooms["222222"] = &RoomInfo{ID: "222222", Clients: make(map[string]*Connection), Sessions: make(map[string]*Session)}
+	s.mu.Unlock()
+	resp, err = http.Get(ts.URL + "/join/222222")
+	if err != nil {
+		t.Fatalf("join room: %v", err)
+	}
+	var joined struct {
+		ICEServers []ICEServer `json:"ice_servers"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&joined)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decode join: %v", err)
+	}
+	if len(joined.ICEServers) != 1 || !strings.HasSuffix(joined.ICEServers[0].Username, ":222222") {
+		t.Fatalf("/join issued %+v", joined.ICEServers)
+	}
+}
+
+// startTestTURN 在本机回环地址上运行服务器的内置TURN，中继地址为127.0.0.1
+func startTestTURN(t *testing.T, s *Server) string {
+	t.Helper()
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen udp: %v", err)
+	}
+	tcpListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen tcp: %v", err)
+	}
+	turnServer, err := s.newTURNServer(udpListener, tcpListener, net.IPv4(127, 0, 0, 1), nil)
+	if err != nil {
+		t.Fatalf("start TURN: %v", err)
+	}
+	t.Cleanup(func() { turnServer.Close() })
+	return udpListener.LocalAddr().String()
+}
+
+// testTURNClient 用为房间签发的临时凭据连接TURN服务器并分配中继
+func testTURNClient(t *testing.T, s *Server, addr, roomID string) (*turn.Client, net.PacketConn) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen client: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	servers := s.iceServersForRoom(roomID)
+	issued := servers[len(servers)-1]
+	client, err := turn.NewClient(&turn.ClientConfig{
+		TURNServerAddr: addr,
+		Username:       issued.Username,
+		Password:       issued.Credential,
+		Realm:          s.turn.Realm,
+		Conn:           conn,
+	})
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	t.Cleanup(client.Close)
+	if err := client.Listen(); err != nil {
+		t.Fatalf("client listen: %v", err)
+	}
+
+	relay, err := client.Allocate()
+	if err != nil {
+		t.Fatalf("allocate: %v", err)
+	}
+	t.Cleanup(func() { relay.Close() })
+	return client, relay
+}
+
+// TestTURNRefusesInternalPeers 中继不能用来访问信令服务器所在网络的本机和内网地址
+func TestTURNRefusesInternalPeers(t *testing.T) {
+	s := New(TURNConfig{Enabled: true, PublicIP: "127.0.0.1", Realm: "stardewl", Secret: "secret", CredentialTTL: time.Hour})
+	defer s.Close()
+
+	client, _ := testTURNClient(t, s, startTestTURN(t, s), "123456")
+
+	// 127.0.0.1是中继地址本身，总是放行；同网段的其他回环地址仍被拒绝
+	for _, ip := range []string{"127.0.0.2", "10.0.0.1", "192.168.1.1", "169.254.169.254", "0.0.0.0", "224.0.0.1"} {
+		if err := client.CreatePermission(&net.UDPAddr{IP: net.ParseIP(ip), Port: 24642}); err == nil {
+			t.Errorf("permission to %s granted", ip)
+		}
+	}
+
+	if err := client.CreatePermission(&net.UDPAddr{IP: net.ParseIP("203.0.113.7"), Port: 24642}); err != nil {
+		t.Fatalf("permission to a public peer refused: %v", err)
+	}
+}
+
+// TestTURNRelayToRelay 双方都只能走中继时，两个中继之间可以互通，即使中继地址不是公网地址
+func TestTURNRelayToRelay(t *testing.T) {
+	s := New(TURNConfig{Enabled: true, PublicIP: "127.0.0.1", Realm: "stardewl", Secret: "secret", CredentialTTL: time.Hour})
+	defer s.Close()
+
+	addr := startTestTURN(t, s)
+	hostClient, hostRelay := testTURNClient(t, s, addr, "123456")
+	joinClient, joinRelay := testTURNClient(t, s, addr, "123456")
+
+	if err := hostClient.CreatePermission(joinRelay.LocalAddr()); err != nil {
+		t.Fatalf("host permission to the other relay refused: %v", err)
+	}
+	if err := joinClient.CreatePermission(hostRelay.LocalAddr()); err != nil {
+		t.Fatalf("client permission to the other relay refused: %v", err)
+	}
+
+	if _, err := hostRelay.WriteTo([]byte("hello farmhand"), joinRelay.LocalAddr()); err != nil {
+		t.Fatalf("send through relay: %v", err)
+	}
+
+	buf := make([]byte, 64)
+	joinRelay.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, from, err := joinRelay.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("receive through relay: %v", err)
+	}
+	if string(buf[:n]) != "hello farmhand" || from.String() != hostRelay.LocalAddr().String() {
+		t.Fatalf("got %q from %s, want hello farmhand from %s", buf[:n], from, hostRelay.LocalAddr())
+	}
+}